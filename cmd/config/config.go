@@ -24,6 +24,7 @@ func NewCommand() *cobra.Command {
 		Short: "Config subcommands",
 	}
 	cmd.AddCommand(NewInitCommand())
+	cmd.AddCommand(NewImportDiscoveredCommand())
 
 	return cmd
 }
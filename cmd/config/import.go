@@ -0,0 +1,260 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/srv/discover"
+)
+
+const (
+	DefaultNameTemplate = "device_{{.Slot}}"
+	// Ways to resolve conflicts between discovered devices and devices in config
+	ConflictSkip    = "skip"
+	ConflictUpdate  = "update"
+	ConflictReplace = "replace"
+)
+
+// importTemplateData is available in the device name template
+type importTemplateData struct {
+	Index    int // order of the device sorted by slot and serial
+	Slot     uint16
+	Crate    uint16
+	Serial   string
+	SerialID uint64
+}
+
+func NewImportDiscoveredCommand() *cobra.Command {
+	var fromApi, dryRun, includeOffline bool
+	var nameTemplate, onConflict string
+	var crate uint16
+	cmd := &cobra.Command{
+		Use:   "import-discovered",
+		Short: "Add discovered devices to config",
+		Long: `Add devices found by the discover server to config. Discovered devices match
+devices in config by serial, IP or generated name. On conflict the device in config
+is kept (skip), gets IP, serial and slot of the discovered device (update) or is
+replaced by a new device with default settings (replace). Devices which are offline
+are not imported unless --include-offline is given.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if onConflict != ConflictSkip && onConflict != ConflictUpdate && onConflict != ConflictReplace {
+				return fmt.Errorf("Wrong conflict resolution: %s. Must be one of: %s, %s, %s", onConflict, ConflictSkip, ConflictUpdate, ConflictReplace)
+			}
+			tmpl, err := template.New("name").Parse(nameTemplate)
+			if err != nil {
+				return err
+			}
+			cfg := config.NewDefaultConfig()
+			if err = cfg.Load(); err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+				cfg.Devices = nil
+			}
+
+			var descriptions []*layers.DeviceDescription
+			if fromApi {
+				descriptions, err = discover.GetDiscoveredDevices(cfg)
+			} else {
+				descriptions, err = readDiscoverDB(cfg)
+			}
+			if err != nil {
+				return err
+			}
+
+			changes, err := importDevices(cfg, descriptions, tmpl, crate, onConflict, includeOffline)
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				fmt.Println("Config is up to date")
+				return nil
+			}
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+			if dryRun {
+				fmt.Println("Dry run, config is not saved")
+				return nil
+			}
+			return cfg.Persist(true)
+		},
+	}
+	cmd.Flags().BoolVar(&fromApi, "api", false, "Get discovered devices from the running discover server instead of the discover database")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show changes without saving config")
+	cmd.Flags().StringVar(&nameTemplate, "name-template", DefaultNameTemplate, "Template of device names. Fields: .Index, .Slot, .Crate, .Serial, .SerialID")
+	cmd.Flags().StringVar(&onConflict, "on-conflict", ConflictSkip, "How to resolve conflicts with devices in config: skip, update or replace")
+	cmd.Flags().BoolVar(&includeOffline, "include-offline", false, "Import devices which are offline, e.g. powered off or swapped")
+	cmd.Flags().Uint16Var(&crate, "crate", config.DefaultDeviceInventoryCrateID, "Crate ID of imported devices")
+
+	return cmd
+}
+
+func readDiscoverDB(cfg *config.Config) ([]*layers.DeviceDescription, error) {
+	state, err := discover.NewReadOnlyState(context.Background(), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open discover database %s: %s. Use --api if the discover server is running", cfg.DiscoverDBPath(), err)
+	}
+	defer state.Close()
+	return state.GetAllDeviceDescriptions()
+}
+
+func discoveredIP(dd *layers.DeviceDescription) net.IP {
+	if dd.MasterIP == nil || dd.MasterIP.IsUnspecified() {
+		return dd.Address
+	}
+	return dd.MasterIP
+}
+
+func discoveredSerial(dd *layers.DeviceDescription) string {
+	if dd.SerialID == 0 && dd.SerialNumber != "" {
+		return dd.SerialNumber
+	}
+	return fmt.Sprintf("0x%x", dd.SerialID)
+}
+
+// importDevices merges discovered devices into config and returns the list of changes.
+// It fails if several discovered devices match the same device in config.
+func importDevices(cfg *config.Config, all []*layers.DeviceDescription, tmpl *template.Template, crate uint16, onConflict string, includeOffline bool) ([]string, error) {
+	var descriptions []*layers.DeviceDescription
+	for _, dd := range all {
+		if dd.Online || includeOffline {
+			descriptions = append(descriptions, dd)
+		}
+	}
+	sort.Slice(descriptions, func(i, j int) bool {
+		if descriptions[i].ChassisSlot != descriptions[j].ChassisSlot {
+			return descriptions[i].ChassisSlot < descriptions[j].ChassisSlot
+		}
+		return descriptions[i].SerialID < descriptions[j].SerialID
+	})
+	var changes []string
+	names := map[string]bool{}
+	// serials of discovered devices by index of the matching device in config
+	matched := map[int]string{}
+	for i, dd := range descriptions {
+		ip := discoveredIP(dd)
+		serial := discoveredSerial(dd)
+		var name bytes.Buffer
+		data := importTemplateData{Index: i, Slot: dd.ChassisSlot, Crate: crate, Serial: serial, SerialID: dd.SerialID}
+		if err := tmpl.Execute(&name, data); err != nil {
+			return nil, err
+		}
+		if names[name.String()] {
+			return nil, fmt.Errorf("Name template gives the same name %s to several devices, use .Index or .Serial", name.String())
+		}
+		names[name.String()] = true
+
+		imported := &config.Device{
+			Name:            name.String(),
+			IP:              &ip,
+			Serial:          serial,
+			DeviceInventory: &config.DeviceInventory{CrateID: crate, SlotID: uint8(dd.ChassisSlot)},
+			Channels:        config.NewDefaultChannelsSetup(),
+		}
+
+		index := findDevice(cfg, dd, imported)
+		if other, ok := matched[index]; ok {
+			return nil, fmt.Errorf("Discovered devices %s and %s both match device %s in config", other, serial, cfg.Devices[index].Name)
+		}
+		if index < 0 {
+			matched[len(cfg.Devices)] = serial
+			cfg.Devices = append(cfg.Devices, imported)
+			changes = append(changes, fmt.Sprintf("+ %s ip: %s serial: %s slot: %d", imported.Name, ip, serial, dd.ChassisSlot))
+			continue
+		}
+		matched[index] = serial
+		existing := cfg.Devices[index]
+		updated := *existing
+		updated.IP = imported.IP
+		updated.Serial = imported.Serial
+		inventory := config.DeviceInventory{CrateID: crate, SlotID: imported.SlotID}
+		if existing.DeviceInventory != nil {
+			inventory.CrateID = existing.CrateID
+		}
+		updated.DeviceInventory = &inventory
+		diff := deviceDiff(existing, &updated)
+		if len(diff) == 0 {
+			continue
+		}
+		switch onConflict {
+		case ConflictSkip:
+			changes = append(changes, fmt.Sprintf("! %s is kept: %s", existing.Name, strings.Join(diff, ", ")))
+		case ConflictUpdate:
+			cfg.Devices[index] = &updated
+			changes = append(changes, fmt.Sprintf("~ %s %s", existing.Name, strings.Join(diff, ", ")))
+		case ConflictReplace:
+			cfg.Devices[index] = imported
+			changes = append(changes, fmt.Sprintf("- %s", existing.Name))
+			changes = append(changes, fmt.Sprintf("+ %s ip: %s serial: %s slot: %d", imported.Name, ip, serial, dd.ChassisSlot))
+		}
+	}
+	return changes, nil
+}
+
+// findDevice returns the index of the device in config which matches the discovered device
+// by serial, IP or name, or -1 if there is no such device
+func findDevice(cfg *config.Config, dd *layers.DeviceDescription, imported *config.Device) int {
+	for i, device := range cfg.Devices {
+		if discover.MatchSerial(dd, device) {
+			return i
+		}
+	}
+	for i, device := range cfg.Devices {
+		if device.IP != nil && device.IP.Equal(*imported.IP) {
+			return i
+		}
+	}
+	for i, device := range cfg.Devices {
+		if device.Name == imported.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// deviceDiff returns descriptions of changed IP, serial and inventory
+func deviceDiff(existing, updated *config.Device) []string {
+	var diff []string
+	if existing.IP == nil || !existing.IP.Equal(*updated.IP) {
+		diff = append(diff, fmt.Sprintf("ip: %v -> %s", existing.IP, updated.IP))
+	}
+	if existing.Serial != updated.Serial {
+		diff = append(diff, fmt.Sprintf("serial: %q -> %q", existing.Serial, updated.Serial))
+	}
+	if existing.DeviceInventory == nil {
+		diff = append(diff, fmt.Sprintf("inventory: none -> crate: %d slot: %d", updated.CrateID, updated.SlotID))
+		return diff
+	}
+	if existing.SlotID != updated.SlotID {
+		diff = append(diff, fmt.Sprintf("slot: %d -> %d", existing.SlotID, updated.SlotID))
+	}
+	if existing.CrateID != updated.CrateID {
+		diff = append(diff, fmt.Sprintf("crate: %d -> %d", existing.CrateID, updated.CrateID))
+	}
+	return diff
+}
@@ -25,8 +25,11 @@ func NewInitCommand() *cobra.Command {
 		Use:   "init",
 		Short: "Create default config",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			config := config.NewDefaultConfig()
-			return config.Persist(false)
+			cfg := config.NewDefaultConfig()
+			for _, device := range cfg.Devices {
+				device.Channels = config.NewDefaultChannelsSetup()
+			}
+			return cfg.Persist(false)
 		},
 	}
 
@@ -0,0 +1,37 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package adcspi
+
+import (
+	"github.com/spf13/cobra"
+)
+
+const (
+	DeviceOptionName = "device"
+	AdcOptionName    = "adc"
+	RegOptionName    = "reg"
+	ValueOptionName  = "value"
+)
+
+func NewAdcSpiCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "adc-spi",
+		Short: "Read/write internal registers of ADC chips",
+	}
+	cmd.AddCommand(NewRegsCommand())
+	cmd.AddCommand(NewReadCommand())
+	cmd.AddCommand(NewWriteCommand())
+	return cmd
+}
@@ -0,0 +1,51 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package adcspi
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewReadCommand() *cobra.Command {
+	var device, reg string
+	var adc int
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "read",
+		Short: "Read internal register of ADC chip",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			value, err := apiClient.AdcSpiRead(device, adc, reg)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("ADC %d register state: %s (%s) = %s\n", value.Adc, value.Reg, value.Addr, value.Value)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+	cmd.Flags().IntVar(&adc, AdcOptionName, 0, "ADC chip index (starting from 0)")
+	cmd.Flags().StringVar(&reg, RegOptionName, "", "Register name or address (hexadecimal)")
+	cmd.MarkFlagRequired(RegOptionName)
+
+	return cmd
+}
@@ -0,0 +1,54 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package adcspi
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewRegsCommand() *cobra.Command {
+	var device string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "regs",
+		Short: "List internal registers of ADC chips installed on a device",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			chip, err := apiClient.AdcSpiChip(device)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("ADC chip: %s channels per chip: %d\n", chip.Name, chip.Channels)
+			for _, reg := range chip.Regs {
+				access := "rw"
+				if reg.ReadOnly {
+					access = "ro"
+				}
+				fmt.Printf("0x%02x %-18s %s %s\n", reg.Addr, reg.Name, access, reg.Description)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+
+	return cmd
+}
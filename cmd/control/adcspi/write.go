@@ -0,0 +1,46 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package adcspi
+
+import (
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewWriteCommand() *cobra.Command {
+	var device, reg, value string
+	var adc int
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "write",
+		Short: "Write value to internal register of ADC chip",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			return apiClient.AdcSpiWrite(device, adc, reg, value)
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+	cmd.Flags().IntVar(&adc, AdcOptionName, 0, "ADC chip index (starting from 0)")
+	cmd.Flags().StringVar(&reg, RegOptionName, "", "Register name or address (hexadecimal)")
+	cmd.MarkFlagRequired(RegOptionName)
+	cmd.Flags().StringVar(&value, ValueOptionName, "", "Register value (hexadecimal)")
+	cmd.MarkFlagRequired(ValueOptionName)
+
+	return cmd
+}
@@ -0,0 +1,99 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+func NewAlarmsCommand() *cobra.Command {
+	var history bool
+	var from, to, ack string
+	filter := &layers.AlarmFilter{}
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "alarms",
+		Short: "Show active alarms raised by the device health monitor",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			if ack != "" {
+				if filter.Device == "" {
+					return fmt.Errorf("Device is required to acknowledge the alarm")
+				}
+				return apiClient.AlarmAck(filter.Device, ack)
+			}
+			if !history {
+				alarms, err := apiClient.Alarms()
+				if err != nil {
+					return err
+				}
+				printAlarms(alarms)
+				return nil
+			}
+			var err error
+			if from != "" {
+				if filter.From, err = parseTime(from); err != nil {
+					return err
+				}
+			}
+			if to != "" {
+				if filter.To, err = parseTime(to); err != nil {
+					return err
+				}
+			}
+			alarms, err := apiClient.AlarmHistory(filter)
+			if err != nil {
+				return err
+			}
+			printAlarms(alarms)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&history, "history", false, "Show raised and cleared alarms")
+	cmd.Flags().StringVar(&ack, "ack", "", "Acknowledge the active alarm of the rule, the device is required")
+	cmd.Flags().StringVar(&from, "from", "", "Show alarms raised since the time (RFC3339, YYYY-MM-DD HH:MM:SS or YYYY-MM-DD)")
+	cmd.Flags().StringVar(&to, "to", "", "Show alarms raised until the time")
+	cmd.Flags().StringVar(&filter.Device, DeviceOptionName, "", "Device name")
+	cmd.Flags().IntVar(&filter.Limit, "limit", AuditLimitDefault, "Show only the latest alarms, 0 means all alarms")
+
+	return cmd
+}
+
+func printAlarms(alarms []*layers.Alarm) {
+	fmt.Printf("%-20s %-20s %-12s %-16s %-8s %s\n", "Raised", "Cleared", "Device", "Rule", "Severity", "Message")
+	for _, a := range alarms {
+		cleared := "-"
+		if a.Cleared != 0 {
+			cleared = formatAlarmTime(a.Cleared)
+		}
+		message := a.Message
+		if a.Acknowledged != 0 {
+			message += " (acknowledged)"
+		}
+		fmt.Printf("%-20s %-20s %-12s %-16s %-8s %s\n", formatAlarmTime(a.Raised), cleared, a.Device, a.Rule, a.Severity, message)
+	}
+}
+
+func formatAlarmTime(ms uint64) string {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
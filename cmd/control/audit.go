@@ -0,0 +1,90 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+const (
+	// AuditLimitDefault is how many latest records are shown by default
+	AuditLimitDefault = 50
+)
+
+func NewAuditCommand() *cobra.Command {
+	var from, to string
+	filter := &layers.AuditFilter{}
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show write operations done through the control API",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if from != "" {
+				if filter.From, err = parseTime(from); err != nil {
+					return err
+				}
+			}
+			if to != "" {
+				if filter.To, err = parseTime(to); err != nil {
+					return err
+				}
+			}
+			apiClient := command.NewApiClient(cfg)
+			records, err := apiClient.Audit(filter)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-20s %-16s %-20s %-20s %-12s %-6s %s\n", "Time", "Client", "Owner", "Operation", "Device", "Status", "Request")
+			for _, r := range records {
+				request := r.Request
+				if r.Error != "" {
+					request = fmt.Sprintf("%s error: %s", request, r.Error)
+				}
+				fmt.Printf("%-20s %-16s %-20s %-20s %-12s %-6d %s\n",
+					time.Unix(0, int64(r.Timestamp)*int64(time.Millisecond)).Format("2006-01-02 15:04:05"),
+					r.Client, r.Owner, r.Operation, r.Device, r.Status, request)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Show records since the time (RFC3339, YYYY-MM-DD HH:MM:SS or YYYY-MM-DD)")
+	cmd.Flags().StringVar(&to, "to", "", "Show records until the time")
+	cmd.Flags().StringVar(&filter.Device, DeviceOptionName, "", "Device name")
+	cmd.Flags().StringVar(&filter.Owner, "owner", "", "Lease owner")
+	cmd.Flags().StringVar(&filter.Operation, "operation", "", "Operation, e.g. 'reg write' or 'mstream start'")
+	cmd.Flags().StringVar(&filter.Register, "register", "", "Written register address, e.g. 0x0040")
+	cmd.Flags().IntVar(&filter.Limit, "limit", AuditLimitDefault, "Show only the latest records, 0 means all records")
+
+	return cmd
+}
+
+// parseTime parses local time and returns milliseconds
+func parseTime(value string) (uint64, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return uint64(t.UnixNano()) / uint64(time.Millisecond), nil
+		}
+	}
+	return 0, fmt.Errorf("Wrong time format: %s. Must be RFC3339, YYYY-MM-DD HH:MM:SS or YYYY-MM-DD", value)
+}
@@ -0,0 +1,55 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package baseline
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+const (
+	DeviceOptionName    = "device"
+	ChOptionName        = "ch"
+	DacOptionName       = "dac"
+	DigitalOptionName   = "digital"
+	TargetOptionName    = "target"
+	ToleranceOptionName = "tolerance"
+)
+
+func NewBaselineCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "baseline",
+		Short: "Show/set channel baselines and equalize pedestals",
+	}
+	cmd.AddCommand(NewShowCommand())
+	cmd.AddCommand(NewSetCommand())
+	cmd.AddCommand(NewEqualizeCommand())
+	return cmd
+}
+
+func printBaselineResult(result *layers.BaselineResult) {
+	fmt.Printf("Device %s: target: %d tolerance: %d\n", result.Device, result.Target, result.Tolerance)
+	for _, ch := range result.Channels {
+		status := "ok"
+		if !ch.Ok {
+			status = "out of tolerance"
+		}
+		fmt.Printf("  ch %2d: dac 0x%03x digital %6d pedestal %8.1f rms %6.1f %s\n",
+			ch.Ch, ch.Dac, ch.Digital, ch.Pedestal, ch.Rms, status)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package baseline
+
+import (
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+)
+
+func NewEqualizeCommand() *cobra.Command {
+	var deviceName string
+	var target, tolerance int
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "equalize",
+		Short: "Adjust DAC codes so that pedestals of all channels are equal to the target",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			result, err := apiClient.BaselineEqualize(deviceName, target, tolerance)
+			if err != nil {
+				return err
+			}
+			printBaselineResult(result)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&deviceName, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+	cmd.Flags().IntVar(&target, TargetOptionName, 0, "Target pedestal (ADC counts)")
+	cmd.Flags().IntVar(&tolerance, ToleranceOptionName, device.BaselineToleranceDefault, "Allowed deviation from the target (ADC counts)")
+
+	return cmd
+}
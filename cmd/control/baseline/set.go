@@ -0,0 +1,59 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package baseline
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
+
+func NewSetCommand() *cobra.Command {
+	var device string
+	var ch, dac, digital int
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set analog offset DAC code and/or digital baseline of a channel",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value := &control.BaselineValue{Ch: ch}
+			if cmd.Flags().Changed(DacOptionName) {
+				code := uint16(dac)
+				value.Dac = &code
+			}
+			if cmd.Flags().Changed(DigitalOptionName) {
+				value.Digital = &digital
+			}
+			if value.Dac == nil && value.Digital == nil {
+				return errors.New("At least one of --dac or --digital must be given")
+			}
+			apiClient := command.NewApiClient(cfg)
+			return apiClient.BaselineSet(device, value)
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+	cmd.Flags().IntVar(&ch, ChOptionName, 0, "Channel number (starting from 0)")
+	cmd.MarkFlagRequired(ChOptionName)
+	cmd.Flags().IntVar(&dac, DacOptionName, 0, "Analog offset DAC code")
+	cmd.Flags().IntVar(&digital, DigitalOptionName, 0, "Digital baseline")
+
+	return cmd
+}
@@ -17,7 +17,13 @@ package control
 import (
 	"github.com/spf13/cobra"
 
+	"jinr.ru/greenlab/go-adc/cmd/control/adcspi"
+	"jinr.ru/greenlab/go-adc/cmd/control/baseline"
+	"jinr.ru/greenlab/go-adc/cmd/control/fir"
+	"jinr.ru/greenlab/go-adc/cmd/control/lease"
 	"jinr.ru/greenlab/go-adc/cmd/control/reg"
+	"jinr.ru/greenlab/go-adc/cmd/control/scan"
+	"jinr.ru/greenlab/go-adc/cmd/control/snapshot"
 )
 
 const (
@@ -31,8 +37,20 @@ func NewCommand() *cobra.Command {
 	}
 
 	cmd.AddCommand(reg.NewRegCommand())
+	cmd.AddCommand(adcspi.NewAdcSpiCommand())
+	cmd.AddCommand(baseline.NewBaselineCommand())
+	cmd.AddCommand(fir.NewFirCommand())
+	cmd.AddCommand(scan.NewScanCommand())
+	cmd.AddCommand(snapshot.NewSnapshotCommand())
+	cmd.AddCommand(lease.NewLeaseCommand())
 	cmd.AddCommand(NewMStreamCommand())
 	cmd.AddCommand(NewStartCommand())
+	cmd.AddCommand(NewDesTrainCommand())
+	cmd.AddCommand(NewDriftCommand())
+	cmd.AddCommand(NewSettingsCommand())
+	cmd.AddCommand(NewAuditCommand())
+	cmd.AddCommand(NewAlarmsCommand())
+	cmd.AddCommand(NewIdentityCommand())
 
 	return cmd
 }
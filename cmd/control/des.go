@@ -0,0 +1,86 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+func NewDesTrainCommand() *cobra.Command {
+	var device string
+	var show bool
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "des-train",
+		Short: "Align ADC deserializers (all devices if device is not given)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			var devices []string
+			if device != "" {
+				devices = append(devices, device)
+			} else {
+				for _, d := range cfg.Devices {
+					devices = append(devices, d.Name)
+				}
+			}
+			failed := false
+			for _, d := range devices {
+				var result *layers.DesTrainResult
+				var err error
+				if show {
+					result, err = apiClient.DesResult(d)
+				} else {
+					result, err = apiClient.DesTrain(d)
+				}
+				if err != nil {
+					fmt.Printf("Device %s: error: %s\n", d, err)
+					failed = true
+					continue
+				}
+				printDesResult(result)
+				if len(result.Failed()) > 0 {
+					failed = true
+				}
+			}
+			if failed {
+				return fmt.Errorf("Deserializer training failed")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.Flags().BoolVar(&show, "show", false, "Show the last stored result instead of running the training")
+
+	return cmd
+}
+
+func printDesResult(result *layers.DesTrainResult) {
+	fmt.Printf("Device %s:\n", result.Device)
+	for _, ch := range result.Channels {
+		status := "ok"
+		if !ch.Ok {
+			status = "FAILED"
+		}
+		fmt.Printf("  ch %2d: tap %2d eye %2d..%2d (width %2d) %s\n",
+			ch.Ch, ch.Tap, ch.EyeStart, ch.EyeStart+ch.EyeWidth-1, ch.EyeWidth, status)
+	}
+}
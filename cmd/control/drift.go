@@ -0,0 +1,84 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+func NewDriftCommand() *cobra.Command {
+	var device string
+	var refresh, apply bool
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Show settings which differ on devices from config (all devices if device is not given)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			var devices []string
+			if device != "" {
+				devices = append(devices, device)
+			} else {
+				for _, d := range cfg.Devices {
+					devices = append(devices, d.Name)
+				}
+			}
+			for _, d := range devices {
+				var report *layers.DriftReport
+				var err error
+				if apply {
+					report, err = apiClient.DriftApply(d)
+				} else {
+					report, err = apiClient.Drift(d, refresh)
+				}
+				if err != nil {
+					fmt.Printf("Device %s: error: %s\n", d, err)
+					continue
+				}
+				printDriftReport(report)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Check devices now instead of showing the last reconciler report")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Re-apply config to devices with drift")
+
+	return cmd
+}
+
+func printDriftReport(report *layers.DriftReport) {
+	switch {
+	case report.Error != "":
+		fmt.Printf("Device %s: error: %s\n", report.Device, report.Error)
+	case len(report.Drifts) == 0:
+		fmt.Printf("Device %s: no drift\n", report.Device)
+	default:
+		fmt.Printf("Device %s: %d settings differ from config\n", report.Device, len(report.Drifts))
+	}
+	for _, drift := range report.Drifts {
+		fmt.Printf("  %-32s desired: %-10s actual: %s\n", drift.Setting, drift.Desired, drift.Actual)
+	}
+	if report.Applied {
+		fmt.Printf("  config re-applied\n")
+	}
+}
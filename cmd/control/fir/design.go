@@ -0,0 +1,64 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fir
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
+
+func NewDesignCommand() *cobra.Command {
+	var deviceName, shape string
+	var param float64
+	var roundoff uint16
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "design",
+		Short: "Design FIR coefficients and optionally load them to a device",
+		Long: fmt.Sprintf(`Design FIR coefficients with unity gain. Shapes:
+  %s       moving average, param is the number of samples (1..16)
+  %s     CR-RC shaper, param is the time constant in samples (0..4]
+  %s  windowed-sinc low-pass, param is the cutoff as a fraction of the sampling frequency (0..0.5)`,
+			device.FirShapeMovingAverage, device.FirShapeCrRc, device.FirShapeLowPass),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			coef, err := device.DesignFir(shape, param)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Coefficients: %s\n", formatCoef(coef))
+			if deviceName == "" {
+				return nil
+			}
+			apiClient := command.NewApiClient(cfg)
+			return apiClient.FirSet(deviceName, &control.FirSetup{
+				Coef:     coef,
+				Roundoff: &roundoff,
+			})
+		},
+	}
+	cmd.Flags().StringVar(&deviceName, DeviceOptionName, "", "Device name to load coefficients to")
+	cmd.Flags().StringVar(&shape, "shape", device.FirShapeMovingAverage, "Filter shape")
+	cmd.Flags().Float64Var(&param, "param", 4, "Shape parameter")
+	cmd.Flags().Uint16Var(&roundoff, "roundoff", device.FirRoundoffDefault, "Roundoff")
+
+	return cmd
+}
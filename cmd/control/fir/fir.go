@@ -0,0 +1,41 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fir
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	DeviceOptionName = "device"
+)
+
+func NewFirCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fir",
+		Short: "Show/load FIR filter setup and design coefficients",
+	}
+	cmd.AddCommand(NewShowCommand())
+	cmd.AddCommand(NewSetCommand())
+	cmd.AddCommand(NewPresetsCommand())
+	cmd.AddCommand(NewDesignCommand())
+	return cmd
+}
+
+func formatCoef(coef []int) string {
+	return fmt.Sprint(coef)
+}
@@ -0,0 +1,50 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fir
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+)
+
+func NewPresetsCommand() *cobra.Command {
+	var deviceName string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "presets",
+		Short: "List FIR presets available for a device",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			presets, err := apiClient.FirPresets(deviceName)
+			if err != nil {
+				return err
+			}
+			for _, name := range device.FirPresetNames(presets) {
+				fmt.Printf("%-10s roundoff: %d coef: %s\n", name, presets[name].Roundoff, formatCoef(presets[name].Coef))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&deviceName, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+
+	return cmd
+}
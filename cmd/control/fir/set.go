@@ -0,0 +1,64 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fir
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
+
+func NewSetCommand() *cobra.Command {
+	var deviceName, preset string
+	var coef []int
+	var roundoff uint16
+	var enable bool
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Load FIR preset or coefficients and enable/disable the filter",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			setup := &control.FirSetup{
+				Preset: preset,
+				Coef:   coef,
+			}
+			if len(coef) > 0 || cmd.Flags().Changed("roundoff") {
+				setup.Roundoff = &roundoff
+			}
+			if cmd.Flags().Changed("enable") {
+				setup.Enabled = &enable
+			}
+			if setup.Preset == "" && len(setup.Coef) == 0 && setup.Roundoff == nil && setup.Enabled == nil {
+				return errors.New("At least one of --preset, --coef, --roundoff or --enable must be given")
+			}
+			apiClient := command.NewApiClient(cfg)
+			return apiClient.FirSet(deviceName, setup)
+		},
+	}
+	cmd.Flags().StringVar(&deviceName, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+	cmd.Flags().StringVar(&preset, "preset", "", "Preset name")
+	cmd.Flags().IntSliceVar(&coef, "coef", nil, "Coefficients (up to 16, missing are set to 0)")
+	cmd.Flags().Uint16Var(&roundoff, "roundoff", device.FirRoundoffDefault, "Roundoff")
+	cmd.Flags().BoolVar(&enable, "enable", true, "Enable/disable the filter")
+
+	return cmd
+}
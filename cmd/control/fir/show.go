@@ -0,0 +1,52 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package fir
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewShowCommand() *cobra.Command {
+	var device string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Read the FIR filter setup loaded to a device",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			state, err := apiClient.FirRead(device)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Enabled: %t\n", state.Enabled)
+			if state.Preset != "" {
+				fmt.Printf("Preset: %s\n", state.Preset)
+			}
+			fmt.Printf("Roundoff: %d\n", state.Roundoff)
+			fmt.Printf("Coefficients: %s\n", formatCoef(state.Coef))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+
+	return cmd
+}
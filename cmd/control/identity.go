@@ -0,0 +1,60 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+func NewIdentityCommand() *cobra.Command {
+	var device string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "identity",
+		Short: "Show whether devices have serial numbers declared in config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			var reports []*layers.IdentityReport
+			if device != "" {
+				report, err := apiClient.VerifyIdentity(device)
+				if err != nil {
+					return err
+				}
+				reports = append(reports, report)
+			} else {
+				var err error
+				reports, err = apiClient.Identity()
+				if err != nil {
+					return err
+				}
+			}
+			fmt.Printf("%-12s %-16s %-20s %-10s %-20s %-8s %s\n", "Device", "IP", "Expected", "Register", "Discovered", "Verified", "Error")
+			for _, r := range reports {
+				fmt.Printf("%-12s %-16s %-20s %-10s %-20s %-8t %s\n", r.Device, r.IP, r.Expected, r.Register, r.Discovered, r.Verified, r.Error)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Verify the device now")
+
+	return cmd
+}
@@ -0,0 +1,60 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package lease
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
+
+func NewAcquireCommand() *cobra.Command {
+	var crate uint16
+	request := &layers.LeaseRequest{}
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "acquire",
+		Short: "Acquire or renew the exclusive lease of a device or of all devices in a crate",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			request.Crate = crateFlag(cmd, crate)
+			if (request.Device == "") == (request.Crate == nil) {
+				return errors.New("either device or crate must be given")
+			}
+			if request.Owner != "" {
+				cfg.LeaseOwner = request.Owner
+			}
+			apiClient := command.NewApiClient(cfg)
+			lease, err := apiClient.LeaseAcquire(request)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Lease of %s is held by %s until %s\n", leaseTarget(lease), lease.Owner, formatTime(lease.Expires))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&request.Device, DeviceOptionName, "", "Device name")
+	cmd.Flags().Uint16Var(&crate, CrateOptionName, 0, "Crate ID")
+	cmd.Flags().StringVar(&request.Owner, OwnerOptionName, "", "Lease owner. Default is leaseOwner from config or user@host")
+	cmd.Flags().IntVar(&request.TTL, TTLOptionName, control.LeaseTTLDefault, "Lease time to live in seconds")
+
+	return cmd
+}
@@ -0,0 +1,85 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package lease
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+const (
+	DeviceOptionName = "device"
+	CrateOptionName  = "crate"
+	OwnerOptionName  = "owner"
+	TTLOptionName    = "ttl"
+)
+
+func NewLeaseCommand() *cobra.Command {
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "lease",
+		Short: "Acquire/release exclusive leases of devices and crates (list active leases if no subcommand is given)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listLeases(cfg)
+		},
+	}
+	cmd.AddCommand(NewAcquireCommand())
+	cmd.AddCommand(NewReleaseCommand())
+	cmd.AddCommand(NewListCommand())
+	return cmd
+}
+
+func listLeases(cfg *config.Config) error {
+	apiClient := command.NewApiClient(cfg)
+	leases, err := apiClient.LeaseList()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-20s %-24s %-20s %s\n", "Lease", "Owner", "Acquired", "Expires")
+	for _, lease := range leases {
+		printLease(lease)
+	}
+	return nil
+}
+
+func printLease(lease *layers.Lease) {
+	fmt.Printf("%-20s %-24s %-20s %s\n", leaseTarget(lease), lease.Owner, formatTime(lease.Acquired), formatTime(lease.Expires))
+}
+
+func leaseTarget(lease *layers.Lease) string {
+	if lease.Crate != nil {
+		return fmt.Sprintf("crate %d", *lease.Crate)
+	}
+	return fmt.Sprintf("device %s", lease.Device)
+}
+
+func formatTime(ms uint64) string {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
+
+// crateFlag returns the crate if the flag is set
+func crateFlag(cmd *cobra.Command, crate uint16) *uint16 {
+	if cmd.Flags().Changed(CrateOptionName) {
+		return &crate
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package lease
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewReleaseCommand() *cobra.Command {
+	var device, owner string
+	var crate uint16
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "release",
+		Short: "Release the lease of a device or a crate",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cratePtr := crateFlag(cmd, crate)
+			if (device == "") == (cratePtr == nil) {
+				return errors.New("either device or crate must be given")
+			}
+			if owner != "" {
+				cfg.LeaseOwner = owner
+			}
+			apiClient := command.NewApiClient(cfg)
+			if err := apiClient.LeaseRelease(device, cratePtr); err != nil {
+				return err
+			}
+			fmt.Println("Lease is released")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.Flags().Uint16Var(&crate, CrateOptionName, 0, "Crate ID")
+	cmd.Flags().StringVar(&owner, OwnerOptionName, "", "Lease owner. Default is leaseOwner from config or user@host")
+
+	return cmd
+}
@@ -22,11 +22,13 @@ import (
 
 	"jinr.ru/greenlab/go-adc/pkg/command"
 	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
 )
 
 func NewMStreamCommand() *cobra.Command {
 	var filePrefix string
 	var dir string
+	var delay int
 	cfg := config.NewDefaultConfig()
 	cfg.Load()
 	cmd := &cobra.Command{
@@ -42,7 +44,11 @@ func NewMStreamCommand() *cobra.Command {
 				if err != nil {
 					return err
 				}
-				return apiClient.MStreamStartAll()
+				report, err := apiClient.MStreamSyncStart(delay)
+				if report != nil {
+					printSyncStartReport(report)
+				}
+				return err
 			case "stop":
 				err := apiClient.MStreamStopAll()
 				if err != nil {
@@ -56,6 +62,25 @@ func NewMStreamCommand() *cobra.Command {
 	}
 	cmd.Flags().StringVar(&dir, "dir", "", "Directory path where to persist data")
 	cmd.Flags().StringVar(&filePrefix, "file-prefix", "", "File name prefix")
+	cmd.Flags().IntVar(&delay, "delay", 0, "Start all devices at the same White Rabbit time the given number of seconds ahead (server default if 0)")
 
 	return cmd
 }
+
+func printSyncStartReport(report *layers.SyncStartReport) {
+	if report.TaiSec != 0 {
+		fmt.Printf("Start time (TAI): %d\n", report.TaiSec)
+	} else {
+		fmt.Printf("Start time (TAI): devices started one by one\n")
+	}
+	for _, d := range report.Devices {
+		switch {
+		case d.Error != "":
+			fmt.Printf("  %-20s failed: %s\n", d.Device, d.Error)
+		case d.SeenRunningTaiSec != 0:
+			fmt.Printf("  %-20s running at %d\n", d.Device, d.SeenRunningTaiSec)
+		default:
+			fmt.Printf("  %-20s running\n", d.Device)
+		}
+	}
+}
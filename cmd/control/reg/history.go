@@ -0,0 +1,98 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package reg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+const (
+	FromOptionName = "from"
+	ToOptionName   = "to"
+	StepOptionName = "step"
+)
+
+// timeLayouts are accepted by --from and --to options
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func NewHistoryCommand() *cobra.Command {
+	var device, addr, from, to, step string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show history of register values",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var fromMs, toMs uint64
+			var err error
+			if from != "" {
+				if fromMs, err = parseTime(from); err != nil {
+					return err
+				}
+			}
+			if to != "" {
+				if toMs, err = parseTime(to); err != nil {
+					return err
+				}
+			}
+			apiClient := command.NewApiClient(cfg)
+			history, err := apiClient.RegHistory(device, addr, fromMs, toMs, step)
+			if err != nil {
+				return err
+			}
+			if history.Step == 0 {
+				fmt.Printf("%-20s %s\n", "Time", "Value")
+				for _, s := range history.Samples {
+					fmt.Printf("%-20s 0x%04x\n", formatTime(s.Timestamp), s.Value)
+				}
+				return nil
+			}
+			fmt.Printf("%-20s %-8s %-8s %-8s %s\n", "Time", "Last", "Min", "Max", "Count")
+			for _, s := range history.Samples {
+				fmt.Printf("%-20s 0x%04x   0x%04x   0x%04x   %d\n", formatTime(s.Timestamp), s.Value, s.Min, s.Max, s.Count)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+	cmd.Flags().StringVar(&addr, AddrOptionName, "", "Register address (hexadecimal, e.g. 0x0040)")
+	cmd.MarkFlagRequired(AddrOptionName)
+	cmd.Flags().StringVar(&from, FromOptionName, "", "Show values since the time (RFC3339, YYYY-MM-DD HH:MM:SS or YYYY-MM-DD)")
+	cmd.Flags().StringVar(&to, ToOptionName, "", "Show values until the time")
+	cmd.Flags().StringVar(&step, StepOptionName, "", "Downsample the history to intervals of the duration, e.g. 10m")
+
+	return cmd
+}
+
+// parseTime parses local time in one of timeLayouts and returns milliseconds
+func parseTime(value string) (uint64, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return uint64(t.UnixNano()) / uint64(time.Millisecond), nil
+		}
+	}
+	return 0, fmt.Errorf("Wrong time format: %s. Must be RFC3339, YYYY-MM-DD HH:MM:SS or YYYY-MM-DD", value)
+}
+
+func formatTime(ms uint64) string {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
@@ -31,5 +31,6 @@ func NewRegCommand() *cobra.Command {
 	}
 	cmd.AddCommand(NewReadCommand())
 	cmd.AddCommand(NewWriteCommand())
+	cmd.AddCommand(NewHistoryCommand())
 	return cmd
 }
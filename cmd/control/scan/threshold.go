@@ -0,0 +1,145 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
+
+const (
+	jobPollInterval = 2 * time.Second
+)
+
+func NewThresholdCommand() *cobra.Command {
+	var deviceName, output string
+	setup := &layers.ThresholdScanSetup{}
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "threshold",
+		Short: "Measure trigger rate vs threshold and propose thresholds (all devices if device is not given)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			var devices []string
+			if deviceName != "" {
+				devices = append(devices, deviceName)
+			} else {
+				for _, d := range cfg.Devices {
+					devices = append(devices, d.Name)
+				}
+			}
+
+			jobs := make(map[string]*control.Job)
+			for _, d := range devices {
+				job, err := apiClient.ScanThreshold(d, setup)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Threshold scan started: device: %s job: %s\n", d, job.ID)
+				jobs[d] = job
+			}
+
+			// interrupted scans are cancelled, so the server restores the trigger setup
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			defer signal.Stop(interrupt)
+
+			var results []*layers.ThresholdScanResult
+			failed := false
+			for len(jobs) > 0 {
+				select {
+				case <-interrupt:
+					for d, job := range jobs {
+						if err := apiClient.CancelJob(job.ID); err != nil {
+							fmt.Printf("Device %s: error while cancelling threshold scan: %s\n", d, err)
+						}
+					}
+					return errors.New("Threshold scan cancelled")
+				case <-time.After(jobPollInterval):
+				}
+				for d, started := range jobs {
+					result := &layers.ThresholdScanResult{}
+					job, err := apiClient.GetJob(started.ID, result)
+					if err != nil {
+						return err
+					}
+					switch job.State {
+					case control.JobRunning:
+						fmt.Printf("Device %s: %.0f%%\n", d, job.Progress*100)
+						continue
+					case control.JobFailed, control.JobCancelled:
+						fmt.Printf("Device %s: threshold scan %s: %s\n", d, job.State, job.Error)
+						failed = true
+					case control.JobDone:
+						printThresholdScanResult(result)
+						results = append(results, result)
+					}
+					delete(jobs, d)
+				}
+			}
+
+			if output != "" {
+				data, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					return err
+				}
+				if err := ioutil.WriteFile(output, data, 0644); err != nil {
+					return err
+				}
+			}
+			if failed {
+				return errors.New("Threshold scan failed")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&deviceName, DeviceOptionName, "", "Device name")
+	cmd.Flags().IntVar(&setup.From, "from", 0, "First threshold (ADC counts)")
+	cmd.Flags().IntVar(&setup.To, "to", 0, "Last threshold (ADC counts)")
+	cmd.MarkFlagRequired("to")
+	cmd.Flags().IntVar(&setup.Step, "step", device.ThresholdScanStepDefault, "Threshold step (ADC counts)")
+	cmd.Flags().IntVar(&setup.DwellMs, "dwell", device.ThresholdScanDwellDefault, "Time to count triggers at each threshold (ms)")
+	cmd.Flags().Float64Var(&setup.NSigma, "nsigma", device.ThresholdScanNSigmaDefault, "Proposed threshold distance from the noise mean (sigma)")
+	cmd.Flags().IntSliceVar(&setup.Channels, "ch", nil, "Channels to scan (all channels if not given)")
+	cmd.Flags().BoolVar(&setup.Apply, "apply", false, "Apply proposed thresholds")
+	cmd.Flags().StringVar(&output, "output", "", "File where to save S-curves (JSON)")
+
+	return cmd
+}
+
+func printThresholdScanResult(result *layers.ThresholdScanResult) {
+	fmt.Printf("Device %s:\n", result.Device)
+	for _, ch := range result.Channels {
+		if !ch.Ok {
+			fmt.Printf("  ch %2d: no noise found\n", ch.Ch)
+			continue
+		}
+		fmt.Printf("  ch %2d: mean %8.1f sigma %6.1f proposed threshold %6d\n", ch.Ch, ch.Mean, ch.Sigma, ch.Proposed)
+	}
+}
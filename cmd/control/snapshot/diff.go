@@ -0,0 +1,71 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+func NewDiffCommand() *cobra.Command {
+	var deviceName string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "diff FILE [FILE]",
+		Short: "Compare two snapshots or a snapshot with the current device state",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			left, err := readSnapshot(args[0])
+			if err != nil {
+				return err
+			}
+
+			var diffs []*layers.SnapshotDiff
+			if len(args) == 2 {
+				right, err := readSnapshot(args[1])
+				if err != nil {
+					return err
+				}
+				diffs, err = device.DiffSnapshots(left, right)
+				if err != nil {
+					return err
+				}
+				printDiffs(args[0], args[1], diffs)
+				return nil
+			}
+
+			if deviceName == "" {
+				return errors.New("Either the second file or --device must be given")
+			}
+			apiClient := command.NewApiClient(cfg)
+			diffs, err = apiClient.SnapshotDiff(deviceName, left)
+			if err != nil {
+				return err
+			}
+			printDiffs(args[0], deviceName, diffs)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&deviceName, DeviceOptionName, "", "Device name to compare the snapshot with")
+
+	return cmd
+}
@@ -0,0 +1,46 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewRestoreCommand() *cobra.Command {
+	var device, file string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore device state from a file. The snapshot can be taken from another device",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshot, err := readSnapshot(file)
+			if err != nil {
+				return err
+			}
+			apiClient := command.NewApiClient(cfg)
+			return apiClient.SnapshotRestore(device, snapshot)
+		},
+	}
+	cmd.Flags().StringVar(&device, DeviceOptionName, "", "Device name")
+	cmd.MarkFlagRequired(DeviceOptionName)
+	cmd.Flags().StringVar(&file, FileOptionName, "", "Snapshot file")
+	cmd.MarkFlagRequired(FileOptionName)
+
+	return cmd
+}
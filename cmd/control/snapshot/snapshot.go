@@ -0,0 +1,82 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+const (
+	DeviceOptionName = "device"
+	FileOptionName   = "file"
+)
+
+func NewSnapshotCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save/restore/diff device state snapshots",
+	}
+	cmd.AddCommand(NewSaveCommand())
+	cmd.AddCommand(NewRestoreCommand())
+	cmd.AddCommand(NewDiffCommand())
+	return cmd
+}
+
+// writeSnapshot saves the snapshot as JSON if the file has .json extension and as YAML otherwise
+func writeSnapshot(path string, snapshot *layers.Snapshot) error {
+	var data []byte
+	var err error
+	if filepath.Ext(path) == ".json" {
+		data, err = json.MarshalIndent(snapshot, "", "  ")
+	} else {
+		data, err = yaml.Marshal(snapshot)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// readSnapshot reads a snapshot from JSON or YAML file
+func readSnapshot(path string) (*layers.Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &layers.Snapshot{}
+	if err := yaml.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func printDiffs(left, right string, diffs []*layers.SnapshotDiff) {
+	if len(diffs) == 0 {
+		fmt.Println("No differences")
+		return
+	}
+	fmt.Printf("%-40s %-12s %s\n", "Setting", left, right)
+	for _, diff := range diffs {
+		fmt.Printf("%-40s %-12s %s\n", diff.Setting, diff.Left, diff.Right)
+	}
+}
@@ -26,6 +26,10 @@ func NewCommand() *cobra.Command {
 
 	cmd.AddCommand(NewStartCommand())
 	cmd.AddCommand(NewListCommand())
+	cmd.AddCommand(NewShowCommand())
+	cmd.AddCommand(NewWatchCommand())
+	cmd.AddCommand(NewCheckCommand())
+	cmd.AddCommand(NewReadCommand())
 
 	return cmd
 }
@@ -15,36 +15,54 @@
 package discover
 
 import (
+	"errors"
 	"fmt"
-	"time"
 
 	"github.com/spf13/cobra"
 
 	"jinr.ru/greenlab/go-adc/pkg/command"
 	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
 )
 
 func NewListCommand() *cobra.Command {
+	var online, offline bool
+	var slot uint16
+	filter := &layers.DeviceFilter{}
 	cfg := config.NewDefaultConfig()
 	cfg.Load()
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List discovered devices",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if online && offline {
+				return errors.New("--online and --offline are mutually exclusive")
+			}
+			if online || offline {
+				filter.Online = &online
+			}
+			if cmd.Flags().Changed("slot") {
+				filter.Slot = &slot
+			}
 			apiClient := command.NewApiClient(cfg)
-			devices, err := apiClient.ListDevices()
+			devices, err := apiClient.ListDevices(filter)
 			if err != nil {
 				return err
 			}
 			for _, device := range devices {
 				fmt.Printf(device.String())
-				now := uint64(time.Now().UnixNano()) * uint64(time.Nanosecond) / uint64(time.Millisecond)
-				if now-device.Timestamp > 3000 {
+				if !device.Online {
 					fmt.Printf("~~~ Device is offline ~~~\n")
 				}
 			}
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&online, "online", false, "List only online devices")
+	cmd.Flags().BoolVar(&offline, "offline", false, "List only offline devices")
+	cmd.Flags().StringVar(&filter.IP, "ip", "", "List only devices with the master IP or source address")
+	cmd.Flags().StringVar(&filter.Firmware, "firmware", "", "List only devices with the firmware revision")
+	cmd.Flags().StringVar(&filter.Hardware, "hardware", "", "List only devices with the hardware revision")
+	cmd.Flags().Uint16Var(&slot, "slot", 0, "List only devices in the chassis slot")
 	return cmd
 }
@@ -0,0 +1,85 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package discover
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/srv/discover"
+)
+
+func NewReadCommand() *cobra.Command {
+	var pcap string
+	var all, jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "read",
+		Short: "Read device descriptions from a pcap or pcapng capture",
+		Long: "Read device descriptions from MLDP frames in a pcap or pcapng capture. " +
+			"By default only the last description of every device is printed.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(pcap)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			descriptions, err := discover.ReadCapture(f)
+			if err != nil {
+				return err
+			}
+			if !all {
+				descriptions = lastDescriptions(descriptions)
+			}
+			if jsonOutput {
+				data, err := json.MarshalIndent(descriptions, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+			for _, dd := range descriptions {
+				fmt.Printf(dd.String())
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&pcap, "pcap", "", "Capture file")
+	cmd.MarkFlagRequired("pcap")
+	cmd.Flags().BoolVar(&all, "all", false, "Print descriptions from all frames")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print descriptions as JSON")
+	return cmd
+}
+
+// lastDescriptions returns the last description of every device in the order devices were first seen
+func lastDescriptions(descriptions []*layers.DeviceDescription) []*layers.DeviceDescription {
+	var result []*layers.DeviceDescription
+	index := map[string]int{}
+	for _, dd := range descriptions {
+		if i, ok := index[dd.SerialNumber]; ok {
+			dd.FirstSeen = result[i].FirstSeen
+			result[i] = dd
+			continue
+		}
+		dd.FirstSeen = dd.Timestamp
+		index[dd.SerialNumber] = len(result)
+		result = append(result, dd)
+	}
+	return result
+}
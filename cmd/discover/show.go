@@ -0,0 +1,72 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package discover
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+func NewShowCommand() *cobra.Command {
+	var serial string
+	var history bool
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the discovered device and its history",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiClient := command.NewApiClient(cfg)
+			device, err := apiClient.Device(serial)
+			if err != nil {
+				return err
+			}
+			fmt.Printf(device.String())
+			if !history {
+				return nil
+			}
+			events, err := apiClient.DeviceHistory(serial)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("---\n")
+			for _, event := range events {
+				printEvent(event)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&serial, "serial", "", "Device serial number")
+	cmd.MarkFlagRequired("serial")
+	cmd.Flags().BoolVar(&history, "history", false, "Show appear, disappear and change events of the device")
+	return cmd
+}
+
+func printEvent(event *layers.DeviceEvent) {
+	fmt.Printf("%s %-12s %s\n", formatTime(event.Timestamp), event.Event, event.Serial)
+	for _, change := range event.Changes {
+		fmt.Printf("    %s: %s -> %s\n", change.Field, change.Old, change.New)
+	}
+}
+
+func formatTime(ms uint64) string {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
@@ -31,7 +31,8 @@ const (
 )
 
 func NewStartCommand() *cobra.Command {
-	var ip, iface string
+	var ip string
+	var ifaces []string
 	cfg := config.NewDefaultConfig()
 	cfg.Load()
 	cmd := &cobra.Command{
@@ -42,8 +43,9 @@ func NewStartCommand() *cobra.Command {
 				parsedIP := net.ParseIP(ip)
 				cfg.DiscoverIP = &parsedIP
 			}
-			if iface != "" {
-				cfg.DiscoverIface = iface
+			if len(ifaces) > 0 {
+				cfg.DiscoverIface = ifaces[0]
+				cfg.DiscoverIfaces = ifaces[1:]
 			}
 
 			ctx := context.Background()
@@ -55,8 +57,8 @@ func NewStartCommand() *cobra.Command {
 		},
 	}
 	cmd.Flags().StringVar(&ip, IPOptionName, "", fmt.Sprintf("IP to bind. E.g. %s", config.DefaultDiscoverIP))
-	cmd.Flags().StringVar(&iface, IfaceOptionName, "",
-		fmt.Sprintf("Interface name to listen on. E.g. %s", config.DefaultDiscoverIface))
+	cmd.Flags().StringSliceVar(&ifaces, IfaceOptionName, nil,
+		fmt.Sprintf("Interface names to listen on, comma separated or repeated. E.g. %s", config.DefaultDiscoverIface))
 
 	return cmd
 }
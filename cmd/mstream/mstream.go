@@ -27,5 +27,6 @@ func NewCommand() *cobra.Command {
 	cmd.AddCommand(NewStartCommand())
 	cmd.AddCommand(NewPersistCommand())
 	cmd.AddCommand(NewFlushCommand())
+	cmd.AddCommand(NewReplayCommand())
 	return cmd
 }
@@ -0,0 +1,72 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package mstream
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/srv/mstream"
+)
+
+func NewReplayCommand() *cobra.Command {
+	var pcap string
+	setup := &mstream.ReplaySetup{DrainTimeout: mstream.DrainTimeoutDefault}
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Write data files from MStream traffic captured in a pcap or pcapng file",
+		Long: "Feed MStream datagrams captured in a pcap or pcapng file through the same pipeline " +
+			"as the MStream server and write data files. Datagrams are assigned to devices by " +
+			"the source IP in config. ACKs are not sent.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(pcap)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			server, err := mstream.NewMStreamServer(context.Background(), cfg)
+			if err != nil {
+				return err
+			}
+			result, err := server.Replay(f, setup)
+			if result == nil {
+				return err
+			}
+			fmt.Printf("Datagrams: %d unknown devices: %d\n", result.Packets, result.Unknown)
+			for name, st := range result.Stats {
+				fmt.Printf("%-12s fragments: %d events: %d partial: %d dropped: %d bytes: %d\n",
+					name, st.Fragments, st.Events, st.Partial, st.Dropped, st.Bytes)
+			}
+			for _, file := range result.Files {
+				fmt.Printf("%s size: %d sha256: %s\n", file.Path, file.Size, file.Sha256)
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&pcap, "pcap", "", "Capture file")
+	cmd.MarkFlagRequired("pcap")
+	cmd.Flags().StringVar(&setup.Dir, "dir", "", "Directory path where to write data")
+	cmd.Flags().StringVar(&setup.FilePrefix, "file-prefix", "", "File name prefix")
+	cmd.Flags().Uint32Var(&setup.RunNumber, "run", 0, "Run number used in file names instead of the current time")
+	cmd.Flags().Float64Var(&setup.Speed, "speed", 1, "Replay speed relative to the capture, 0 means as fast as possible")
+
+	return cmd
+}
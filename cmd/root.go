@@ -25,6 +25,9 @@ import (
 	"jinr.ru/greenlab/go-adc/cmd/control"
 	"jinr.ru/greenlab/go-adc/cmd/discover"
 	"jinr.ru/greenlab/go-adc/cmd/mstream"
+	"jinr.ru/greenlab/go-adc/cmd/run"
+	"jinr.ru/greenlab/go-adc/cmd/runs"
+	"jinr.ru/greenlab/go-adc/cmd/simulate"
 	pkgconfig "jinr.ru/greenlab/go-adc/pkg/config"
 	"jinr.ru/greenlab/go-adc/pkg/log"
 )
@@ -52,6 +55,9 @@ func NewRootCommand(out io.Writer) *cobra.Command {
 	cmd.AddCommand(control.NewCommand())
 	cmd.AddCommand(discover.NewCommand())
 	cmd.AddCommand(mstream.NewCommand())
+	cmd.AddCommand(run.NewCommand())
+	cmd.AddCommand(runs.NewCommand())
+	cmd.AddCommand(simulate.NewCommand())
 	cmd.AddCommand(completion.NewCommand())
 	cmd.PersistentFlags().StringVar(&logLevel, LogLevelOptionName, "", fmt.Sprintf("Log level. %s", log.HelpLevels))
 	return cmd
@@ -0,0 +1,83 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package run
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+const (
+	TypeOptionName       = "type"
+	CommentOptionName    = "comment"
+	DirOptionName        = "dir"
+	FilePrefixOptionName = "file-prefix"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Configure/start/stop data taking runs on all devices",
+	}
+
+	cmd.AddCommand(NewConfigureCommand())
+	cmd.AddCommand(NewStartCommand())
+	cmd.AddCommand(NewStopCommand())
+	cmd.AddCommand(NewStatusCommand())
+	return cmd
+}
+
+func addSetupFlags(cmd *cobra.Command, setup *layers.RunSetup) {
+	cmd.Flags().StringVar(&setup.Type, TypeOptionName, "physics", "Run type")
+	cmd.Flags().StringVar(&setup.Comment, CommentOptionName, "", "Operator comment")
+	cmd.Flags().StringVar(&setup.Dir, DirOptionName, "", "Directory path where to persist data")
+	cmd.Flags().StringVar(&setup.FilePrefix, FilePrefixOptionName, "", "File name prefix")
+}
+
+func formatTime(ms uint64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
+
+func printRun(run *layers.Run) {
+	if run.Number > 0 {
+		fmt.Printf("Run:        %d\n", run.Number)
+	}
+	fmt.Printf("State:      %s\n", run.State)
+	if run.State == layers.RunIdle {
+		return
+	}
+	fmt.Printf("Type:       %s\n", run.Type)
+	if run.Comment != "" {
+		fmt.Printf("Comment:    %s\n", run.Comment)
+	}
+	fmt.Printf("Devices:    %s\n", strings.Join(run.Devices, ", "))
+	fmt.Printf("Configured: %s\n", formatTime(run.Configured))
+	fmt.Printf("Started:    %s\n", formatTime(run.Started))
+	if run.StartTaiSec != 0 {
+		fmt.Printf("Start TAI:  %d\n", run.StartTaiSec)
+	}
+	fmt.Printf("Stopped:    %s\n", formatTime(run.Stopped))
+	if run.Error != "" {
+		fmt.Printf("Error:      %s\n", run.Error)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package runs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewAnnotateCommand() *cobra.Command {
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "annotate NUMBER TEXT...",
+		Short: "Add a comment to a run",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			number, err := parseRunNumber(args[0])
+			if err != nil {
+				return err
+			}
+			apiClient := command.NewApiClient(cfg)
+			record, err := apiClient.RunsAnnotate(number, strings.Join(args[1:], " "))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Run %d has %d annotations\n", record.Number, len(record.Annotations))
+			return nil
+		},
+	}
+
+	return cmd
+}
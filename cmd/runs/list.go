@@ -0,0 +1,67 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package runs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+func NewListCommand() *cobra.Command {
+	var from, to string
+	filter := &layers.RunFilter{}
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List runs started in the time range with the given state and device",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if from != "" {
+				if filter.From, err = parseTime(from); err != nil {
+					return err
+				}
+			}
+			if to != "" {
+				if filter.To, err = parseTime(to); err != nil {
+					return err
+				}
+			}
+			apiClient := command.NewApiClient(cfg)
+			records, err := apiClient.RunsList(filter)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-8s %-12s %-10s %-20s %-12s %-20s %s\n", "Run", "Type", "State", "Started", "Duration", "Devices", "Comment")
+			for _, r := range records {
+				fmt.Printf("%-8d %-12s %-10s %-20s %-12s %-20s %s\n", r.Number, r.Type, r.State,
+					formatTime(r.Started), formatDuration(r.Started, r.Stopped), strings.Join(r.Devices, ","), r.Comment)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, FromOptionName, "", "List runs started at or after the time")
+	cmd.Flags().StringVar(&to, ToOptionName, "", "List runs started at or before the time")
+	cmd.Flags().StringVar(&filter.State, StateOptionName, "", "Run state")
+	cmd.Flags().StringVar(&filter.Device, DeviceOptionName, "", "Device name")
+
+	return cmd
+}
@@ -0,0 +1,77 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package runs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	FromOptionName   = "from"
+	ToOptionName     = "to"
+	StateOptionName  = "state"
+	DeviceOptionName = "device"
+)
+
+// timeLayouts are accepted by --from and --to options
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "runs",
+		Short: "Query and annotate the run catalog",
+	}
+
+	cmd.AddCommand(NewListCommand())
+	cmd.AddCommand(NewShowCommand())
+	cmd.AddCommand(NewAnnotateCommand())
+	return cmd
+}
+
+// parseTime parses local time in one of timeLayouts and returns milliseconds
+func parseTime(value string) (uint64, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return uint64(t.UnixNano()) / uint64(time.Millisecond), nil
+		}
+	}
+	return 0, fmt.Errorf("Wrong time format: %s. Must be RFC3339, YYYY-MM-DD HH:MM:SS or YYYY-MM-DD", value)
+}
+
+func parseRunNumber(value string) (uint32, error) {
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Wrong run number: %s", value)
+	}
+	return uint32(number), nil
+}
+
+func formatTime(ms uint64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
+
+func formatDuration(start, stop uint64) string {
+	if start == 0 || stop < start {
+		return "-"
+	}
+	return (time.Duration(stop-start) * time.Millisecond).String()
+}
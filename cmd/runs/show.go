@@ -0,0 +1,74 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package runs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/command"
+	"jinr.ru/greenlab/go-adc/pkg/config"
+)
+
+func NewShowCommand() *cobra.Command {
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "show NUMBER",
+		Short: "Show the catalog record of a run",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			number, err := parseRunNumber(args[0])
+			if err != nil {
+				return err
+			}
+			apiClient := command.NewApiClient(cfg)
+			r, err := apiClient.RunsShow(number)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Run:      %d\n", r.Number)
+			fmt.Printf("Type:     %s\n", r.Type)
+			fmt.Printf("State:    %s\n", r.State)
+			if r.Error != "" {
+				fmt.Printf("Error:    %s\n", r.Error)
+			}
+			fmt.Printf("Started:  %s\n", formatTime(r.Started))
+			fmt.Printf("Stopped:  %s\n", formatTime(r.Stopped))
+			fmt.Printf("Duration: %s\n", formatDuration(r.Started, r.Stopped))
+			fmt.Printf("Devices:  %s\n", strings.Join(r.Devices, ", "))
+			if r.Comment != "" {
+				fmt.Printf("Comment:  %s\n", r.Comment)
+			}
+			if len(r.Files) > 0 {
+				fmt.Printf("Files:\n")
+				for _, f := range r.Files {
+					fmt.Printf("  %s size: %d sha256: %s\n", f.Path, f.Size, f.Sha256)
+				}
+			}
+			if len(r.Annotations) > 0 {
+				fmt.Printf("Annotations:\n")
+				for _, a := range r.Annotations {
+					fmt.Printf("  %s %s\n", formatTime(a.Timestamp), a.Text)
+				}
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,62 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package simulate
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/srv/simulator"
+)
+
+const (
+	DeviceOptionName = "device"
+)
+
+func NewCommand() *cobra.Command {
+	var devices []string
+	cfg := config.NewDefaultConfig()
+	cfg.Load()
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Simulate devices from config",
+		Long: "Answer register and memory requests on behalf of devices from config. " +
+			"Device IP addresses must be assigned to a local interface (e.g. 127.0.0.0/8 on loopback).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(devices) == 0 {
+				for _, d := range cfg.Devices {
+					devices = append(devices, d.Name)
+				}
+			}
+
+			ctx := context.Background()
+			errChan := make(chan error, len(devices))
+			for _, name := range devices {
+				s, err := simulator.NewDeviceSimulator(ctx, cfg, name)
+				if err != nil {
+					return err
+				}
+				go func() {
+					errChan <- s.Run()
+				}()
+			}
+			return <-errChan
+		},
+	}
+	cmd.Flags().StringSliceVar(&devices, DeviceOptionName, nil, "Devices to simulate. All devices from config by default")
+	return cmd
+}
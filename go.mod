@@ -11,6 +11,7 @@ require (
 	github.com/imroc/req v0.3.0
 	github.com/spf13/cobra v1.1.3
 	go.etcd.io/bbolt v1.3.6
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
 	sigs.k8s.io/yaml v1.2.0
 )
 
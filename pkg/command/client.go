@@ -15,13 +15,21 @@
 package command
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/imroc/req"
 
 	"jinr.ru/greenlab/go-adc/pkg/command/ifc"
 	"jinr.ru/greenlab/go-adc/pkg/config"
+	devicepkg "jinr.ru/greenlab/go-adc/pkg/device"
 	"jinr.ru/greenlab/go-adc/pkg/layers"
 	"jinr.ru/greenlab/go-adc/pkg/srv/control"
 	"jinr.ru/greenlab/go-adc/pkg/srv/discover"
@@ -46,6 +54,11 @@ func NewApiClient(cfg *config.Config) ifc.ApiClient {
 	}
 }
 
+// leaseHeader identifies the caller for write endpoints which require a device lease
+func (c *ApiClient) leaseHeader() req.Header {
+	return req.Header{control.LeaseOwnerHeader: c.GetLeaseOwner()}
+}
+
 func (c *ApiClient) regReadUrl(device, addr string) string {
 	return fmt.Sprintf("%s/reg/r/%s/%s", c.ApiPrefix, device, addr)
 }
@@ -75,6 +88,34 @@ func (c *ApiClient) RegRead(device, addr string) (string, error) {
 	return reg.Value, nil
 }
 
+// RegHistory sends request to get the history of register values between from and to (milliseconds).
+// Step is a duration, e.g. 1m, the history is downsampled to.
+func (c *ApiClient) RegHistory(device, addr string, from, to uint64, step string) (*layers.RegHistory, error) {
+	params := req.QueryParam{}
+	if from != 0 {
+		params["from"] = time.Unix(0, int64(from)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if to != 0 {
+		params["to"] = time.Unix(0, int64(to)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if step != "" {
+		params["step"] = step
+	}
+	r, err := req.Get(fmt.Sprintf("%s/reg/history/%s/%s", c.ApiPrefix, device, addr), params)
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	history := &layers.RegHistory{}
+	err = r.ToJSON(history)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
 // RegReadAll sends request to get values of all registers of a device
 func (c *ApiClient) RegReadAll(device string) (map[string]string, error) {
 	r, err := req.Get(c.regReadUrl(device, "all"))
@@ -102,7 +143,7 @@ func (c *ApiClient) RegWrite(device, addr, value string) error {
 		Addr:  addr,
 		Value: value,
 	}
-	r, err := req.Post(c.regWriteUrl(device), req.BodyJSON(reg))
+	r, err := req.Post(c.regWriteUrl(device), req.BodyJSON(reg), c.leaseHeader())
 	if err != nil {
 		return err
 	}
@@ -112,9 +153,650 @@ func (c *ApiClient) RegWrite(device, addr, value string) error {
 	return nil
 }
 
+func (c *ApiClient) adcSpiUrl(device string, adc int, reg string) string {
+	return fmt.Sprintf("%s/adc_spi/%s/%d/%s", c.ApiPrefix, device, adc, reg)
+}
+
+// AdcSpiChip sends request to get the definition of the ADC chip installed on a device
+func (c *ApiClient) AdcSpiChip(device string) (*devicepkg.AdcChip, error) {
+	r, err := req.Get(fmt.Sprintf("%s/adc_spi/%s", c.ApiPrefix, device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	chip := &devicepkg.AdcChip{}
+	err = r.ToJSON(chip)
+	if err != nil {
+		return nil, err
+	}
+	return chip, nil
+}
+
+// AdcSpiRead sends request to read an internal register of an ADC chip
+func (c *ApiClient) AdcSpiRead(device string, adc int, reg string) (*control.AdcSpiValue, error) {
+	r, err := req.Get(c.adcSpiUrl(device, adc, reg))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	value := &control.AdcSpiValue{}
+	err = r.ToJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// AdcSpiWrite sends request to write the value to an internal register of an ADC chip
+func (c *ApiClient) AdcSpiWrite(device string, adc int, reg, value string) error {
+	spiValue := &control.AdcSpiValue{
+		Adc:   adc,
+		Reg:   reg,
+		Value: value,
+	}
+	r, err := req.Post(c.adcSpiUrl(device, adc, reg), req.BodyJSON(spiValue), c.leaseHeader())
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+func (c *ApiClient) baselineUrl(device string) string {
+	return fmt.Sprintf("%s/baseline/%s", c.ApiPrefix, device)
+}
+
+// BaselineGet sends request to measure pedestals of all channels of a device
+func (c *ApiClient) BaselineGet(device string) (*layers.BaselineResult, error) {
+	r, err := req.Get(c.baselineUrl(device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	result := &layers.BaselineResult{}
+	err = r.ToJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BaselineSet sends request to set the DAC code and/or the digital baseline of a channel
+func (c *ApiClient) BaselineSet(device string, value *control.BaselineValue) error {
+	r, err := req.Post(c.baselineUrl(device), req.BodyJSON(value), c.leaseHeader())
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+// BaselineEqualize sends request to equalize pedestals of all channels of a device
+func (c *ApiClient) BaselineEqualize(device string, target, tolerance int) (*layers.BaselineResult, error) {
+	setup := &control.BaselineEqualize{
+		Target:    target,
+		Tolerance: tolerance,
+	}
+	r, err := req.Post(fmt.Sprintf("%s/baseline/equalize/%s", c.ApiPrefix, device), req.BodyJSON(setup), c.leaseHeader())
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	result := &layers.BaselineResult{}
+	err = r.ToJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FirRead sends request to read the FIR filter setup loaded to a device
+func (c *ApiClient) FirRead(device string) (*layers.FirState, error) {
+	r, err := req.Get(fmt.Sprintf("%s/fir/%s", c.ApiPrefix, device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	state := &layers.FirState{}
+	err = r.ToJSON(state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// FirSet sends request to load the FIR filter setup to a device
+func (c *ApiClient) FirSet(device string, setup *control.FirSetup) error {
+	r, err := req.Post(fmt.Sprintf("%s/fir/%s", c.ApiPrefix, device), req.BodyJSON(setup), c.leaseHeader())
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+// FirPresets sends request to get FIR presets available for a device
+func (c *ApiClient) FirPresets(device string) (map[string]*config.FirPreset, error) {
+	r, err := req.Get(fmt.Sprintf("%s/fir_presets/%s", c.ApiPrefix, device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	presets := make(map[string]*config.FirPreset)
+	err = r.ToJSON(&presets)
+	if err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// ScanThreshold sends request to start the trigger threshold scan for a device
+func (c *ApiClient) ScanThreshold(device string, setup *layers.ThresholdScanSetup) (*control.Job, error) {
+	r, err := req.Post(fmt.Sprintf("%s/scan/threshold/%s", c.ApiPrefix, device), req.BodyJSON(setup), c.leaseHeader())
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	job := &control.Job{}
+	err = r.ToJSON(job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJob sends request to get the state of a job. The result of the job is decoded to the given object.
+func (c *ApiClient) GetJob(id string, result interface{}) (*control.Job, error) {
+	r, err := req.Get(fmt.Sprintf("%s/jobs/%s", c.ApiPrefix, id))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	job := &control.Job{Result: result}
+	err = r.ToJSON(job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CancelJob sends request to cancel a running job
+func (c *ApiClient) CancelJob(id string) error {
+	r, err := req.Delete(fmt.Sprintf("%s/jobs/%s", c.ApiPrefix, id))
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+// Drift sends request to get the drift of a device state from config.
+// If refresh is false the last report made by the reconciler is returned.
+func (c *ApiClient) Drift(device string, refresh bool) (*layers.DriftReport, error) {
+	r, err := req.Get(fmt.Sprintf("%s/drift/%s", c.ApiPrefix, device), req.QueryParam{"refresh": refresh})
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	report := &layers.DriftReport{}
+	err = r.ToJSON(report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// DriftApply sends request to re-apply config to a device if its state drifted from config
+func (c *ApiClient) DriftApply(device string) (*layers.DriftReport, error) {
+	r, err := req.Post(fmt.Sprintf("%s/drift/apply/%s", c.ApiPrefix, device), c.leaseHeader())
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	report := &layers.DriftReport{}
+	err = r.ToJSON(report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (c *ApiClient) snapshotUrl(device string) string {
+	return fmt.Sprintf("%s/snapshot/%s", c.ApiPrefix, device)
+}
+
+// SnapshotSave sends request to take a snapshot of a device state
+func (c *ApiClient) SnapshotSave(device string) (*layers.Snapshot, error) {
+	r, err := req.Get(c.snapshotUrl(device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	snapshot := &layers.Snapshot{}
+	err = r.ToJSON(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// SnapshotRestore sends request to write a snapshot to a device
+func (c *ApiClient) SnapshotRestore(device string, snapshot *layers.Snapshot) error {
+	r, err := req.Post(c.snapshotUrl(device), req.BodyJSON(snapshot), c.leaseHeader())
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+// SnapshotDiff sends request to compare a snapshot with the current state of a device
+func (c *ApiClient) SnapshotDiff(device string, snapshot *layers.Snapshot) ([]*layers.SnapshotDiff, error) {
+	r, err := req.Post(fmt.Sprintf("%s/snapshot/diff/%s", c.ApiPrefix, device), req.BodyJSON(snapshot))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var diffs []*layers.SnapshotDiff
+	err = r.ToJSON(&diffs)
+	if err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+// DesTrain sends request to run the deserializer link training for a device
+func (c *ApiClient) DesTrain(device string) (*layers.DesTrainResult, error) {
+	r, err := req.Post(fmt.Sprintf("%s/des/train/%s", c.ApiPrefix, device), c.leaseHeader())
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	result := &layers.DesTrainResult{}
+	err = r.ToJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DesResult sends request to get the last deserializer link training result for a device
+func (c *ApiClient) DesResult(device string) (*layers.DesTrainResult, error) {
+	r, err := req.Get(fmt.Sprintf("%s/des/%s", c.ApiPrefix, device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	result := &layers.DesTrainResult{}
+	err = r.ToJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Settings sends request to get software settings of a device
+func (c *ApiClient) Settings(device string) (*layers.DeviceSettings, error) {
+	r, err := req.Get(fmt.Sprintf("%s/settings/%s", c.ApiPrefix, device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	settings := &layers.DeviceSettings{}
+	err = r.ToJSON(settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// LeaseList sends request to list active device and crate leases
+func (c *ApiClient) LeaseList() ([]*layers.Lease, error) {
+	r, err := req.Get(fmt.Sprintf("%s/leases", c.ApiPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var leases []*layers.Lease
+	err = r.ToJSON(&leases)
+	if err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+// LeaseAcquire sends request to acquire or renew the lease of a device or a crate
+func (c *ApiClient) LeaseAcquire(request *layers.LeaseRequest) (*layers.Lease, error) {
+	if request.Owner == "" {
+		request.Owner = c.GetLeaseOwner()
+	}
+	r, err := req.Post(fmt.Sprintf("%s/leases", c.ApiPrefix), req.BodyJSON(request))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	lease := &layers.Lease{}
+	err = r.ToJSON(lease)
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// LeaseRelease sends request to release the lease of a device or a crate
+func (c *ApiClient) LeaseRelease(device string, crate *uint16) error {
+	url := fmt.Sprintf("%s/leases/device/%s", c.ApiPrefix, device)
+	if crate != nil {
+		url = fmt.Sprintf("%s/leases/crate/%d", c.ApiPrefix, *crate)
+	}
+	r, err := req.Delete(url, c.leaseHeader())
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+// RunGet sends request to get the current run state
+func (c *ApiClient) RunGet() (*layers.Run, error) {
+	r, err := req.Get(fmt.Sprintf("%s/run", c.ApiPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	run := &layers.Run{}
+	err = r.ToJSON(run)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// runAction sends request to configure/start/stop the run
+func (c *ApiClient) runAction(action string, setup *layers.RunSetup) (*layers.Run, error) {
+	params := []interface{}{c.leaseHeader()}
+	if setup != nil {
+		params = append(params, req.BodyJSON(setup))
+	}
+	r, err := req.Post(fmt.Sprintf("%s/run/%s", c.ApiPrefix, action), params...)
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	run := &layers.Run{}
+	err = r.ToJSON(run)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// RunConfigure sends request to apply config to all devices and prepare the run
+func (c *ApiClient) RunConfigure(setup *layers.RunSetup) (*layers.Run, error) {
+	return c.runAction("configure", setup)
+}
+
+// RunStart sends request to start the run. Devices are configured unless the run is already configured.
+func (c *ApiClient) RunStart(setup *layers.RunSetup) (*layers.Run, error) {
+	return c.runAction("start", setup)
+}
+
+// RunStop sends request to stop the run
+func (c *ApiClient) RunStop() (*layers.Run, error) {
+	return c.runAction("stop", nil)
+}
+
+// RunsList sends request to get cataloged runs matching the filter.
+// Time limits are sent in RFC3339 format.
+func (c *ApiClient) RunsList(filter *layers.RunFilter) ([]*layers.RunRecord, error) {
+	params := req.QueryParam{}
+	if filter.State != "" {
+		params["state"] = filter.State
+	}
+	if filter.Device != "" {
+		params["device"] = filter.Device
+	}
+	if filter.From != 0 {
+		params["from"] = time.Unix(0, int64(filter.From)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if filter.To != 0 {
+		params["to"] = time.Unix(0, int64(filter.To)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	r, err := req.Get(fmt.Sprintf("%s/runs", c.ApiPrefix), params)
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var records []*layers.RunRecord
+	err = r.ToJSON(&records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Audit sends request to list audit records matching the filter
+func (c *ApiClient) Audit(filter *layers.AuditFilter) ([]*layers.AuditRecord, error) {
+	params := req.QueryParam{}
+	if filter.Device != "" {
+		params["device"] = filter.Device
+	}
+	if filter.Owner != "" {
+		params["owner"] = filter.Owner
+	}
+	if filter.Operation != "" {
+		params["operation"] = filter.Operation
+	}
+	if filter.Register != "" {
+		params["register"] = filter.Register
+	}
+	if filter.From != 0 {
+		params["from"] = time.Unix(0, int64(filter.From)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if filter.To != 0 {
+		params["to"] = time.Unix(0, int64(filter.To)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if filter.Limit > 0 {
+		params["limit"] = filter.Limit
+	}
+	r, err := req.Get(fmt.Sprintf("%s/audit", c.ApiPrefix), params)
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var records []*layers.AuditRecord
+	err = r.ToJSON(&records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Identity sends request to list results of the device identity verification
+func (c *ApiClient) Identity() ([]*layers.IdentityReport, error) {
+	r, err := req.Get(fmt.Sprintf("%s/identity", c.ApiPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var reports []*layers.IdentityReport
+	err = r.ToJSON(&reports)
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// VerifyIdentity sends request to verify that the device has the serial number declared in config
+func (c *ApiClient) VerifyIdentity(device string) (*layers.IdentityReport, error) {
+	r, err := req.Get(fmt.Sprintf("%s/identity/%s", c.ApiPrefix, device))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	report := &layers.IdentityReport{}
+	err = r.ToJSON(report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Alarms sends request to list active alarms
+func (c *ApiClient) Alarms() ([]*layers.Alarm, error) {
+	r, err := req.Get(fmt.Sprintf("%s/alarms", c.ApiPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var alarms []*layers.Alarm
+	err = r.ToJSON(&alarms)
+	if err != nil {
+		return nil, err
+	}
+	return alarms, nil
+}
+
+// AlarmHistory sends request to list raised and cleared alarms matching the filter
+func (c *ApiClient) AlarmHistory(filter *layers.AlarmFilter) ([]*layers.Alarm, error) {
+	params := req.QueryParam{}
+	if filter.Device != "" {
+		params["device"] = filter.Device
+	}
+	if filter.From != 0 {
+		params["from"] = time.Unix(0, int64(filter.From)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if filter.To != 0 {
+		params["to"] = time.Unix(0, int64(filter.To)*int64(time.Millisecond)).Format(time.RFC3339)
+	}
+	if filter.Limit > 0 {
+		params["limit"] = filter.Limit
+	}
+	r, err := req.Get(fmt.Sprintf("%s/alarms/history", c.ApiPrefix), params)
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var alarms []*layers.Alarm
+	err = r.ToJSON(&alarms)
+	if err != nil {
+		return nil, err
+	}
+	return alarms, nil
+}
+
+// AlarmAck sends request to acknowledge the active alarm of the device
+func (c *ApiClient) AlarmAck(device, rule string) error {
+	r, err := req.Post(fmt.Sprintf("%s/alarms/ack/%s/%s", c.ApiPrefix, device, url.PathEscape(rule)))
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+// RunsShow sends request to get the catalog record of a run
+func (c *ApiClient) RunsShow(number uint32) (*layers.RunRecord, error) {
+	r, err := req.Get(fmt.Sprintf("%s/runs/%d", c.ApiPrefix, number))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	record := &layers.RunRecord{}
+	err = r.ToJSON(record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// RunsAnnotate sends request to add a comment to a run
+func (c *ApiClient) RunsAnnotate(number uint32, text string) (*layers.RunRecord, error) {
+	annotation := &layers.RunAnnotation{Text: text}
+	r, err := req.Post(fmt.Sprintf("%s/runs/%d/annotate", c.ApiPrefix, number), req.BodyJSON(annotation))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	record := &layers.RunRecord{}
+	err = r.ToJSON(record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
 // MStreamStart sends request to start streaming for a device
 func (c *ApiClient) MStreamStart(device string) error {
-	r, err := req.Get(fmt.Sprintf("%s/mstream/start/%s", c.ApiPrefix, device))
+	r, err := req.Get(fmt.Sprintf("%s/mstream/start/%s", c.ApiPrefix, device), c.leaseHeader())
 	if err != nil {
 		return err
 	}
@@ -126,7 +808,7 @@ func (c *ApiClient) MStreamStart(device string) error {
 
 // MStreamStop sends request to stop streaming for a device
 func (c *ApiClient) MStreamStop(device string) error {
-	r, err := req.Get(fmt.Sprintf("%s/mstream/stop/%s", c.ApiPrefix, device))
+	r, err := req.Get(fmt.Sprintf("%s/mstream/stop/%s", c.ApiPrefix, device), c.leaseHeader())
 	if err != nil {
 		return err
 	}
@@ -138,19 +820,30 @@ func (c *ApiClient) MStreamStop(device string) error {
 
 // MStreamStartAll sends request to start streaming for all devices
 func (c *ApiClient) MStreamStartAll() error {
-	r, err := req.Get(fmt.Sprintf("%s/mstream/start", c.ApiPrefix))
+	_, err := c.MStreamSyncStart(0)
+	return err
+}
+
+// MStreamSyncStart sends request to start streaming for all devices at the same White Rabbit time
+// delay seconds ahead. The report is returned even if some devices have not started.
+func (c *ApiClient) MStreamSyncStart(delay int) (*layers.SyncStartReport, error) {
+	r, err := req.Get(fmt.Sprintf("%s/mstream/start", c.ApiPrefix), req.QueryParam{"delay": delay}, c.leaseHeader())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	report := &layers.SyncStartReport{}
+	if jsonErr := r.ToJSON(report); jsonErr != nil {
+		report = nil
 	}
 	if r.Response().StatusCode != 200 {
-		return errors.New(r.Response().Status)
+		return report, errors.New(r.Response().Status)
 	}
-	return nil
+	return report, nil
 }
 
 // MStreamStop sends request to stop streaming for all devices
 func (c *ApiClient) MStreamStopAll() error {
-	r, err := req.Get(fmt.Sprintf("%s/mstream/stop", c.ApiPrefix))
+	r, err := req.Get(fmt.Sprintf("%s/mstream/stop", c.ApiPrefix), c.leaseHeader())
 	if err != nil {
 		return err
 	}
@@ -176,7 +869,7 @@ func (c *ApiClient) MStreamPersist(dirPath, filePrefix string) error {
 	return nil
 }
 
-// MStreamPersist ...
+// MStreamFlush ...
 func (c *ApiClient) MStreamFlush() error {
 	r, err := req.Get(fmt.Sprintf("%s/flush", c.MStreamApiPrefix))
 	if err != nil {
@@ -189,9 +882,25 @@ func (c *ApiClient) MStreamFlush() error {
 }
 
 // MStreamPersist ...
-func (c *ApiClient) ListDevices() ([]*layers.DeviceDescription, error) {
+func (c *ApiClient) ListDevices(filter *layers.DeviceFilter) ([]*layers.DeviceDescription, error) {
+	params := req.QueryParam{}
+	if filter.Online != nil {
+		params["online"] = *filter.Online
+	}
+	if filter.IP != "" {
+		params["ip"] = filter.IP
+	}
+	if filter.Firmware != "" {
+		params["firmware"] = filter.Firmware
+	}
+	if filter.Hardware != "" {
+		params["hardware"] = filter.Hardware
+	}
+	if filter.Slot != nil {
+		params["slot"] = *filter.Slot
+	}
 	var devices []*layers.DeviceDescription
-	r, err := req.Get(fmt.Sprintf("%s/devices", c.DiscoverApiPrefix))
+	r, err := req.Get(fmt.Sprintf("%s/devices", c.DiscoverApiPrefix), params)
 	if err != nil {
 		return nil, err
 	}
@@ -204,3 +913,88 @@ func (c *ApiClient) ListDevices() ([]*layers.DeviceDescription, error) {
 	}
 	return devices, nil
 }
+
+func (c *ApiClient) Device(serial string) (*layers.DeviceDescription, error) {
+	r, err := req.Get(fmt.Sprintf("%s/devices/%s", c.DiscoverApiPrefix, serial))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	dd := &layers.DeviceDescription{}
+	err = r.ToJSON(dd)
+	if err != nil {
+		return nil, err
+	}
+	return dd, nil
+}
+
+func (c *ApiClient) DeviceHistory(serial string) ([]*layers.DeviceEvent, error) {
+	r, err := req.Get(fmt.Sprintf("%s/devices/%s/history", c.DiscoverApiPrefix, serial))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var history []*layers.DeviceEvent
+	err = r.ToJSON(&history)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// DiscoverCheck requests the report on conflicts between discovered devices
+func (c *ApiClient) DiscoverCheck(offline bool) (*layers.DiscoverReport, error) {
+	r, err := req.Get(fmt.Sprintf("%s/check", c.DiscoverApiPrefix), req.QueryParam{"offline": offline})
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	report := &layers.DiscoverReport{}
+	err = r.ToJSON(report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// WatchDevices calls the handler for every device event streamed by the discover server
+// until the context is done, the stream is closed or the handler returns an error
+func (c *ApiClient) WatchDevices(ctx context.Context, handler func(*layers.DeviceEvent) error) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/events", c.DiscoverApiPrefix), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return errors.New(response.Status)
+	}
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		event := &layers.DeviceEvent{}
+		if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), event); err != nil {
+			return err
+		}
+		if err = handler(event); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
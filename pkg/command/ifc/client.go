@@ -14,17 +14,66 @@
 
 package ifc
 
-import "jinr.ru/greenlab/go-adc/pkg/layers"
+import (
+	"context"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
 
 type ApiClient interface {
 	RegRead(device, addr string) (string, error)
 	RegReadAll(device string) (map[string]string, error)
+	RegHistory(device, addr string, from, to uint64, step string) (*layers.RegHistory, error)
 	RegWrite(device, addr, value string) error
+	AdcSpiChip(deviceName string) (*device.AdcChip, error)
+	AdcSpiRead(deviceName string, adc int, reg string) (*control.AdcSpiValue, error)
+	AdcSpiWrite(deviceName string, adc int, reg, value string) error
+	BaselineGet(deviceName string) (*layers.BaselineResult, error)
+	BaselineSet(deviceName string, value *control.BaselineValue) error
+	BaselineEqualize(deviceName string, target, tolerance int) (*layers.BaselineResult, error)
+	FirRead(deviceName string) (*layers.FirState, error)
+	FirSet(deviceName string, setup *control.FirSetup) error
+	FirPresets(deviceName string) (map[string]*config.FirPreset, error)
+	ScanThreshold(deviceName string, setup *layers.ThresholdScanSetup) (*control.Job, error)
+	GetJob(id string, result interface{}) (*control.Job, error)
+	CancelJob(id string) error
+	Drift(deviceName string, refresh bool) (*layers.DriftReport, error)
+	DriftApply(deviceName string) (*layers.DriftReport, error)
+	SnapshotSave(deviceName string) (*layers.Snapshot, error)
+	SnapshotRestore(deviceName string, snapshot *layers.Snapshot) error
+	SnapshotDiff(deviceName string, snapshot *layers.Snapshot) ([]*layers.SnapshotDiff, error)
+	DesTrain(deviceName string) (*layers.DesTrainResult, error)
+	DesResult(deviceName string) (*layers.DesTrainResult, error)
+	Settings(deviceName string) (*layers.DeviceSettings, error)
+	LeaseList() ([]*layers.Lease, error)
+	LeaseAcquire(request *layers.LeaseRequest) (*layers.Lease, error)
+	LeaseRelease(deviceName string, crate *uint16) error
+	RunGet() (*layers.Run, error)
+	RunConfigure(setup *layers.RunSetup) (*layers.Run, error)
+	RunStart(setup *layers.RunSetup) (*layers.Run, error)
+	RunStop() (*layers.Run, error)
+	RunsList(filter *layers.RunFilter) ([]*layers.RunRecord, error)
+	RunsShow(number uint32) (*layers.RunRecord, error)
+	RunsAnnotate(number uint32, text string) (*layers.RunRecord, error)
+	Audit(filter *layers.AuditFilter) ([]*layers.AuditRecord, error)
+	Identity() ([]*layers.IdentityReport, error)
+	VerifyIdentity(device string) (*layers.IdentityReport, error)
+	Alarms() ([]*layers.Alarm, error)
+	AlarmHistory(filter *layers.AlarmFilter) ([]*layers.Alarm, error)
+	AlarmAck(device, rule string) error
 	MStreamStart(device string) error
 	MStreamStop(device string) error
 	MStreamStartAll() error
+	MStreamSyncStart(delay int) (*layers.SyncStartReport, error)
 	MStreamStopAll() error
 	MStreamPersist(dir, filePrefix string) error
 	MStreamFlush() error
-	ListDevices() ([]*layers.DeviceDescription, error)
+	ListDevices(filter *layers.DeviceFilter) ([]*layers.DeviceDescription, error)
+	Device(serial string) (*layers.DeviceDescription, error)
+	DeviceHistory(serial string) ([]*layers.DeviceEvent, error)
+	DiscoverCheck(offline bool) (*layers.DiscoverReport, error)
+	WatchDevices(ctx context.Context, handler func(*layers.DeviceEvent) error) error
 }
@@ -0,0 +1,129 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ChannelSetup contains settings of a channel. Settings which are not given are left unchanged.
+type ChannelSetup struct {
+	Enabled     *bool `json:"enabled,omitempty"`
+	TrigEnabled *bool `json:"trigEnabled,omitempty"`
+	TrigThr     *int  `json:"trigThr,omitempty"`
+	ZsThr       *int  `json:"zsThr,omitempty"`
+	Baseline    *int  `json:"baseline,omitempty"`
+}
+
+// ChannelOverride applies settings to channels given as a comma separated
+// list of numbers and ranges, e.g. "0-31" or "1,5,40-47"
+type ChannelOverride struct {
+	Channels string `json:"channels"`
+	ChannelSetup
+}
+
+// ChannelsSetup contains settings applied to all channels and overrides for some of them.
+// Overrides are applied in the order they are listed.
+type ChannelsSetup struct {
+	Defaults  *ChannelSetup      `json:"defaults,omitempty"`
+	Overrides []*ChannelOverride `json:"overrides,omitempty"`
+}
+
+// NewDefaultChannelsSetup returns settings which are used for channels by default
+func NewDefaultChannelsSetup() *ChannelsSetup {
+	enabled := true
+	trigEnabled := true
+	trigThr := DefaultChannelTrigThr
+	zsThr := DefaultChannelZsThr
+	baseline := DefaultChannelBaseline
+	return &ChannelsSetup{
+		Defaults: &ChannelSetup{
+			Enabled:     &enabled,
+			TrigEnabled: &trigEnabled,
+			TrigThr:     &trigThr,
+			ZsThr:       &zsThr,
+			Baseline:    &baseline,
+		},
+		Overrides: []*ChannelOverride{},
+	}
+}
+
+// ParseChannelRange parses a comma separated list of channel numbers and ranges
+func ParseChannelRange(s string, nch int) ([]int, error) {
+	var channels []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, ErrChannelRange{Range: s, Max: nch - 1}
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				return nil, ErrChannelRange{Range: s, Max: nch - 1}
+			}
+		}
+		if first < 0 || last >= nch || first > last {
+			return nil, ErrChannelRange{Range: s, Max: nch - 1}
+		}
+		for ch := first; ch <= last; ch++ {
+			channels = append(channels, ch)
+		}
+	}
+	return channels, nil
+}
+
+func (s *ChannelSetup) merge(other *ChannelSetup) {
+	if other == nil {
+		return
+	}
+	if other.Enabled != nil {
+		s.Enabled = other.Enabled
+	}
+	if other.TrigEnabled != nil {
+		s.TrigEnabled = other.TrigEnabled
+	}
+	if other.TrigThr != nil {
+		s.TrigThr = other.TrigThr
+	}
+	if other.ZsThr != nil {
+		s.ZsThr = other.ZsThr
+	}
+	if other.Baseline != nil {
+		s.Baseline = other.Baseline
+	}
+}
+
+// Resolve returns settings of each of nch channels with defaults and overrides applied
+func (c *ChannelsSetup) Resolve(nch int) ([]*ChannelSetup, error) {
+	result := make([]*ChannelSetup, nch)
+	for ch := range result {
+		result[ch] = &ChannelSetup{}
+		result[ch].merge(c.Defaults)
+	}
+	for _, override := range c.Overrides {
+		channels, err := ParseChannelRange(override.Channels, nch)
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range channels {
+			result[ch].merge(&override.ChannelSetup)
+		}
+	}
+	return result, nil
+}
@@ -21,6 +21,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"sigs.k8s.io/yaml"
 )
@@ -49,6 +50,122 @@ type ZsSetup struct {
 	Zs bool
 }
 
+// BaselineSetup contains analog offsets and digital baselines of channels.
+// Dac and Digital are indexed by channel number.
+type BaselineSetup struct {
+	Target    int      `json:"target"`
+	Tolerance int      `json:"tolerance"`
+	Dac       []uint16 `json:"dac,omitempty"`
+	Digital   []int    `json:"digital,omitempty"`
+}
+
+// FirPreset is a named set of FIR filter coefficients
+type FirPreset struct {
+	Roundoff uint16 `json:"roundoff"`
+	Coef     []int  `json:"coef"`
+}
+
+// FirSetup is applied on start. Preset has priority over Coef.
+type FirSetup struct {
+	Enabled  bool   `json:"enabled"`
+	Preset   string `json:"preset,omitempty"`
+	Roundoff uint16 `json:"roundoff,omitempty"`
+	Coef     []int  `json:"coef,omitempty"`
+}
+
+// Reconcile defines how often devices are checked for drift from config
+// and whether config is re-applied automatically when drift is found
+type Reconcile struct {
+	Interval  int  `json:"interval"` // seconds
+	AutoApply bool `json:"autoApply"`
+}
+
+// PollGroup is a set of registers polled with the same interval.
+// Registers are named as in snapshots, e.g. Temperature or SerialNum.
+type PollGroup struct {
+	Name      string   `json:"name"`
+	Interval  int      `json:"interval"` // seconds, 0 means the registers are only read on start
+	Registers []string `json:"registers"`
+}
+
+// Polling defines how often registers are read from devices. Registers which
+// are not in any group are read with the default interval.
+type Polling struct {
+	Interval   int          `json:"interval"` // seconds
+	Groups     []*PollGroup `json:"groups,omitempty"`
+	AfterWrite bool         `json:"afterWrite"` // read registers back after they are written
+}
+
+// Alarm rule conditions
+const (
+	// AlarmAbove and AlarmBelow compare the register value with the rule value
+	AlarmAbove = "above"
+	AlarmBelow = "below"
+	// AlarmIncrease is raised when the counter register has grown since the previous check.
+	// It stays active until it is acknowledged or the counter has not grown for the hold time.
+	AlarmIncrease = "increase"
+	// AlarmBitsSet and AlarmBitsClear are raised when any of the mask bits is set or clear.
+	// The mask is read from MaskRegister if it is given, otherwise the rule value is the mask.
+	AlarmBitsSet   = "bitsSet"
+	AlarmBitsClear = "bitsClear"
+	// AlarmStale is raised when the register has not been read from the device for value seconds
+	AlarmStale = "stale"
+	// AlarmNotRunning is raised when a run is active but the device is not running
+	AlarmNotRunning = "notRunning"
+)
+
+// Alarm severities
+const (
+	AlarmWarning = "warning"
+	AlarmError   = "error"
+)
+
+// AlarmRule defines when an alarm is raised for a device. Registers are named as in snapshots.
+type AlarmRule struct {
+	Name         string   `json:"name"`
+	Register     string   `json:"register,omitempty"`
+	Condition    string   `json:"condition"`
+	Value        int      `json:"value,omitempty"`
+	MaskRegister string   `json:"maskRegister,omitempty"`
+	Severity     string   `json:"severity,omitempty"` // warning or error
+	Devices      []string `json:"devices,omitempty"`  // empty means all devices
+	// Hold is how long an increase alarm stays active after the last increase in seconds,
+	// DefaultAlarmHold is used if it is not set
+	Hold int `json:"hold,omitempty"`
+}
+
+// Health defines how device health is checked and how alarms are reported.
+// Webhook receives alarms as JSON in POST requests. Command is executed by the shell
+// with the alarm as JSON in the standard input.
+type Health struct {
+	Interval    int          `json:"interval"` // seconds
+	Rules       []*AlarmRule `json:"rules,omitempty"`
+	HistorySize int          `json:"historySize,omitempty"`
+	Webhook     string       `json:"webhook,omitempty"`
+	Command     string       `json:"command,omitempty"`
+}
+
+// RegHistory defines which register values are recorded in the history and how long they are kept
+type RegHistory struct {
+	RecordPolls bool `json:"recordPolls"` // record every polled value, not only changes
+	Retention   int  `json:"retention"`   // seconds
+}
+
+// Identity defines how devices declared by serial number are verified
+type Identity struct {
+	// Strict stops the control server if a device does not have the expected serial number
+	Strict bool `json:"strict"`
+	// ResolveIPs takes device IPs from the discover server by serial number
+	ResolveIPs bool `json:"resolveIPs"`
+}
+
+// Compliance defines firmware and hardware revisions allowed for discovered devices.
+// An empty list allows any revision.
+type Compliance struct {
+	Firmware []string `json:"firmware,omitempty"`
+	Hardware []string `json:"hardware,omitempty"`
+}
+
 type Inventory struct {
 	Version    uint8 `json:"version"`
 	DetectorID uint8 `json:"detectorID"` // 33 for NDLAr
@@ -62,22 +179,48 @@ type DeviceInventory struct {
 type Device struct {
 	Name                string  `json:"name,omitempty"`
 	IP                  *net.IP `json:"ip,omitempty"`
+	Serial              string  `json:"serial,omitempty"` // serial ID reported by MLDP, decimal or hexadecimal with 0x prefix
+	AdcChip             string  `json:"adcChip,omitempty"`
 	*TrigSetup          `json:"TriggerSetup,omitempty"`
 	*MAFSetup           `json:"MafSetup,omitempty"`
 	*InvertSetup        `json:"InvertSetup,omitempty"`
 	*ReadoutWindowSetup `json:"ReadoutWindowSetup,omitempty"`
 	*ZsSetup            `json:"ZsSetup,omitempty"`
+	*BaselineSetup      `json:"BaselineSetup,omitempty"`
+	*FirSetup           `json:"FirSetup,omitempty"`
 	*DeviceInventory    `json:"inventory,omitempty"`
+	Channels            *ChannelsSetup `json:"channels,omitempty"`
+	// Poll is false if registers must not be polled from the device
+	Poll *bool `json:"poll,omitempty"`
+	// TimedStart is true if the firmware can start the device at a given White Rabbit time.
+	// The timed start bit is not in the published register map, so it must be enabled
+	// only for firmware which is known to support it. Otherwise devices are started one by one.
+	TimedStart bool `json:"timedStart,omitempty"`
 }
 
 type Config struct {
-	LogLevel      string     `json:"logLevel,omitempty"`
-	DiscoverIP    *net.IP    `json:"discoverIP,omitempty"`
-	DiscoverIface string     `json:"discoverIface,omitempty"`
-	IP            *net.IP    `json:"ip,omitempty"`
-	Devices       []*Device  `json:"devices"`
-	Inventory     *Inventory `json:"inventory,omitempty"`
-	dirpath       string
+	LogLevel      string                `json:"logLevel,omitempty"`
+	DiscoverIP    *net.IP               `json:"discoverIP,omitempty"`
+	DiscoverIface string                `json:"discoverIface,omitempty"`
+	IP            *net.IP               `json:"ip,omitempty"`
+	Devices       []*Device             `json:"devices"`
+	Inventory     *Inventory            `json:"inventory,omitempty"`
+	FirPresets    map[string]*FirPreset `json:"firPresets,omitempty"`
+	Reconcile     *Reconcile            `json:"reconcile,omitempty"`
+	RegHistory    *RegHistory           `json:"regHistory,omitempty"`
+	Polling       *Polling              `json:"polling,omitempty"`
+	Health        *Health               `json:"health,omitempty"`
+	Identity      *Identity             `json:"identity,omitempty"`
+	Compliance    *Compliance           `json:"compliance,omitempty"`
+	// LeaseOwner identifies the user in device leases. Default is user@host.
+	LeaseOwner string `json:"leaseOwner,omitempty"`
+	// AuditFile is the JSON lines file where audit records are appended in addition to the audit database
+	AuditFile string `json:"auditFile,omitempty"`
+	// DiscoverOfflineTimeout is how long a device may be silent before it is marked offline (seconds)
+	DiscoverOfflineTimeout int `json:"discoverOfflineTimeout,omitempty"`
+	// DiscoverIfaces are interfaces to listen on for discovery in addition to DiscoverIface
+	DiscoverIfaces []string `json:"discoverIfaces,omitempty"`
+	dirpath        string
 }
 
 // Persist serialized the config and saves it to the config file
@@ -128,13 +271,161 @@ func (c *Config) GetDeviceByName(name string) (*Device, error) {
 // GetDeviceByIP ...
 func (c *Config) GetDeviceByIP(ip net.IP) (*Device, error) {
 	for i, device := range c.Devices {
-		if device.IP.String() == ip.String() {
+		if device.IP != nil && device.IP.String() == ip.String() {
 			return c.Devices[i], nil
 		}
 	}
 	return nil, errors.New(fmt.Sprintf("Device not found: %s", ip.String()))
 }
 
+// GetDiscoverIfaces returns names of all interfaces to listen on for discovery
+func (c *Config) GetDiscoverIfaces() []string {
+	var ifaces []string
+	for _, iface := range append([]string{c.DiscoverIface}, c.DiscoverIfaces...) {
+		if iface == "" {
+			continue
+		}
+		found := false
+		for _, i := range ifaces {
+			if i == iface {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	return ifaces
+}
+
+// GetDiscoverOfflineTimeout returns how long (seconds) a device may be silent before it is marked offline
+func (c *Config) GetDiscoverOfflineTimeout() int {
+	if c.DiscoverOfflineTimeout > 0 {
+		return c.DiscoverOfflineTimeout
+	}
+	return DefaultDiscoverOfflineTimeout
+}
+
+// GetPolling returns register polling settings, defaults are used if not configured
+func (c *Config) GetPolling() *Polling {
+	if c.Polling == nil {
+		return &Polling{
+			Interval:   DefaultPollInterval,
+			Groups:     DefaultPollGroups(),
+			AfterWrite: true,
+		}
+	}
+	polling := *c.Polling
+	if polling.Interval <= 0 {
+		polling.Interval = DefaultPollInterval
+	}
+	if polling.Groups == nil {
+		polling.Groups = DefaultPollGroups()
+	}
+	return &polling
+}
+
+// DefaultPollGroups returns the groups of status registers which are polled often
+// and of static registers which are polled rarely
+func DefaultPollGroups() []*PollGroup {
+	return []*PollGroup{
+		{
+			Name:     "status",
+			Interval: DefaultPollStatusInterval,
+			Registers: []string{"RunStatus", "Temperature", "AdcStatus", "DesStatus", "ZsEvents",
+				"RunEventNumber", "RunEventNumber64", "WrSyncLostCounter", "WrLinkErrorCounter",
+				"TrigOnXoffErrorCounter", "AdcTimeSec"},
+		},
+		{
+			Name:      "static",
+			Interval:  DefaultPollStaticInterval,
+			Registers: []string{"DeviceId", "AdcInfo", "FwVer", "FwRev", "SerialNum"},
+		},
+	}
+}
+
+// GetCompliance returns allowed revisions of discovered devices, any revision is allowed if not configured
+func (c *Config) GetCompliance() *Compliance {
+	if c.Compliance != nil {
+		return c.Compliance
+	}
+	return &Compliance{}
+}
+
+// GetHealth returns health monitor settings, defaults are used if not configured
+func (c *Config) GetHealth() *Health {
+	health := &Health{}
+	if c.Health != nil {
+		*health = *c.Health
+	}
+	if health.Interval <= 0 {
+		health.Interval = DefaultHealthInterval
+	}
+	if health.Rules == nil {
+		health.Rules = DefaultAlarmRules()
+	}
+	if health.HistorySize <= 0 {
+		health.HistorySize = DefaultAlarmHistorySize
+	}
+	return health
+}
+
+// DefaultAlarmRules returns rules which detect White Rabbit and ADC problems,
+// devices which do not respond and devices which stopped during a run.
+// Temperature is not checked because the register holds the raw sensor value.
+func DefaultAlarmRules() []*AlarmRule {
+	return []*AlarmRule{
+		{Name: "wr sync lost", Register: "WrSyncLostCounter", Condition: AlarmIncrease, Severity: AlarmError},
+		{Name: "wr link errors", Register: "WrLinkErrorCounter", Condition: AlarmIncrease, Severity: AlarmWarning},
+		{Name: "trigger on xoff", Register: "TrigOnXoffErrorCounter", Condition: AlarmIncrease, Severity: AlarmWarning},
+		{Name: "adc status", Register: "AdcStatus", Condition: AlarmBitsClear, MaskRegister: "AdcStatusMask", Severity: AlarmError},
+		{Name: "not responding", Register: "RunStatus", Condition: AlarmStale, Value: DefaultAlarmStale, Severity: AlarmError},
+		{Name: "not running", Register: "RunStatus", Condition: AlarmNotRunning, Severity: AlarmError},
+	}
+}
+
+// SerialID returns the serial ID of the device, ok is false if the serial is not declared
+func (d *Device) SerialID() (serial uint64, ok bool, err error) {
+	if d.Serial == "" {
+		return 0, false, nil
+	}
+	serial, err = strconv.ParseUint(d.Serial, 0, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("Wrong serial of device %s: %s", d.Name, d.Serial)
+	}
+	return serial, true, nil
+}
+
+// PollEnabled returns true if registers are polled from the device
+func (d *Device) PollEnabled() bool {
+	return d.Poll == nil || *d.Poll
+}
+
+// GetRegHistory returns register history settings, defaults are used if not configured
+func (c *Config) GetRegHistory() *RegHistory {
+	history := &RegHistory{Retention: DefaultRegHistoryRetention}
+	if c.RegHistory != nil {
+		history.RecordPolls = c.RegHistory.RecordPolls
+		if c.RegHistory.Retention > 0 {
+			history.Retention = c.RegHistory.Retention
+		}
+	}
+	return history
+}
+
+// GetLeaseOwner returns the owner name used to acquire device leases
+func (c *Config) GetLeaseOwner() string {
+	if c.LeaseOwner != "" {
+		return c.LeaseOwner
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s@%s", os.Getenv("USER"), host)
+}
+
 func DefaultConfigDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -155,6 +446,14 @@ func (c *Config) DiscoverDBPath() string {
 	return filepath.Join(c.dirpath, DiscoverDBFile)
 }
 
+func (c *Config) RunsDBPath() string {
+	return filepath.Join(c.dirpath, RunsDBFile)
+}
+
+func (c *Config) AuditDBPath() string {
+	return filepath.Join(c.dirpath, AuditDBFile)
+}
+
 func NewDefaultConfig() *Config {
 	discoverIP := net.ParseIP(DefaultDiscoverIP)
 	ip := net.ParseIP(DefaultIP)
@@ -19,8 +19,11 @@ const (
 	ConfigFile                    = "config"
 	DBFile                        = "db.bolt"
 	DiscoverDBFile                = "discoverdb.bolt"
+	RunsDBFile                    = "runs.bolt"
+	AuditDBFile                   = "audit.bolt"
 	DefaultDiscoverIP             = "239.192.1.1"
 	DefaultDiscoverIface          = "eth0"
+	DefaultDiscoverOfflineTimeout = 3 // seconds
 	DefaultIP                     = "192.168.1.100"
 	DefaultDeviceName             = "device_0"
 	DefaultDeviceIP               = "192.168.1.101"
@@ -29,4 +32,15 @@ const (
 	DefaultInventoryVersion       = 0
 	DefaultInventoryDetectorID    = 0
 	DefaultLogLevel               = "info"
+	DefaultChannelTrigThr         = 100
+	DefaultChannelZsThr           = -0x8000
+	DefaultChannelBaseline        = 0
+	DefaultRegHistoryRetention    = 7 * 24 * 3600 // seconds
+	DefaultPollInterval           = 30            // seconds
+	DefaultPollStatusInterval     = 5             // seconds
+	DefaultPollStaticInterval     = 3600          // seconds
+	DefaultHealthInterval         = 5             // seconds
+	DefaultAlarmHistorySize       = 1000
+	DefaultAlarmStale             = 30  // seconds
+	DefaultAlarmHold              = 300 // seconds
 )
@@ -26,3 +26,13 @@ type ErrConfigFileExists struct {
 func (e ErrConfigFileExists) Error() string {
 	return fmt.Sprintf("could not create default config at %s, file already exists", e.Path)
 }
+
+// ErrChannelRange is returned when the channel range can not be parsed
+type ErrChannelRange struct {
+	Range string
+	Max   int
+}
+
+func (e ErrChannelRange) Error() string {
+	return fmt.Sprintf("wrong channel range %q, channels must be in range 0..%d", e.Range, e.Max)
+}
@@ -0,0 +1,311 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+/*
+ ADC chips are configured over SPI by means of RegAdcSpi register.
+ A single SPI transaction is two consecutive writes to RegAdcSpi:
+ - the first word is the chip specific instruction (r/w bit and register address)
+ - the second word is the chip select mask (bits 15:8, one bit per ADC chip)
+   and the data byte (bits 7:0); this write starts the transaction
+ The byte read from ADC chips is latched in RegAdcXXSpiRead registers,
+ one register per pair of chips: the low byte is for the even chip and
+ the high byte is for the odd one (chips are counted from 0).
+*/
+
+const (
+	AdcChipAD9249  = "ad9249"
+	AdcChipAD9252  = "ad9252"
+	AdcChipLTM9011 = "ltm9011"
+)
+
+// AdcSpiReg describes an internal register of an ADC chip
+type AdcSpiReg struct {
+	Name        string `json:"name"`
+	Addr        uint16 `json:"addr"`
+	Description string `json:"description"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+}
+
+// AdcChip describes a type of ADC chip installed on a board
+type AdcChip struct {
+	Name string `json:"name"`
+	// Channels is the number of device channels served by a single chip
+	Channels int          `json:"channels"`
+	Regs     []*AdcSpiReg `json:"regs"`
+	// TransferAddr is the address of the register which must be written
+	// to apply the values written to the shadowed registers. Zero means
+	// the chip does not have such a register.
+	TransferAddr  uint16 `json:"-"`
+	TransferValue uint8  `json:"-"`
+	instruction   func(read bool, addr uint16) uint16
+	testPattern   func(pattern uint16, enable bool) []adcSpiWrite
+}
+
+// adcSpiWrite is a single write to an internal register of an ADC chip
+type adcSpiWrite struct {
+	Addr  uint16
+	Value uint8
+}
+
+// NumAdc returns the number of ADC chips on a board
+func (c *AdcChip) NumAdc() int {
+	return Nch / c.Channels
+}
+
+// AdcChannels returns the list of device channels digitized by the given ADC chip
+func (c *AdcChip) AdcChannels(adc int) []int {
+	var channels []int
+	for i := 0; i < c.Channels; i++ {
+		channels = append(channels, adc*c.Channels+i)
+	}
+	return channels
+}
+
+// GetReg returns the chip register by its name or by its address (hexadecimal, e.g. 0x0d)
+func (c *AdcChip) GetReg(nameOrAddr string) (*AdcSpiReg, error) {
+	for _, reg := range c.Regs {
+		if reg.Name == strings.ToLower(nameOrAddr) {
+			return reg, nil
+		}
+	}
+	addr, err := strconv.ParseUint(nameOrAddr, 0, 16)
+	if err != nil {
+		return nil, ErrAdcSpiReg{Chip: c.Name, Reg: nameOrAddr}
+	}
+	for _, reg := range c.Regs {
+		if reg.Addr == uint16(addr) {
+			return reg, nil
+		}
+	}
+	// Undocumented registers are still accessible by address
+	return &AdcSpiReg{Name: fmt.Sprintf("0x%02x", addr), Addr: uint16(addr)}, nil
+}
+
+// adiInstruction encodes the instruction of Analog Devices chips:
+// r/w bit, two bits W1:W0 (00 means one byte transfer) and 13 bits of address
+func adiInstruction(read bool, addr uint16) uint16 {
+	var result uint16
+	if read {
+		result |= 0x8000
+	}
+	return result | (addr & 0x1fff)
+}
+
+// ltcInstruction encodes the instruction of Linear Technology chips:
+// r/w bit and 7 bits of address
+func ltcInstruction(read bool, addr uint16) uint16 {
+	var result uint16
+	if read {
+		result |= 0x80
+	}
+	return result | (addr & 0x7f)
+}
+
+// adiTestPattern makes the chip output the user pattern.
+// Both user patterns are set to the same value so the output is constant.
+func adiTestPattern(pattern uint16, enable bool) []adcSpiWrite {
+	if !enable {
+		return []adcSpiWrite{{Addr: 0x0d, Value: 0x00}}
+	}
+	lsb, msb := uint8(pattern&0xff), uint8(pattern>>8)
+	return []adcSpiWrite{
+		{Addr: 0x19, Value: lsb},
+		{Addr: 0x1a, Value: msb},
+		{Addr: 0x1b, Value: lsb},
+		{Addr: 0x1c, Value: msb},
+		{Addr: 0x0d, Value: 0x08},
+	}
+}
+
+// ltcTestPattern makes the chip output the test pattern instead of the converted data
+func ltcTestPattern(pattern uint16, enable bool) []adcSpiWrite {
+	if !enable {
+		return []adcSpiWrite{{Addr: 0x03, Value: 0x00}}
+	}
+	return []adcSpiWrite{
+		{Addr: 0x04, Value: uint8(pattern & 0xff)},
+		{Addr: 0x03, Value: 0x80 | uint8((pattern>>8)&0x3f)},
+	}
+}
+
+var adiCommonRegs = []*AdcSpiReg{
+	{Name: "spi_port_config", Addr: 0x00, Description: "SPI port configuration (soft reset, LSB first)"},
+	{Name: "chip_id", Addr: 0x01, Description: "Chip ID", ReadOnly: true},
+	{Name: "chip_grade", Addr: 0x02, Description: "Chip speed grade", ReadOnly: true},
+	{Name: "device_index_2", Addr: 0x04, Description: "Channels addressed by the following writes (E..H)"},
+	{Name: "device_index_1", Addr: 0x05, Description: "Channels addressed by the following writes (A..D, DCO, FCO)"},
+	{Name: "power_modes", Addr: 0x08, Description: "Power-down: 0 normal, 1 full power-down, 2 standby"},
+	{Name: "clock", Addr: 0x09, Description: "Duty cycle stabilizer"},
+	{Name: "test_mode", Addr: 0x0d, Description: "Output test pattern: 0 off, 4 checkerboard, 5 PN23, 6 PN9, " +
+		"7 one/zero toggle, 8 user pattern, 9 1/0 bit toggle, 10 1x sync, 11 one bit high, 12 mixed frequency"},
+	{Name: "offset_adjust", Addr: 0x10, Description: "Offset adjustment in LSBs (two's complement)"},
+	{Name: "output_mode", Addr: 0x14, Description: "Output format: bit 0 two's complement, bit 2 invert"},
+	{Name: "output_adjust", Addr: 0x15, Description: "LVDS output drive and termination"},
+	{Name: "output_phase", Addr: 0x16, Description: "Output clock phase"},
+	{Name: "user_patt1_lsb", Addr: 0x19, Description: "User test pattern 1 LSB"},
+	{Name: "user_patt1_msb", Addr: 0x1a, Description: "User test pattern 1 MSB"},
+	{Name: "user_patt2_lsb", Addr: 0x1b, Description: "User test pattern 2 LSB"},
+	{Name: "user_patt2_msb", Addr: 0x1c, Description: "User test pattern 2 MSB"},
+	{Name: "serial_control", Addr: 0x21, Description: "Serial output data control (bit order, word length)"},
+	{Name: "serial_ch_status", Addr: 0x22, Description: "Per channel output power-down"},
+	{Name: "device_update", Addr: 0xff, Description: "Write 1 to transfer shadowed registers"},
+}
+
+// AdcChips contains definitions of all supported ADC chips
+var AdcChips = map[string]*AdcChip{
+	AdcChipAD9249: {
+		Name:          AdcChipAD9249,
+		Channels:      16,
+		Regs:          adiCommonRegs,
+		TransferAddr:  0xff,
+		TransferValue: 0x01,
+		instruction:   adiInstruction,
+		testPattern:   adiTestPattern,
+	},
+	AdcChipAD9252: {
+		Name:          AdcChipAD9252,
+		Channels:      8,
+		Regs:          adiCommonRegs,
+		TransferAddr:  0xff,
+		TransferValue: 0x01,
+		instruction:   adiInstruction,
+		testPattern:   adiTestPattern,
+	},
+	AdcChipLTM9011: {
+		Name:     AdcChipLTM9011,
+		Channels: 8,
+		Regs: []*AdcSpiReg{
+			{Name: "reset", Addr: 0x00, Description: "Write 0x80 to reset all registers"},
+			{Name: "power_down", Addr: 0x01, Description: "Power-down: 0 normal, 1 nap, 3 sleep; bits 7:4 per channel nap"},
+			{Name: "output_mode", Addr: 0x02, Description: "Output mode: LVDS current, termination, lanes, digital outputs"},
+			{Name: "test_pattern_msb", Addr: 0x03, Description: "Bit 7 enables test pattern, bits 5:0 pattern MSB"},
+			{Name: "test_pattern_lsb", Addr: 0x04, Description: "Test pattern LSB"},
+		},
+		instruction: ltcInstruction,
+		testPattern: ltcTestPattern,
+	},
+}
+
+// AdcChipNames returns sorted names of supported ADC chips
+func AdcChipNames() []string {
+	var names []string
+	for name := range AdcChips {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetAdcChip returns the definition of the ADC chip installed on the device
+func (d *Device) GetAdcChip() (*AdcChip, error) {
+	return AdcChipFor(d.Device)
+}
+
+// AdcChipFor returns the definition of the ADC chip defined in the device config
+func AdcChipFor(cfg *config.Device) (*AdcChip, error) {
+	name := cfg.AdcChip
+	if name == "" {
+		name = DefaultAdcChip
+	}
+	chip, ok := AdcChips[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrAdcChip{Name: name}
+	}
+	return chip, nil
+}
+
+func adcSpiReadReg(adc int) uint16 {
+	return RegMap[RegAdc12SpiRead] + uint16(adc/2)
+}
+
+func (d *Device) adcSpiOps(chip *AdcChip, adc int, read bool, addr uint16, value uint8) ([]*layers.RegOp, error) {
+	if adc < 0 || adc >= chip.NumAdc() {
+		return nil, ErrAdcIndex{Adc: adc, Max: chip.NumAdc() - 1}
+	}
+	csMask := uint16(1) << uint16(adc)
+	return []*layers.RegOp{
+		{Reg: &layers.Reg{Addr: RegMap[RegAdcSpi], Value: chip.instruction(read, addr)}},
+		{Reg: &layers.Reg{Addr: RegMap[RegAdcSpi], Value: csMask<<8 | uint16(value)}},
+	}, nil
+}
+
+// AdcSpiWrite writes the value to the internal register of the ADC chip
+func (d *Device) AdcSpiWrite(adc int, addr uint16, value uint8) error {
+	chip, err := d.GetAdcChip()
+	if err != nil {
+		return err
+	}
+	ops, err := d.adcSpiOps(chip, adc, false, addr, value)
+	if err != nil {
+		return err
+	}
+	if chip.TransferAddr != 0 && addr != chip.TransferAddr {
+		transferOps, _ := d.adcSpiOps(chip, adc, false, chip.TransferAddr, chip.TransferValue)
+		ops = append(ops, transferOps...)
+	}
+	_, err = d.ctrl.RegRequestSync(ops, d.IP)
+	return err
+}
+
+// AdcSpiRead reads the internal register of the ADC chip
+func (d *Device) AdcSpiRead(adc int, addr uint16) (uint8, error) {
+	chip, err := d.GetAdcChip()
+	if err != nil {
+		return 0, err
+	}
+	ops, err := d.adcSpiOps(chip, adc, true, addr, 0)
+	if err != nil {
+		return 0, err
+	}
+	ops = append(ops, &layers.RegOp{Read: true, Reg: &layers.Reg{Addr: adcSpiReadReg(adc)}})
+	resp, err := d.ctrl.RegRequestSync(ops, d.IP)
+	if err != nil {
+		return 0, err
+	}
+	for _, op := range resp {
+		if op.Read && op.Addr == adcSpiReadReg(adc) {
+			if adc%2 == 0 {
+				return uint8(op.Value & 0xff), nil
+			}
+			return uint8(op.Value >> 8), nil
+		}
+	}
+	return 0, ErrAdcSpiResponse{Adc: adc, Addr: addr}
+}
+
+// AdcTestPattern enables/disables the constant test pattern on the output of the ADC chip
+func (d *Device) AdcTestPattern(adc int, pattern uint16, enable bool) error {
+	chip, err := d.GetAdcChip()
+	if err != nil {
+		return err
+	}
+	for _, write := range chip.testPattern(pattern, enable) {
+		if err := d.AdcSpiWrite(adc, write.Addr, write.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"context"
+	"math"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+)
+
+// Analog offsets are set by two MAX5501/MAX5502 DAC chips, 32 channels each.
+// The value written to RegDacMax5501 (channels 0..31) or RegDacMax5502 (channels 32..63)
+// contains the DAC channel in bits 15:11 and the DAC code in bits 10:0.
+const (
+	DacChannels    = 32
+	DacCodeMax     = 0x7ff
+	DacCodeDefault = 0x400
+	dacChShift     = 11
+)
+
+const (
+	BaselineToleranceDefault = 16
+	// BaselineSamples is the number of raw ADC samples used to measure a pedestal
+	BaselineSamples = 16
+	// BaselineMaxIter limits the number of equalization steps
+	BaselineMaxIter = 10
+	// BaselineProbeStep is the DAC step used to measure how a pedestal depends on the DAC code
+	BaselineProbeStep = 64
+)
+
+func dacWord(ch int, code uint16) (uint16, uint16) {
+	reg := RegMap[RegDacMax5501]
+	if ch >= DacChannels {
+		reg = RegMap[RegDacMax5502]
+	}
+	return reg, uint16(ch%DacChannels)<<dacChShift | code&DacCodeMax
+}
+
+func checkCh(ch int) error {
+	if ch < 0 || ch >= Nch {
+		return ErrChIndex{Ch: ch}
+	}
+	return nil
+}
+
+func copyBaselineSetup(setup *config.BaselineSetup) *config.BaselineSetup {
+	return &config.BaselineSetup{
+		Target:    setup.Target,
+		Tolerance: setup.Tolerance,
+		Dac:       append([]uint16{}, setup.Dac...),
+		Digital:   append([]int{}, setup.Digital...),
+	}
+}
+
+// GetBaselineSetup returns a copy of the baseline setup in use. Missing values are filled with defaults.
+func (d *Device) GetBaselineSetup() *config.BaselineSetup {
+	setup := &config.BaselineSetup{Tolerance: BaselineToleranceDefault}
+	if d.baselineSetup != nil {
+		setup = copyBaselineSetup(d.baselineSetup)
+	}
+	fillBaselineDefaults(setup)
+	return setup
+}
+
+// initBaselineSetup creates the baseline setup in use if there is none and fills missing values
+// with defaults. It is used when baselines are changed, so the whole setup is saved with settings.
+func (d *Device) initBaselineSetup() *config.BaselineSetup {
+	if d.baselineSetup == nil {
+		d.baselineSetup = &config.BaselineSetup{Tolerance: BaselineToleranceDefault}
+	}
+	fillBaselineDefaults(d.baselineSetup)
+	return d.baselineSetup
+}
+
+func fillBaselineDefaults(setup *config.BaselineSetup) {
+	for len(setup.Dac) < Nch {
+		setup.Dac = append(setup.Dac, DacCodeDefault)
+	}
+	for len(setup.Digital) < Nch {
+		setup.Digital = append(setup.Digital, 0)
+	}
+}
+
+// SetDac sets the analog offset DAC code of the channel
+func (d *Device) SetDac(ch int, code uint16) error {
+	if err := checkCh(ch); err != nil {
+		return err
+	}
+	if code > DacCodeMax {
+		return ErrDacCode{Code: int(code)}
+	}
+	addr, value := dacWord(ch, code)
+	ops := []*layers.RegOp{
+		{Reg: &layers.Reg{Addr: addr, Value: value}},
+	}
+	if err := d.ctrl.RegRequest(ops, d.IP); err != nil {
+		return err
+	}
+	d.initBaselineSetup().Dac[ch] = code
+	return nil
+}
+
+// SetDigitalBaseline sets the baseline which is subtracted from samples by firmware
+func (d *Device) SetDigitalBaseline(ch int, val int) error {
+	if err := checkCh(ch); err != nil {
+		return err
+	}
+	if err := d.WriteChReg(ch, MemMap[MemChBaseline], uint32(val)); err != nil {
+		return err
+	}
+	d.ChSettings[ch].BaseLine = val
+	d.initBaselineSetup().Digital[ch] = val
+	return nil
+}
+
+// ApplyBaselineSetup writes DAC codes and digital baselines to the device
+func (d *Device) ApplyBaselineSetup(setup *config.BaselineSetup) error {
+	for ch, code := range setup.Dac {
+		if ch >= Nch {
+			break
+		}
+		if err := d.SetDac(ch, code); err != nil {
+			return err
+		}
+	}
+	for ch, val := range setup.Digital {
+		if ch >= Nch {
+			break
+		}
+		if err := d.SetDigitalBaseline(ch, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measurePedestals reads raw ADC samples of the channels and returns their mean and rms indexed by channel
+func (d *Device) measurePedestals(channels []int) ([]float64, []float64, error) {
+	signed := d.HasAdcRawDataSigned()
+	mean := make([]float64, Nch)
+	rms := make([]float64, Nch)
+	for _, ch := range channels {
+		var sum, sum2 float64
+		for i := 0; i < BaselineSamples; i++ {
+			raw, err := d.ReadChReg(ch, MemMap[MemChAdcData])
+			if err != nil {
+				return nil, nil, err
+			}
+			var sample float64
+			if signed {
+				sample = float64(int16(uint16(raw)))
+			} else {
+				sample = float64(int(uint16(raw)) - 0x8000)
+			}
+			sum += sample
+			sum2 += sample * sample
+		}
+		mean[ch] = sum / BaselineSamples
+		rms[ch] = math.Sqrt(math.Max(sum2/BaselineSamples-mean[ch]*mean[ch], 0))
+	}
+	return mean, rms, nil
+}
+
+func allChannels() []int {
+	channels := make([]int, Nch)
+	for ch := range channels {
+		channels[ch] = ch
+	}
+	return channels
+}
+
+func (d *Device) baselineResult(mean, rms []float64) *layers.BaselineResult {
+	setup := d.GetBaselineSetup()
+	result := &layers.BaselineResult{
+		Device:    d.Name,
+		Target:    setup.Target,
+		Tolerance: setup.Tolerance,
+	}
+	for ch := 0; ch < Nch; ch++ {
+		result.Channels = append(result.Channels, &layers.BaselineChannel{
+			Ch:       ch,
+			Dac:      setup.Dac[ch],
+			Digital:  setup.Digital[ch],
+			Pedestal: mean[ch],
+			Rms:      rms[ch],
+			Ok:       math.Abs(mean[ch]-float64(setup.Target)) <= float64(setup.Tolerance),
+		})
+	}
+	return result
+}
+
+// MeasureBaseline measures pedestals of all channels
+func (d *Device) MeasureBaseline() (*layers.BaselineResult, error) {
+	mean, rms, err := d.measurePedestals(allChannels())
+	if err != nil {
+		return nil, err
+	}
+	return d.baselineResult(mean, rms), nil
+}
+
+func clampDac(code int) int {
+	if code < 0 {
+		return 0
+	}
+	if code > DacCodeMax {
+		return DacCodeMax
+	}
+	return code
+}
+
+// queuedPedestals measures pedestals like measurePedestals but takes the device queue for each channel
+func (d *Device) queuedPedestals(ctx context.Context, channels []int) ([]float64, []float64, error) {
+	mean := make([]float64, Nch)
+	rms := make([]float64, Nch)
+	for _, ch := range channels {
+		ch := ch
+		err := d.DoContext(ctx, func() error {
+			chMean, chRms, err := d.measurePedestals([]int{ch})
+			if err != nil {
+				return err
+			}
+			mean[ch], rms[ch] = chMean[ch], chRms[ch]
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return mean, rms, nil
+}
+
+// EqualizeBaseline adjusts DAC codes until pedestals of all channels are within tolerance of the target.
+// First each DAC code is moved by BaselineProbeStep to find how the pedestal depends on it,
+// then codes are corrected using the secant method.
+// It must be called outside Do, every step takes the device queue by itself.
+func (d *Device) EqualizeBaseline(ctx context.Context, target, tolerance int) (*layers.BaselineResult, error) {
+	finish, err := d.startJob("baseline equalization")
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+	if tolerance <= 0 {
+		tolerance = BaselineToleranceDefault
+	}
+	log.Info("Equalizing baseline: device: %s target: %d tolerance: %d", d.Name, target, tolerance)
+
+	codes := make([]int, Nch)
+	err = d.DoContext(ctx, func() error {
+		setup := d.initBaselineSetup()
+		setup.Target = target
+		setup.Tolerance = tolerance
+		for ch := range codes {
+			codes[ch] = int(setup.Dac[ch])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	setDac := func(ch, code int) error {
+		return d.DoContext(ctx, func() error { return d.SetDac(ch, uint16(code)) })
+	}
+
+	channels := allChannels()
+	mean, rms, err := d.queuedPedestals(ctx, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	probes := make([]int, Nch)
+	slopes := make([]float64, Nch)
+	for ch := range codes {
+		probes[ch] = codes[ch] + BaselineProbeStep
+		if probes[ch] > DacCodeMax {
+			probes[ch] = codes[ch] - BaselineProbeStep
+		}
+		if err := setDac(ch, probes[ch]); err != nil {
+			return nil, err
+		}
+	}
+	probeMean, probeRms, err := d.queuedPedestals(ctx, channels)
+	if err != nil {
+		return nil, err
+	}
+	for ch := range codes {
+		slopes[ch] = (probeMean[ch] - mean[ch]) / float64(probes[ch]-codes[ch])
+		codes[ch] = probes[ch]
+	}
+	mean, rms = probeMean, probeRms
+
+	for iter := 0; iter < BaselineMaxIter; iter++ {
+		var active []int
+		nexts := make([]int, Nch)
+		for _, ch := range channels {
+			if math.Abs(mean[ch]-float64(target)) <= float64(tolerance) {
+				continue
+			}
+			if math.Abs(slopes[ch]) < 1e-3 {
+				log.Warning("Pedestal does not depend on DAC code: device: %s channel: %d", d.Name, ch)
+				continue
+			}
+			nexts[ch] = clampDac(codes[ch] + int(math.Round((float64(target)-mean[ch])/slopes[ch])))
+			if nexts[ch] == codes[ch] {
+				continue
+			}
+			if err := setDac(ch, nexts[ch]); err != nil {
+				return nil, err
+			}
+			active = append(active, ch)
+		}
+		if len(active) == 0 {
+			break
+		}
+
+		nextMean, nextRms, err := d.queuedPedestals(ctx, active)
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range active {
+			slope := (nextMean[ch] - mean[ch]) / float64(nexts[ch]-codes[ch])
+			// keep the previous slope if the new one is obviously wrong because of noise
+			if slope*slopes[ch] > 0 {
+				slopes[ch] = slope
+			}
+			codes[ch] = nexts[ch]
+			mean[ch] = nextMean[ch]
+			rms[ch] = nextRms[ch]
+		}
+		log.Debug("Baseline equalization step: device: %s iteration: %d channels: %d", d.Name, iter, len(active))
+	}
+
+	var result *layers.BaselineResult
+	err = d.DoContext(ctx, func() error {
+		result = d.baselineResult(mean, rms)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, ch := range result.Channels {
+		if !ch.Ok {
+			log.Warning("Baseline not equalized: device: %s channel: %d pedestal: %.1f", d.Name, ch.Ch, ch.Pedestal)
+		}
+	}
+	return result, nil
+}
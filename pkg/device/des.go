@@ -0,0 +1,253 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"context"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	// DesTrainPattern is the constant ADC output used while training (14 bits, both edges toggle)
+	DesTrainPattern uint16 = 0x2b4c
+	// DesTrainDwell is how long mismatches are counted for each tap value
+	DesTrainDwell = 10 * time.Millisecond
+	// DesBucket is the state bucket where training results are stored by device name
+	DesBucket = "des"
+)
+
+func desBankCtrl(bank int, ctrl uint16) uint16 {
+	return ctrl | ((uint16(bank) << RegDesCtrlBankShift) & RegDesCtrlBankMask)
+}
+
+// desLoadTapOps returns register operations loading the tap value to channels of the bank given by mask
+func desLoadTapOps(bank int, ctrl, tap, mask uint16) []*layers.RegOp {
+	return []*layers.RegOp{
+		{Reg: &layers.Reg{Addr: RegMap[RegDesCtrl], Value: desBankCtrl(bank, ctrl)}},
+		{Reg: &layers.Reg{Addr: RegMap[RegDesIdelayTapVal], Value: tap}},
+		{Reg: &layers.Reg{Addr: RegMap[RegDesIdelayLoadMask], Value: mask}},
+	}
+}
+
+// ReadChReg reads a single word from the channel memory
+func (d *Device) ReadChReg(ch int, addr uint32) (uint32, error) {
+	op := &layers.MemOp{
+		Read: true,
+		Addr: MemBitSelectCtrl | addr | ChBaseMemAddr(ch),
+		Size: 1,
+	}
+	resp, err := d.ctrl.MemRequestSync(op, d.IP)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Data) < 1 {
+		return 0, ErrMemResponse{Ch: ch, Addr: addr}
+	}
+	return resp.Data[0], nil
+}
+
+// ApplyDesTaps loads IDELAY tap values to deserializer channels
+func (d *Device) ApplyDesTaps(channels []*layers.DesChannel) error {
+	var ops []*layers.RegOp
+	for _, ch := range channels {
+		if !ch.Ok {
+			continue
+		}
+		ops = append(ops, desLoadTapOps(ch.Ch/DesBankSize, 0, ch.Tap, uint16(1)<<uint16(ch.Ch%DesBankSize))...)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	ops = append(ops, &layers.RegOp{Reg: &layers.Reg{Addr: RegMap[RegDesCtrl], Value: 0}})
+	return d.ctrl.RegRequest(ops, d.IP)
+}
+
+// GetDesResult returns the last stored training result
+func (d *Device) GetDesResult() (*layers.DesTrainResult, error) {
+	result := &layers.DesTrainResult{}
+	if err := d.state.Get(DesBucket, d.Name, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// LoadDesTaps applies the last stored training result if there is one
+func (d *Device) LoadDesTaps() error {
+	result, err := d.GetDesResult()
+	if err != nil {
+		if _, ok := err.(srv.ErrNotFound); ok {
+			log.Info("No stored deserializer taps: device: %s", d.Name)
+			return nil
+		}
+		return err
+	}
+	log.Info("Loading stored deserializer taps: device: %s", d.Name)
+	return d.ApplyDesTaps(result.Channels)
+}
+
+// desEye finds the widest range of taps without mismatches
+func desEye(mismatches []uint32) (int, int) {
+	bestStart, bestWidth := 0, 0
+	start := -1
+	for tap := 0; tap <= len(mismatches); tap++ {
+		if tap < len(mismatches) && mismatches[tap] == 0 {
+			if start < 0 {
+				start = tap
+			}
+			continue
+		}
+		if start >= 0 && tap-start > bestWidth {
+			bestStart, bestWidth = start, tap-start
+		}
+		start = -1
+	}
+	return bestStart, bestWidth
+}
+
+// scanDesTap loads the tap value to all channels and returns the number of mismatches for each channel
+func (d *Device) scanDesTap(ctx context.Context, tap uint16) ([]uint32, error) {
+	var ops []*layers.RegOp
+	for bank := 0; bank < Nch/DesBankSize; bank++ {
+		ops = append(ops, desLoadTapOps(bank, RegDesCtrlBitPatternCheck, tap, 0xffff)...)
+	}
+	ops = append(ops,
+		&layers.RegOp{Reg: &layers.Reg{Addr: RegMap[RegDesCtrl], Value: RegDesCtrlBitPatternCheck | RegDesCtrlBitCntReset}},
+		&layers.RegOp{Reg: &layers.Reg{Addr: RegMap[RegDesCtrl], Value: RegDesCtrlBitPatternCheck}},
+	)
+	err := d.DoContext(ctx, func() error {
+		_, err := d.ctrl.RegRequestSync(ops, d.IP)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sleep(ctx, DesTrainDwell); err != nil {
+		return nil, err
+	}
+
+	mismatches := make([]uint32, Nch)
+	for ch := 0; ch < Nch; ch++ {
+		ch := ch
+		err := d.DoContext(ctx, func() (err error) {
+			mismatches[ch], err = d.ReadChReg(ch, MemMap[MemChAdcPatternMismatchCnt])
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mismatches, nil
+}
+
+func (d *Device) setAdcTestPattern(ctx context.Context, enable bool) error {
+	var chip *AdcChip
+	err := d.DoContext(ctx, func() (err error) {
+		chip, err = d.GetAdcChip()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for adc := 0; adc < chip.NumAdc(); adc++ {
+		adc := adc
+		if err := d.DoContext(ctx, func() error { return d.AdcTestPattern(adc, DesTrainPattern, enable) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrainDes aligns the deserializers. It puts all ADC chips into the test pattern mode,
+// scans IDELAY taps for every channel, picks the centre of the error-free eye and loads it.
+// It must be called outside Do, every step takes the device queue by itself.
+func (d *Device) TrainDes(ctx context.Context) (*layers.DesTrainResult, error) {
+	finish, err := d.startJob("deserializer training")
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+	log.Info("Training deserializers: device: %s", d.Name)
+
+	if err := d.setAdcTestPattern(ctx, true); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the test pattern is disabled even if the training is cancelled
+		if err := d.setAdcTestPattern(context.Background(), false); err != nil {
+			log.Error("Error while disabling ADC test pattern: device: %s error: %s", d.Name, err)
+		}
+	}()
+
+	for ch := 0; ch < Nch; ch++ {
+		ch := ch
+		err := d.DoContext(ctx, func() error {
+			return d.WriteChReg(ch, MemMap[MemChAdcPattern], uint32(DesTrainPattern))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// mismatches[ch][tap]
+	mismatches := make([][]uint32, Nch)
+	for ch := range mismatches {
+		mismatches[ch] = make([]uint32, DesTapNum)
+	}
+	for tap := 0; tap < DesTapNum; tap++ {
+		counts, err := d.scanDesTap(ctx, uint16(tap))
+		if err != nil {
+			return nil, err
+		}
+		for ch, cnt := range counts {
+			mismatches[ch][tap] = cnt
+		}
+	}
+
+	result := &layers.DesTrainResult{
+		Device:    d.Name,
+		Timestamp: uint64(time.Now().UnixNano()) / uint64(time.Millisecond),
+	}
+	for ch := 0; ch < Nch; ch++ {
+		start, width := desEye(mismatches[ch])
+		desCh := &layers.DesChannel{
+			Ch:       ch,
+			EyeStart: start,
+			EyeWidth: width,
+			Ok:       width > 0,
+		}
+		if desCh.Ok {
+			desCh.Tap = uint16(start + width/2)
+		} else {
+			log.Warning("No error-free eye found: device: %s channel: %d", d.Name, ch)
+		}
+		result.Channels = append(result.Channels, desCh)
+	}
+
+	if err := d.DoContext(ctx, func() error { return d.ApplyDesTaps(result.Channels) }); err != nil {
+		return nil, err
+	}
+	if err := d.state.Put(DesBucket, d.Name, result); err != nil {
+		return nil, err
+	}
+	if failed := result.Failed(); len(failed) > 0 {
+		log.Warning("Deserializer training failed for channels: device: %s channels: %v", d.Name, failed)
+	}
+	return result, nil
+}
@@ -15,7 +15,9 @@
 package device
 
 import (
+	"fmt"
 	"net"
+	"sync"
 
 	"jinr.ru/greenlab/go-adc/pkg/config"
 	deviceifc "jinr.ru/greenlab/go-adc/pkg/device/ifc"
@@ -27,6 +29,7 @@ const (
 	Nch                = 64
 	FirRoundoffDefault = 1
 	FirRoundoffMax     = 3
+	DefaultAdcChip     = AdcChipAD9252
 )
 
 type FwVersion struct {
@@ -35,6 +38,10 @@ type FwVersion struct {
 	Revision uint16
 }
 
+func (v *FwVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Revision)
+}
+
 type FirParams struct {
 	PresetKey string
 	Enabled   bool
@@ -52,6 +59,13 @@ func NewFirParams() *FirParams {
 	return f
 }
 
+func (f *FirParams) control() uint16 {
+	if f.Enabled {
+		return 1
+	}
+	return 0
+}
+
 func (f *FirParams) setRoundoff(value uint16) {
 	if value < 0 {
 		value = 0
@@ -120,8 +134,19 @@ type Device struct {
 	InvertZeroSupperssionThreshold bool
 	SoftwareZeroSuppression        bool
 	dspParams                      *DspParams
-	ctrl                           ifc.ControlServer
-	state                          ifc.State
+	// baselineSetup is the baseline setup in use. It is copied from config and changed
+	// by calibration, which is saved with settings, so config is never modified.
+	baselineSetup *config.BaselineSetup
+	firPresets    map[string]*config.FirPreset
+	ctrl          ifc.ControlServer
+	state         ifc.State
+	queue         chan *command
+	// savedSettings are software settings as they are stored in the state database
+	savedSettings     *layers.DeviceSettings
+	settingsTimestamp uint64
+	// job is the kind of the long job running on the device
+	jobMu sync.Mutex
+	job   string
 }
 
 var _ deviceifc.Device = &Device{}
@@ -137,18 +162,24 @@ func NewDevice(device *config.Device, ctrl ifc.ControlServer, state ifc.State) (
 		InvertThresholdTrigger:         false,
 		InvertZeroSupperssionThreshold: false,
 		SoftwareZeroSuppression:        false,
+		dspParams:                      NewDspParams(),
 		ctrl:                           ctrl,
 		state:                          state,
+		queue:                          make(chan *command, QueueSize),
+	}
+	if device.BaselineSetup != nil {
+		d.baselineSetup = copyBaselineSetup(device.BaselineSetup)
 	}
 	for i := 0; i < Nch; i++ {
 		d.ChSettings[i] = &ChannelSettings{
 			Enabled:          true,
-			BaseLine:         0,
+			BaseLine:         config.DefaultChannelBaseline,
 			TriggerEnabled:   true,
-			TriggerThreshold: 100,
-			ZeroThreshold:    -0x8000,
+			TriggerThreshold: config.DefaultChannelTrigThr,
+			ZeroThreshold:    config.DefaultChannelZsThr,
 		}
 	}
+	go d.serve()
 	return d, nil
 }
 
@@ -157,6 +188,11 @@ func (d *Device) GetName() string {
 	return d.Name
 }
 
+// GetConfig ...
+func (d *Device) GetConfig() *config.Device {
+	return d.Device
+}
+
 // GetIP ...
 func (d *Device) GetIP() *net.IP {
 	return d.IP
@@ -177,6 +213,11 @@ func (d *Device) RegReadAll() ([]*layers.Reg, error) {
 	return d.state.GetRegs(d.Name, regs)
 }
 
+// RegTimes ...
+func (d *Device) RegTimes(addrs []uint16) (map[uint16]uint64, error) {
+	return d.state.GetRegTimes(d.Name, addrs)
+}
+
 // TODO: Write to state
 // RegWrite ...
 func (d *Device) RegWrite(reg *layers.Reg) error {
@@ -205,13 +246,31 @@ func (d *Device) ReadFirmware() error {
 		return err
 	}
 
-	d.fwVersion.Major = (ver.Value >> 8) & 0xFF
-	d.fwVersion.Minor = ver.Value & 0xFF
-	d.fwVersion.Revision = rev.Value
+	d.fwVersion = &FwVersion{
+		Major:    (ver.Value >> 8) & 0xFF,
+		Minor:    ver.Value & 0xFF,
+		Revision: rev.Value,
+	}
 
 	return nil
 }
 
+// ReadSerialNum reads the serial number register from the device.
+// It is expected to contain the lower 16 bits of the serial ID reported by MLDP,
+// so the MLDP serial is to be preferred when it is available.
+func (d *Device) ReadSerialNum() (uint16, error) {
+	regs, err := d.readRegs(RegMap[RegSerialNum])
+	if err != nil {
+		return 0, err
+	}
+	return regs[RegMap[RegSerialNum]], nil
+}
+
+// HasTimedStart returns true if the device firmware is declared in config to support the timed start
+func (d *Device) HasTimedStart() bool {
+	return d.TimedStart
+}
+
 func (d *Device) HasAdcRawDataSigned() bool {
 	d.ReadFirmware()
 	if d.fwVersion == nil {
@@ -250,10 +309,10 @@ func (d *Device) TruncateValue(val int) int {
 
 func (d *Device) SetTrigger(bitmask uint16, val bool) error {
 	state, err := d.RegRead(RegMap[RegTrigCtrl])
-	reg := state.Value
 	if err != nil {
 		return err
 	}
+	reg := state.Value
 
 	if val {
 		reg |= bitmask
@@ -304,17 +363,27 @@ func (d *Device) SetRoundoff(val uint16) error {
 		d.WriteChCtrl(i)
 	}
 
-	return nil
+	ops := []*layers.RegOp{
+		{Reg: &layers.Reg{Addr: RegMap[RegFirRoundoff], Value: d.dspParams.fir.Roundoff}},
+	}
+	return d.ctrl.RegRequest(ops, d.IP)
 }
 
-func (d *Device) SetFirCoef(val []uint16) error {
+func (d *Device) SetFirCoef(val []int) error {
+	coef, err := FirCoefToRegs(val)
+	if err != nil {
+		return err
+	}
+	d.dspParams.fir.Coef = coef
+	d.dspParams.fir.PresetKey = ""
+
 	ops := []*layers.RegOp{
-		{Reg: &layers.Reg{Addr: RegMap[RegFirControl], Value: 1}}, //need to implement setting fir on/of
+		{Reg: &layers.Reg{Addr: RegMap[RegFirControl], Value: d.dspParams.fir.control()}},
 		{Reg: &layers.Reg{Addr: RegMap[RegFirRoundoff], Value: d.dspParams.fir.Roundoff}},
 	}
 
-	for i := 0; i < 16; i++ {
-		ops = append(ops, &layers.RegOp{Reg: &layers.Reg{Addr: RegMap[RegFirCoefStart] + uint16(i), Value: val[i]}})
+	for i := 0; i < FirCoefNum; i++ {
+		ops = append(ops, &layers.RegOp{Reg: &layers.Reg{Addr: RegMap[RegFirCoefStart] + uint16(i), Value: coef[i]}})
 	}
 
 	ops = append(ops, &layers.RegOp{Reg: &layers.Reg{Addr: RegMap[RegFirCoefCtrl], Value: 1}},
@@ -338,24 +407,71 @@ func (d *Device) SetLatency(val uint16) error {
 }
 
 func (d *Device) SetChannels(val layers.ChannelsSetup) error {
-	for i := 0; i < len(val.Channels); i++ {
-		id := val.Channels[i].Id
-		d.ChSettings[id].Enabled = val.Channels[id].En
-		d.ChSettings[id].TriggerEnabled = val.Channels[id].TrigEn
-		d.ChSettings[id].TriggerThreshold = val.Channels[id].TrigThr //to fix
-		d.WriteChReg(id, MemMap[MemChCtrl], uint32(d.encodeChCtrlRegValue(i)))
+	for _, ch := range val.Channels {
+		if err := checkCh(ch.Id); err != nil {
+			return err
+		}
+	}
+	for _, ch := range val.Channels {
+		id := ch.Id
+		d.ChSettings[id].Enabled = ch.En
+		d.ChSettings[id].TriggerEnabled = ch.TrigEn
+		d.ChSettings[id].TriggerThreshold = ch.TrigThr
+		d.ChSettings[id].ZeroThreshold = ch.ZsThr
+		d.ChSettings[id].BaseLine = ch.Baseline
+		if d.baselineSetup != nil && id < len(d.baselineSetup.Digital) {
+			d.baselineSetup.Digital[id] = ch.Baseline
+		}
+		d.WriteChReg(id, MemMap[MemChCtrl], uint32(d.encodeChCtrlRegValue(id)))
 
-		d.WriteChReg(id, MemMap[MemChBaseline], uint32(val.Channels[id].Baseline))
+		d.WriteChReg(id, MemMap[MemChBaseline], uint32(ch.Baseline))
 
-		thr := d.TruncateValue(val.Channels[id].ZsThr)
+		thr := d.TruncateValue(ch.ZsThr)
 		d.WriteChReg(id, MemMap[MemChZsThr], uint32(thr))
 
-		thr = d.TruncateValue(val.Channels[id].TrigThr)
+		thr = d.TruncateValue(ch.TrigThr)
 		d.WriteChReg(id, MemMap[MemChThr], uint32(thr))
 	}
 	return nil
 }
 
+// ApplyChannelsSetup applies channel settings from config.
+// Settings which are not given in config are left unchanged.
+func (d *Device) ApplyChannelsSetup(setup *config.ChannelsSetup) error {
+	resolved, err := setup.Resolve(Nch)
+	if err != nil {
+		return err
+	}
+	val := layers.ChannelsSetup{}
+	for id, chSetup := range resolved {
+		ch := layers.Channel{
+			Id:       id,
+			En:       d.ChSettings[id].Enabled,
+			TrigEn:   d.ChSettings[id].TriggerEnabled,
+			Baseline: d.ChSettings[id].BaseLine,
+			TrigThr:  d.ChSettings[id].TriggerThreshold,
+			ZsThr:    d.ChSettings[id].ZeroThreshold,
+		}
+		if chSetup.Enabled != nil {
+			ch.En = *chSetup.Enabled
+		}
+		if chSetup.TrigEnabled != nil {
+			ch.TrigEn = *chSetup.TrigEnabled
+		}
+		if chSetup.Baseline != nil {
+			ch.Baseline = *chSetup.Baseline
+		}
+		if chSetup.TrigThr != nil {
+			ch.TrigThr = *chSetup.TrigThr
+		}
+		if chSetup.ZsThr != nil {
+			ch.ZsThr = *chSetup.ZsThr
+		}
+		val.Channels = append(val.Channels, ch)
+	}
+	return d.SetChannels(val)
+}
+
 func (d *Device) SetZs(val bool) error {
 	d.ZeroSuppressionEnabled = val
 
@@ -366,24 +482,26 @@ func (d *Device) SetZs(val bool) error {
 
 // MStreamStart ...
 func (d *Device) MStreamStart() error {
-	var ercBit uint16 = 1
-	if d.ZeroSuppressionEnabled {
-		ercBit = 2
-	}
-
 	var ops []*layers.RegOp
 	ops = []*layers.RegOp{
 		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: 0}},
-		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: 0x8000}},
-		{Reg: &layers.Reg{Addr: RegMap[RegMstreamRunCtrl], Value: ercBit}},
+		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: RegDeviceCtrlBitRun}},
+		{Reg: &layers.Reg{Addr: RegMap[RegMstreamRunCtrl], Value: d.ercBit()}},
 	}
 	return d.ctrl.RegRequest(ops, d.IP)
 }
 
+func (d *Device) ercBit() uint16 {
+	if d.ZeroSuppressionEnabled {
+		return 2
+	}
+	return 1
+}
+
 // MStreamStop ...
 func (d *Device) MStreamStop() error {
 	ops := []*layers.RegOp{
-		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: 1}},
+		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: RegDeviceCtrlBitReset}},
 		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: 0}},
 		{Reg: &layers.Reg{Addr: RegMap[RegMstreamRunCtrl], Value: 0}},
 	}
@@ -508,5 +626,33 @@ func (d *Device) SetDeviceSettingsFromConfig(cfg *config.Device) error {
 		}
 	}
 
+	// digital baselines from BaselineSetup take precedence over channel baselines.
+	// The baseline setup in use is applied, since it may have been calibrated after it was
+	// copied from config. It is taken before channels are set, as they change it.
+	var baselineSetup *config.BaselineSetup
+	if d.baselineSetup != nil {
+		baselineSetup = d.GetBaselineSetup()
+	}
+	if cfg.Channels != nil {
+		err := d.ApplyChannelsSetup(cfg.Channels)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.FirSetup != nil {
+		err := d.ApplyFirSetup(cfg.FirSetup)
+		if err != nil {
+			return err
+		}
+	}
+
+	if baselineSetup != nil {
+		err := d.ApplyBaselineSetup(baselineSetup)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,242 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+// Bits of the channel control word, see encodeChCtrlRegValue
+const (
+	chCtrlBitEnabled    uint16 = 0x8000
+	chCtrlBitInvert     uint16 = 0x4000
+	chCtrlBitTrigEn     uint16 = 0x0800
+	chCtrlBitMafEnabled uint16 = 0x0080
+	chCtrlMafTapSelMask uint16 = 0x0030
+	chCtrlMafTapSelShft        = 4
+)
+
+func setBits(value, mask uint16, set bool) uint16 {
+	if set {
+		return value | mask
+	}
+	return value &^ mask
+}
+
+type driftChecker struct {
+	drifts []*layers.Drift
+}
+
+func (c *driftChecker) check(setting string, desired, actual interface{}) {
+	desiredStr := fmt.Sprint(desired)
+	actualStr := fmt.Sprint(actual)
+	if desiredStr != actualStr {
+		c.drifts = append(c.drifts, &layers.Drift{Setting: setting, Desired: desiredStr, Actual: actualStr})
+	}
+}
+
+// readRegs reads registers bypassing the state cache
+func (d *Device) readRegs(addrs ...uint16) (map[uint16]uint16, error) {
+	var ops []*layers.RegOp
+	for _, addr := range addrs {
+		ops = append(ops, &layers.RegOp{Read: true, Reg: &layers.Reg{Addr: addr}})
+	}
+	resp, err := d.ctrl.RegRequestSync(ops, d.IP)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[uint16]uint16)
+	for _, op := range resp {
+		values[op.Reg.Addr] = op.Reg.Value
+	}
+	return values, nil
+}
+
+func (d *Device) checkTrigDrift(c *driftChecker, setup *config.TrigSetup) error {
+	regs, err := d.readRegs(RegMap[RegTrigCtrl])
+	if err != nil {
+		return err
+	}
+	value := regs[RegMap[RegTrigCtrl]]
+	c.check("TriggerSetup.Timer", setup.Timer, value&RegTrigStatusBitTimer != 0)
+	c.check("TriggerSetup.Threshold", setup.Threshold, value&RegTrigStatusBitThreshold != 0)
+	c.check("TriggerSetup.Lemo", setup.Lemo, value&RegTrigStatusBitLemo != 0)
+	return nil
+}
+
+func (d *Device) checkReadoutWindowDrift(c *driftChecker, setup *config.ReadoutWindowSetup) error {
+	regs, err := d.readRegs(RegMap[RegMstreamDataSizeBytes], RegMap[RegDeviceRlat])
+	if err != nil {
+		return err
+	}
+	c.check("ReadoutWindowSetup.Size", setup.Size, regs[RegMap[RegMstreamDataSizeBytes]])
+	c.check("ReadoutWindowSetup.Latency", setup.Latency, regs[RegMap[RegDeviceRlat]])
+	return nil
+}
+
+func (d *Device) checkFirDrift(c *driftChecker, setup *config.FirSetup) error {
+	roundoff := setup.Roundoff
+	coef := setup.Coef
+	if setup.Preset != "" {
+		preset, ok := d.GetFirPresets()[setup.Preset]
+		if !ok {
+			return ErrFirPreset{Name: setup.Preset}
+		}
+		roundoff = preset.Roundoff
+		coef = preset.Coef
+	} else if len(coef) == 0 {
+		coef = FirPresets["bypass"].Coef
+	}
+	if roundoff > FirRoundoffMax {
+		roundoff = FirRoundoffMax
+	}
+	regs, err := FirCoefToRegs(coef)
+	if err != nil {
+		return err
+	}
+
+	state, err := d.ReadFir()
+	if err != nil {
+		return err
+	}
+	c.check("FirSetup.enabled", setup.Enabled, state.Enabled)
+	c.check("FirSetup.roundoff", roundoff, state.Roundoff)
+	for i, value := range regs {
+		c.check(fmt.Sprintf("FirSetup.coef[%d]", i), int16(value), state.Coef[i])
+	}
+	return nil
+}
+
+// desiredChCtrl returns the channel control word with settings from config applied
+func (d *Device) desiredChCtrl(ch int, cfg *config.Device, chSetup *config.ChannelSetup) uint16 {
+	value := d.encodeChCtrlRegValue(ch)
+	if chSetup != nil && chSetup.Enabled != nil {
+		value = setBits(value, chCtrlBitEnabled, *chSetup.Enabled)
+	}
+	if chSetup != nil && chSetup.TrigEnabled != nil {
+		value = setBits(value, chCtrlBitTrigEn, *chSetup.TrigEnabled)
+	}
+	if cfg.InvertSetup != nil {
+		value = setBits(value, chCtrlBitInvert, cfg.InvertSetup.Invert)
+	}
+	if cfg.MAFSetup != nil {
+		value |= chCtrlBitMafEnabled
+		value = value&^chCtrlMafTapSelMask | (uint16(cfg.MAFSetup.Selector)<<chCtrlMafTapSelShft)&chCtrlMafTapSelMask
+	}
+	return value
+}
+
+func (d *Device) checkChannelsDrift(c *driftChecker, cfg *config.Device) error {
+	var resolved []*config.ChannelSetup
+	if cfg.Channels != nil {
+		var err error
+		resolved, err = cfg.Channels.Resolve(Nch)
+		if err != nil {
+			return err
+		}
+	}
+	if resolved == nil && cfg.InvertSetup == nil && cfg.MAFSetup == nil {
+		return nil
+	}
+
+	read := func(ch int, alias MemAlias) (uint16, error) {
+		value, err := d.ReadChReg(ch, MemMap[alias])
+		return uint16(value), err
+	}
+	for ch := 0; ch < Nch; ch++ {
+		var chSetup *config.ChannelSetup
+		if resolved != nil {
+			chSetup = resolved[ch]
+		}
+
+		ctrl, err := read(ch, MemChCtrl)
+		if err != nil {
+			return err
+		}
+		c.check(fmt.Sprintf("channels[%d].ctrl", ch), fmt.Sprintf("0x%04x", d.desiredChCtrl(ch, cfg, chSetup)), fmt.Sprintf("0x%04x", ctrl))
+
+		if cfg.MAFSetup != nil {
+			blc, err := read(ch, MemChBlcThrHi)
+			if err != nil {
+				return err
+			}
+			c.check(fmt.Sprintf("channels[%d].MafSetup.BLC", ch), uint16(cfg.MAFSetup.BLC), blc)
+		}
+		if chSetup == nil {
+			continue
+		}
+		if chSetup.TrigThr != nil {
+			thr, err := read(ch, MemChThr)
+			if err != nil {
+				return err
+			}
+			c.check(fmt.Sprintf("channels[%d].trigThr", ch), uint16(d.TruncateValue(*chSetup.TrigThr)), thr)
+		}
+		if chSetup.ZsThr != nil {
+			thr, err := read(ch, MemChZsThr)
+			if err != nil {
+				return err
+			}
+			c.check(fmt.Sprintf("channels[%d].zsThr", ch), uint16(d.TruncateValue(*chSetup.ZsThr)), thr)
+		}
+		baseline := chSetup.Baseline
+		// calibrated baselines are desired rather than the ones in config
+		if d.baselineSetup != nil && ch < len(d.baselineSetup.Digital) {
+			baseline = &d.baselineSetup.Digital[ch]
+		}
+		if baseline != nil {
+			value, err := read(ch, MemChBaseline)
+			if err != nil {
+				return err
+			}
+			c.check(fmt.Sprintf("channels[%d].baseline", ch), uint16(*baseline), value)
+		}
+	}
+	return nil
+}
+
+// CheckDrift compares settings read back from the device with settings from config.
+// Only settings given in config are checked. DAC codes can not be read back and are not checked.
+func (d *Device) CheckDrift() (*layers.DriftReport, error) {
+	c := &driftChecker{}
+	cfg := d.Device
+	if cfg.TrigSetup != nil {
+		if err := d.checkTrigDrift(c, cfg.TrigSetup); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ReadoutWindowSetup != nil {
+		if err := d.checkReadoutWindowDrift(c, cfg.ReadoutWindowSetup); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.FirSetup != nil {
+		if err := d.checkFirDrift(c, cfg.FirSetup); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.checkChannelsDrift(c, cfg); err != nil {
+		return nil, err
+	}
+	return &layers.DriftReport{
+		Device:    d.Name,
+		Timestamp: uint64(time.Now().UnixNano()) / uint64(time.Millisecond),
+		Drifts:    c.drifts,
+	}, nil
+}
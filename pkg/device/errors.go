@@ -0,0 +1,191 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+)
+
+// ErrAdcChip returned when the ADC chip type is not supported
+type ErrAdcChip struct {
+	Name string
+}
+
+func (e ErrAdcChip) Error() string {
+	return fmt.Sprintf("Unsupported ADC chip: %s", e.Name)
+}
+
+// ErrAdcIndex returned when the ADC chip index is out of range
+type ErrAdcIndex struct {
+	Adc int
+	Max int
+}
+
+func (e ErrAdcIndex) Error() string {
+	return fmt.Sprintf("Wrong ADC chip index: %d. Must be in range 0..%d", e.Adc, e.Max)
+}
+
+// ErrAdcSpiReg returned when the ADC chip register can not be found
+type ErrAdcSpiReg struct {
+	Chip string
+	Reg  string
+}
+
+func (e ErrAdcSpiReg) Error() string {
+	return fmt.Sprintf("Unknown register of ADC chip %s: %s", e.Chip, e.Reg)
+}
+
+// ErrAdcSpiResponse returned when the response does not contain the value read from the ADC chip
+type ErrAdcSpiResponse struct {
+	Adc  int
+	Addr uint16
+}
+
+func (e ErrAdcSpiResponse) Error() string {
+	return fmt.Sprintf("No value in response: adc: %d addr: 0x%02x", e.Adc, e.Addr)
+}
+
+// ErrMemResponse returned when the response does not contain the data read from the channel memory
+type ErrMemResponse struct {
+	Ch   int
+	Addr uint32
+}
+
+func (e ErrMemResponse) Error() string {
+	return fmt.Sprintf("No data in response: channel: %d addr: 0x%04x", e.Ch, e.Addr)
+}
+
+// ErrChIndex returned when the channel number is out of range
+type ErrChIndex struct {
+	Ch int
+}
+
+func (e ErrChIndex) Error() string {
+	return fmt.Sprintf("Wrong channel number: %d. Must be in range 0..%d", e.Ch, Nch-1)
+}
+
+// ErrDacCode returned when the DAC code is out of range
+type ErrDacCode struct {
+	Code int
+}
+
+func (e ErrDacCode) Error() string {
+	return fmt.Sprintf("Wrong DAC code: %d. Must be in range 0..%d", e.Code, DacCodeMax)
+}
+
+// ErrScanRange returned when the scan range is empty
+type ErrScanRange struct {
+	From int
+	To   int
+}
+
+func (e ErrScanRange) Error() string {
+	return fmt.Sprintf("Wrong scan range: from %d to %d", e.From, e.To)
+}
+
+// ErrFirCoefNum returned when there are too many FIR coefficients
+type ErrFirCoefNum struct {
+	Num int
+}
+
+func (e ErrFirCoefNum) Error() string {
+	return fmt.Sprintf("Wrong number of FIR coefficients: %d. Must not exceed %d", e.Num, FirCoefNum)
+}
+
+// ErrFirCoefRange returned when a FIR coefficient does not fit into 16 bits
+type ErrFirCoefRange struct {
+	Index int
+	Value int
+}
+
+func (e ErrFirCoefRange) Error() string {
+	return fmt.Sprintf("FIR coefficient %d is out of range: %d. Must be in range -32768..32767", e.Index, e.Value)
+}
+
+// ErrFirPreset returned when the FIR preset is not found
+type ErrFirPreset struct {
+	Name string
+}
+
+func (e ErrFirPreset) Error() string {
+	return fmt.Sprintf("FIR preset not found: %s", e.Name)
+}
+
+// ErrFirDesign returned when FIR coefficients can not be designed with given parameters
+type ErrFirDesign struct {
+	Shape  string
+	Reason string
+}
+
+func (e ErrFirDesign) Error() string {
+	return fmt.Sprintf("Unable to design FIR filter %s: %s", e.Shape, e.Reason)
+}
+
+// ErrSnapshotVersion returned when the snapshot version is not supported
+type ErrSnapshotVersion struct {
+	Version int
+}
+
+func (e ErrSnapshotVersion) Error() string {
+	return fmt.Sprintf("Unsupported snapshot version: %d. Must be %d", e.Version, SnapshotVersion)
+}
+
+// ErrDeviceBusy returned when a long job is started while another one is running on the device
+type ErrDeviceBusy struct {
+	Device string
+	Job    string
+}
+
+func (e ErrDeviceBusy) Error() string {
+	return fmt.Sprintf("Device %s is busy: %s is running", e.Device, e.Job)
+}
+
+// ErrNotRunning returned when a job needs triggers to be counted but the device is not running
+type ErrNotRunning struct {
+	Device string
+}
+
+func (e ErrNotRunning) Error() string {
+	return fmt.Sprintf("Device %s is not running, triggers are not counted", e.Device)
+}
+
+// ErrTimedStart returned when the device is armed to start at a given time but the timed start is not enabled
+type ErrTimedStart struct {
+	Device string
+}
+
+func (e ErrTimedStart) Error() string {
+	return fmt.Sprintf("Timed start is not enabled for device %s", e.Device)
+}
+
+// ErrSettingsRead returned when stored settings can not be read from the state database
+type ErrSettingsRead struct {
+	Device string
+	Err    error
+}
+
+func (e ErrSettingsRead) Error() string {
+	return fmt.Sprintf("Unable to read stored settings: device: %s error: %s", e.Device, e.Err)
+}
+
+// ErrSettingsApply returned when stored settings can not be written to the device
+type ErrSettingsApply struct {
+	Device string
+	Err    error
+}
+
+func (e ErrSettingsApply) Error() string {
+	return fmt.Sprintf("Unable to apply stored settings: device: %s error: %s", e.Device, e.Err)
+}
@@ -0,0 +1,255 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"math"
+	"sort"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+const (
+	FirCoefNum = 16
+	// FirCoefUnity is the sum of coefficients of a filter with unity gain
+	FirCoefUnity = math.MaxInt16
+)
+
+const (
+	FirShapeMovingAverage = "ma"
+	FirShapeCrRc          = "crrc"
+	FirShapeLowPass       = "lowpass"
+)
+
+// FirPresets are built-in presets. Presets from config override them.
+var FirPresets = map[string]*config.FirPreset{
+	"bypass": {Roundoff: FirRoundoffDefault, Coef: []int{FirCoefUnity}},
+	"ma4":    {Roundoff: FirRoundoffDefault, Coef: mustDesign(DesignMovingAverage(4))},
+	"ma8":    {Roundoff: FirRoundoffDefault, Coef: mustDesign(DesignMovingAverage(8))},
+	"ma16":   {Roundoff: FirRoundoffDefault, Coef: mustDesign(DesignMovingAverage(16))},
+	"crrc2":  {Roundoff: FirRoundoffDefault, Coef: mustDesign(DesignCrRc(2))},
+	"crrc4":  {Roundoff: FirRoundoffDefault, Coef: mustDesign(DesignCrRc(4))},
+	"lp0.1":  {Roundoff: FirRoundoffDefault, Coef: mustDesign(DesignLowPass(0.1, FirCoefNum))},
+	"lp0.25": {Roundoff: FirRoundoffDefault, Coef: mustDesign(DesignLowPass(0.25, FirCoefNum))},
+}
+
+func mustDesign(coef []int, err error) []int {
+	if err != nil {
+		panic(err)
+	}
+	return coef
+}
+
+// FirCoefToRegs checks coefficients and converts them to register values.
+// Missing coefficients are set to zero.
+func FirCoefToRegs(coef []int) ([]uint16, error) {
+	if len(coef) > FirCoefNum {
+		return nil, ErrFirCoefNum{Num: len(coef)}
+	}
+	regs := make([]uint16, FirCoefNum)
+	for i, c := range coef {
+		if c < math.MinInt16 || c > math.MaxInt16 {
+			return nil, ErrFirCoefRange{Index: i, Value: c}
+		}
+		regs[i] = uint16(int16(c))
+	}
+	return regs, nil
+}
+
+// normalizeFir scales the impulse response to unity gain
+func normalizeFir(h []float64) []int {
+	var sum float64
+	for _, v := range h {
+		sum += v
+	}
+	coef := make([]int, len(h))
+	for i, v := range h {
+		c := int(math.Round(v / sum * FirCoefUnity))
+		if c > math.MaxInt16 {
+			c = math.MaxInt16
+		}
+		if c < math.MinInt16 {
+			c = math.MinInt16
+		}
+		coef[i] = c
+	}
+	return coef
+}
+
+// DesignMovingAverage returns coefficients of the moving average over n samples
+func DesignMovingAverage(n int) ([]int, error) {
+	if n < 1 || n > FirCoefNum {
+		return nil, ErrFirDesign{Shape: FirShapeMovingAverage, Reason: "length must be in range 1..16"}
+	}
+	h := make([]float64, n)
+	for i := range h {
+		h[i] = 1
+	}
+	return normalizeFir(h), nil
+}
+
+// DesignCrRc returns coefficients of the CR-RC shaper with the time constant tau given in samples
+func DesignCrRc(tau float64) ([]int, error) {
+	if tau <= 0 || tau > FirCoefNum/4 {
+		return nil, ErrFirDesign{Shape: FirShapeCrRc, Reason: "tau must be in range (0, 4] samples"}
+	}
+	h := make([]float64, FirCoefNum)
+	for i := range h {
+		t := float64(i) / tau
+		h[i] = t * math.Exp(-t)
+	}
+	return normalizeFir(h), nil
+}
+
+// DesignLowPass returns coefficients of the windowed-sinc low-pass filter.
+// The cutoff frequency is given as a fraction of the sampling frequency.
+func DesignLowPass(cutoff float64, taps int) ([]int, error) {
+	if cutoff <= 0 || cutoff >= 0.5 {
+		return nil, ErrFirDesign{Shape: FirShapeLowPass, Reason: "cutoff must be in range (0, 0.5)"}
+	}
+	if taps < 2 || taps > FirCoefNum {
+		return nil, ErrFirDesign{Shape: FirShapeLowPass, Reason: "number of taps must be in range 2..16"}
+	}
+	h := make([]float64, taps)
+	m := float64(taps - 1)
+	for i := range h {
+		x := float64(i) - m/2
+		if x == 0 {
+			h[i] = 2 * cutoff
+		} else {
+			h[i] = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		// Hamming window
+		h[i] *= 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/m)
+	}
+	return normalizeFir(h), nil
+}
+
+// DesignFir returns coefficients for the given shape. The meaning of param depends on the shape:
+// number of samples for moving average, time constant in samples for CR-RC and
+// cutoff frequency as a fraction of the sampling frequency for low-pass.
+func DesignFir(shape string, param float64) ([]int, error) {
+	switch shape {
+	case FirShapeMovingAverage:
+		return DesignMovingAverage(int(param))
+	case FirShapeCrRc:
+		return DesignCrRc(param)
+	case FirShapeLowPass:
+		return DesignLowPass(param, FirCoefNum)
+	default:
+		return nil, ErrFirDesign{Shape: shape, Reason: "unknown shape"}
+	}
+}
+
+// SetFirPresets sets presets from config
+func (d *Device) SetFirPresets(presets map[string]*config.FirPreset) {
+	d.firPresets = presets
+}
+
+// GetFirPresets returns built-in presets and presets from config
+func (d *Device) GetFirPresets() map[string]*config.FirPreset {
+	presets := make(map[string]*config.FirPreset)
+	for name, preset := range FirPresets {
+		presets[name] = preset
+	}
+	for name, preset := range d.firPresets {
+		presets[name] = preset
+	}
+	return presets
+}
+
+// FirPresetNames returns sorted names of presets
+func FirPresetNames(presets map[string]*config.FirPreset) []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetFirEnabled enables/disables the FIR filter
+func (d *Device) SetFirEnabled(val bool) error {
+	d.dspParams.fir.Enabled = val
+	ops := []*layers.RegOp{
+		{Reg: &layers.Reg{Addr: RegMap[RegFirControl], Value: d.dspParams.fir.control()}},
+	}
+	return d.ctrl.RegRequest(ops, d.IP)
+}
+
+// SetFirPreset loads coefficients of the preset
+func (d *Device) SetFirPreset(name string) error {
+	preset, ok := d.GetFirPresets()[name]
+	if !ok {
+		return ErrFirPreset{Name: name}
+	}
+	if err := d.SetRoundoff(preset.Roundoff); err != nil {
+		return err
+	}
+	if err := d.SetFirCoef(preset.Coef); err != nil {
+		return err
+	}
+	d.dspParams.fir.PresetKey = name
+	return nil
+}
+
+// ApplyFirSetup loads the FIR setup from config
+func (d *Device) ApplyFirSetup(setup *config.FirSetup) error {
+	d.dspParams.fir.Enabled = setup.Enabled
+	if setup.Preset != "" {
+		return d.SetFirPreset(setup.Preset)
+	}
+	if err := d.SetRoundoff(setup.Roundoff); err != nil {
+		return err
+	}
+	coef := setup.Coef
+	if len(coef) == 0 {
+		coef = FirPresets["bypass"].Coef
+	}
+	return d.SetFirCoef(coef)
+}
+
+// ReadFir reads the FIR setup which is currently loaded to the device
+func (d *Device) ReadFir() (*layers.FirState, error) {
+	ops := []*layers.RegOp{
+		{Read: true, Reg: &layers.Reg{Addr: RegMap[RegFirControl]}},
+		{Read: true, Reg: &layers.Reg{Addr: RegMap[RegFirRoundoff]}},
+	}
+	for i := 0; i < FirCoefNum; i++ {
+		ops = append(ops, &layers.RegOp{Read: true, Reg: &layers.Reg{Addr: RegMap[RegFirCoefStart] + uint16(i)}})
+	}
+	resp, err := d.ctrl.RegRequestSync(ops, d.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &layers.FirState{
+		Preset: d.dspParams.fir.PresetKey,
+		Coef:   make([]int, FirCoefNum),
+	}
+	for _, op := range resp {
+		addr := op.Reg.Addr
+		switch {
+		case addr == RegMap[RegFirControl]:
+			state.Enabled = op.Reg.Value&1 != 0
+		case addr == RegMap[RegFirRoundoff]:
+			state.Roundoff = op.Reg.Value
+		case addr >= RegMap[RegFirCoefStart] && addr < RegMap[RegFirCoefStart]+FirCoefNum:
+			state.Coef[addr-RegMap[RegFirCoefStart]] = int(int16(op.Reg.Value))
+		}
+	}
+	return state, nil
+}
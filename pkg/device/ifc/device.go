@@ -15,14 +15,29 @@
 package ifc
 
 import (
+	"context"
 	"net"
 
+	"jinr.ru/greenlab/go-adc/pkg/config"
 	"jinr.ru/greenlab/go-adc/pkg/layers"
 )
 
 type Device interface {
+	// Do serialises operations on the device. All other methods must be called inside Do
+	// unless they only return immutable properties like GetName.
+	Do(fn func() error) error
+	// DoContext is like Do but stops waiting when the context is done
+	DoContext(ctx context.Context, fn func() error) error
+	// Busy returns an error while a long job is running on the device
+	Busy() error
+
 	MStreamStart() error
 	MStreamStop() error
+	// Synchronised start at the White Rabbit time
+	TaiSec() (uint32, error)
+	ArmStart(taiSec uint32) error
+	HasTimedStart() bool
+	ReadRunStatus() (bool, uint32, error)
 
 	SetTrigger(bitmask uint16, val bool) error
 
@@ -32,7 +47,11 @@ type Device interface {
 	SetInvert(val bool) error
 
 	SetRoundoff(val uint16) error
-	SetFirCoef(val []uint16) error
+	SetFirCoef(val []int) error
+	SetFirEnabled(val bool) error
+	SetFirPreset(name string) error
+	GetFirPresets() map[string]*config.FirPreset
+	ReadFir() (*layers.FirState, error)
 
 	SetWindowSize(val uint16) error
 	SetLatency(val uint16) error
@@ -43,11 +62,43 @@ type Device interface {
 
 	RegRead(addr uint16) (*layers.Reg, error)
 	RegReadAll() ([]*layers.Reg, error)
+	// RegTimes returns the time (milliseconds) when the cached register values were read from the device
+	RegTimes(addrs []uint16) (map[uint16]uint64, error)
 	RegWrite(reg *layers.Reg) error
 	IsRunning() (bool, error)
+	ReadSerialNum() (uint16, error)
 
 	UpdateReg(reg *layers.Reg) error
 
+	AdcSpiWrite(adc int, addr uint16, value uint8) error
+	AdcSpiRead(adc int, addr uint16) (uint8, error)
+
+	SetDac(ch int, code uint16) error
+	SetDigitalBaseline(ch int, val int) error
+	MeasureBaseline() (*layers.BaselineResult, error)
+	EqualizeBaseline(ctx context.Context, target, tolerance int) (*layers.BaselineResult, error)
+
+	SetChThreshold(ch int, thr int) error
+	ScanThreshold(ctx context.Context, setup *layers.ThresholdScanSetup, progress func(float64)) (*layers.ThresholdScanResult, error)
+
+	CheckDrift() (*layers.DriftReport, error)
+	SetDeviceSettingsFromConfig(cfg *config.Device) error
+	GetConfig() *config.Device
+
+	Snapshot() (*layers.Snapshot, error)
+	Restore(snapshot *layers.Snapshot) error
+
+	// RunState is saved to the run metadata when a run starts and stops
+	RunState() (*layers.RunDeviceState, error)
+
+	// Settings kept by go-adc which can not be read from the device
+	Settings() *layers.DeviceSettings
+	LoadSettings() error
+
+	// Long jobs must be called outside Do, they take the queue for each step
+	TrainDes(ctx context.Context) (*layers.DesTrainResult, error)
+	GetDesResult() (*layers.DesTrainResult, error)
+
 	GetName() string
 	GetIP() *net.IP
 }
@@ -0,0 +1,123 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"context"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/log"
+)
+
+const (
+	// QueueSize is how many operations may wait for the device without blocking callers
+	QueueSize = 16
+)
+
+type command struct {
+	ctx  context.Context
+	fn   func() error
+	done chan error
+}
+
+// Do runs the operation on the device command goroutine and waits for it to finish.
+// Operations are executed one at a time in the order they are queued, so multi-step
+// operations (loading FIR coefficients, applying channel setup or config) are atomic
+// with respect to each other and device settings are never changed concurrently.
+// API handlers and background jobs must access the device only inside Do.
+// The operation must not call Do itself, otherwise it deadlocks.
+func (d *Device) Do(fn func() error) error {
+	return d.DoContext(context.Background(), fn)
+}
+
+// DoContext is like Do but stops waiting when the context is done. An operation
+// which has not started yet is dropped, an operation which is already running
+// can not be interrupted and finishes in background.
+func (d *Device) DoContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	select {
+	case d.queue <- &command{ctx: ctx, fn: fn, done: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serve runs queued operations. Software settings changed by an operation are saved
+// to the state database right after it, so they survive control server restarts.
+// While a long job is running settings are saved once when it is finished.
+func (d *Device) serve() {
+	for cmd := range d.queue {
+		if err := cmd.ctx.Err(); err != nil {
+			cmd.done <- err
+			continue
+		}
+		err := cmd.fn()
+		if d.Busy() == nil {
+			if saveErr := d.saveSettings(); saveErr != nil {
+				log.Error("Error while saving settings: device: %s error: %s", d.Name, saveErr)
+			}
+		}
+		cmd.done <- err
+	}
+}
+
+// startJob marks the device busy with a long job. Long jobs (deserializer training,
+// baseline equalization, threshold scan) are called outside Do and take the queue
+// only for each single step, so other operations are not blocked for minutes.
+// The returned function must be called outside Do when the job is finished.
+func (d *Device) startJob(kind string) (func(), error) {
+	d.jobMu.Lock()
+	defer d.jobMu.Unlock()
+	if d.job != "" {
+		return nil, ErrDeviceBusy{Device: d.Name, Job: d.job}
+	}
+	d.job = kind
+	return func() {
+		d.jobMu.Lock()
+		d.job = ""
+		d.jobMu.Unlock()
+		// the empty operation saves settings changed by the job
+		d.Do(func() error { return nil })
+	}, nil
+}
+
+// Busy returns ErrDeviceBusy while a long job is running on the device.
+// Writes between the steps of the job would be overwritten when it restores the setup.
+func (d *Device) Busy() error {
+	d.jobMu.Lock()
+	defer d.jobMu.Unlock()
+	if d.job != "" {
+		return ErrDeviceBusy{Device: d.Name, Job: d.job}
+	}
+	return nil
+}
+
+// sleep waits for the duration or until the context is done
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -173,16 +173,92 @@ var RegMap = map[RegAlias]uint16{
 	RegAdcTimeSec:             0x1000,
 }
 
+// RegNames are used to identify registers in snapshots
+var RegNames = map[RegAlias]string{
+	RegDeviceCtrl:              "DeviceCtrl",
+	RegDeviceRlat:              "DeviceRlat",
+	RegRunStatus:               "RunStatus",
+	RegDeviceId:                "DeviceId",
+	RegTrigCtrl:                "TrigCtrl",
+	RegAdcInfo:                 "AdcInfo",
+	RegChDpmKs:                 "ChDpmKs",
+	RegTemperature:             "Temperature",
+	RegFwVer:                   "FwVer",
+	RegFwRev:                   "FwRev",
+	RegSerialNum:               "SerialNum",
+	RegDacMax5501:              "DacMax5501",
+	RegDacMax5502:              "DacMax5502",
+	RegPca12:                   "Pca12",
+	RegAdcSpi:                  "AdcSpi",
+	RegAdc12SpiRead:            "Adc12SpiRead",
+	RegAdc34SpiRead:            "Adc34SpiRead",
+	RegAdc56SpiRead:            "Adc56SpiRead",
+	RegAdc78SpiRead:            "Adc78SpiRead",
+	RegZsEvents:                "ZsEvents",
+	RegMstreamRunCtrl:          "MstreamRunCtrl",
+	RegMstreamDataSizeBytes:    "MstreamDataSizeBytes",
+	RegMstreamReadoutChannelEn: "MstreamReadoutChannelEn",
+	RegMstreamSparseCtrl:       "MstreamSparseCtrl",
+	RegMstreamSparseOffset:     "MstreamSparseOffset",
+	RegMstreamSparsePeriod:     "MstreamSparsePeriod",
+	RegMstreamMtuSize:          "MstreamMtuSize",
+	RegDesCtrl:                 "DesCtrl",
+	RegDesStatus:               "DesStatus",
+	RegDesIdelayTapVal:         "DesIdelayTapVal",
+	RegDesIdelayLoadMask:       "DesIdelayLoadMask",
+	RegFirControl:              "FirControl",
+	RegFirCoefCtrl:             "FirCoefCtrl",
+	RegFirRoundoff:             "FirRoundoff",
+	RegFirCoefStart:            "FirCoefStart",
+	RegTrigCsrTrigTs:           "TrigCsrTrigTs",
+	RegTrigCsrEvNum:            "TrigCsrEvNum",
+	RegTrigCsrTrigInDelay:      "TrigCsrTrigInDelay",
+	RegTrigCsrTrigCode:         "TrigCsrTrigCode",
+	RegStatisticControl:        "StatisticControl",
+	RegAdcStatus:               "AdcStatus",
+	RegRunEventNumber:          "RunEventNumber",
+	RegWrSyncLostCounter:       "WrSyncLostCounter",
+	RegWrLinkErrorCounter:      "WrLinkErrorCounter",
+	RegAdcStatusMask:           "AdcStatusMask",
+	RegTrigOnXoffErrorCounter:  "TrigOnXoffErrorCounter",
+	RegRunEventNumber64:        "RunEventNumber64",
+	RegAdcTimeSec:              "AdcTimeSec",
+}
+
 const (
 	RegRunStatusBitRunning uint16 = 0x0010
 )
 
+// Device control. When RegDeviceCtrlBitTimedStart is set together with RegDeviceCtrlBitRun
+// the device does not start immediately but waits until its White Rabbit time (RegAdcTimeSec)
+// reaches the TAI second written to RegTrigCsrTrigTs (low word first).
+// The timed start is not described in the register map (mregdevice.h), so it is used only
+// for devices with TimedStart enabled in config, see HasTimedStart.
+const (
+	RegDeviceCtrlBitReset      uint16 = 0x0001
+	RegDeviceCtrlBitTimedStart uint16 = 0x4000
+	RegDeviceCtrlBitRun        uint16 = 0x8000
+)
+
 const (
 	RegTrigStatusBitTimer     uint16 = 0x001
 	RegTrigStatusBitThreshold uint16 = 0x002
 	RegTrigStatusBitLemo      uint16 = 0x004
 )
 
+// Deserializer control. The IDELAY tap value written to RegDesIdelayTapVal
+// is loaded to the channels which bits are set in RegDesIdelayLoadMask.
+// Since there are 64 channels and the mask is 16 bits wide, channels are
+// split into 4 banks and the bank is selected by RegDesCtrl bits 5:4.
+const (
+	RegDesCtrlBitPatternCheck uint16 = 0x0001
+	RegDesCtrlBitCntReset     uint16 = 0x0002
+	RegDesCtrlBankShift              = 4
+	RegDesCtrlBankMask        uint16 = 0x0030
+	DesBankSize                      = 16
+	DesTapNum                        = 32
+)
+
 type MemAlias int
 
 const (
@@ -220,6 +296,24 @@ var MemMap = map[MemAlias]uint32{
 	MemChD2HistTime:            0x00C2,
 }
 
+// MemNames are used to identify channel memory registers in snapshots
+var MemNames = map[MemAlias]string{
+	MemChWrAddr:                "ChWrAddr",
+	MemChCtrl:                  "ChCtrl",
+	MemChThr:                   "ChThr",
+	MemChZsThr:                 "ChZsThr",
+	MemChBaseline:              "ChBaseline",
+	MemChAdcData:               "ChAdcData",
+	MemChAdcPattern:            "ChAdcPattern",
+	MemChAdcPatternMismatchCnt: "ChAdcPatternMismatchCnt",
+	MemChBlcThrHi:              "ChBlcThrHi",
+	MemChBlcThrLo:              "ChBlcThrLo",
+	MemChD2Hist:                "ChD2Hist",
+	MemChD2HistCtrl:            "ChD2HistCtrl",
+	MemChD2HistSt:              "ChD2HistSt",
+	MemChD2HistTime:            "ChD2HistTime",
+}
+
 const (
 	MemBitSelectCtrl = 1 << 13 // bit13==1 (bus 15:0) - register operation
 )
@@ -0,0 +1,100 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+// reg32 combines two consecutive 16 bit registers, the low word goes first
+func reg32(regs map[uint16]uint16, addr uint16) uint32 {
+	return uint32(regs[addr]) | uint32(regs[addr+1])<<16
+}
+
+// RunState reads the firmware version, time, counters and the snapshot of the device.
+// It is saved to the run metadata when the run starts and stops.
+func (d *Device) RunState() (*layers.RunDeviceState, error) {
+	timestamp := srv.Now()
+	var addrs []uint16
+	for _, alias := range []RegAlias{RegAdcTimeSec, RegRunEventNumber, RegWrSyncLostCounter, RegWrLinkErrorCounter, RegTrigOnXoffErrorCounter} {
+		addrs = append(addrs, RegMap[alias], RegMap[alias]+1)
+	}
+	addrs = append(addrs, RegMap[RegFwVer], RegMap[RegFwRev])
+	regs, err := d.readRegs(addrs...)
+	if err != nil {
+		return nil, err
+	}
+	d.fwVersion = &FwVersion{
+		Major:    (regs[RegMap[RegFwVer]] >> 8) & 0xFF,
+		Minor:    regs[RegMap[RegFwVer]] & 0xFF,
+		Revision: regs[RegMap[RegFwRev]],
+	}
+
+	snapshot, err := d.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &layers.RunDeviceState{
+		Timestamp:    timestamp,
+		TaiSec:       reg32(regs, RegMap[RegAdcTimeSec]),
+		Firmware:     d.fwVersion.String(),
+		EventCounter: reg32(regs, RegMap[RegRunEventNumber]),
+		WrSyncLost:   reg32(regs, RegMap[RegWrSyncLostCounter]),
+		WrLinkErrors: reg32(regs, RegMap[RegWrLinkErrorCounter]),
+		TrigOnXoff:   reg32(regs, RegMap[RegTrigOnXoffErrorCounter]),
+		Snapshot:     snapshot,
+	}, nil
+}
+
+// TaiSec reads the White Rabbit time of the device in seconds
+func (d *Device) TaiSec() (uint32, error) {
+	addr := RegMap[RegAdcTimeSec]
+	regs, err := d.readRegs(addr, addr+1)
+	if err != nil {
+		return 0, err
+	}
+	return reg32(regs, addr), nil
+}
+
+// ArmStart prepares streaming and makes the device start when its White Rabbit time reaches taiSec.
+// The request is synchronous, so the device is known to be armed when it returns.
+func (d *Device) ArmStart(taiSec uint32) error {
+	if !d.HasTimedStart() {
+		return ErrTimedStart{Device: d.Name}
+	}
+	addr := RegMap[RegTrigCsrTrigTs]
+	ops := []*layers.RegOp{
+		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: 0}},
+		{Reg: &layers.Reg{Addr: addr, Value: uint16(taiSec)}},
+		{Reg: &layers.Reg{Addr: addr + 1, Value: uint16(taiSec >> 16)}},
+		{Reg: &layers.Reg{Addr: RegMap[RegMstreamRunCtrl], Value: d.ercBit()}},
+		{Reg: &layers.Reg{Addr: RegMap[RegDeviceCtrl], Value: RegDeviceCtrlBitRun | RegDeviceCtrlBitTimedStart}},
+	}
+	_, err := d.ctrl.RegRequestSync(ops, d.IP)
+	return err
+}
+
+// ReadRunStatus reads the running flag and the White Rabbit time from the device.
+// Unlike IsRunning it does not use register values cached in the state database.
+func (d *Device) ReadRunStatus() (bool, uint32, error) {
+	addr := RegMap[RegAdcTimeSec]
+	regs, err := d.readRegs(RegMap[RegRunStatus], addr, addr+1)
+	if err != nil {
+		return false, 0, err
+	}
+	return regs[RegMap[RegRunStatus]]&RegRunStatusBitRunning != 0, reg32(regs, addr), nil
+}
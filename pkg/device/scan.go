@@ -0,0 +1,244 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+)
+
+const (
+	ThresholdScanStepDefault   = 4
+	ThresholdScanDwellDefault  = 100 // ms
+	ThresholdScanNSigmaDefault = 5.0
+)
+
+// EventCounter reads the number of triggers registered since the run start
+func (d *Device) EventCounter() (uint32, error) {
+	addr := RegMap[RegRunEventNumber]
+	ops := []*layers.RegOp{
+		{Read: true, Reg: &layers.Reg{Addr: addr}},
+		{Read: true, Reg: &layers.Reg{Addr: addr + 1}},
+	}
+	resp, err := d.ctrl.RegRequestSync(ops, d.IP)
+	if err != nil {
+		return 0, err
+	}
+	var counter uint32
+	for _, op := range resp {
+		switch op.Reg.Addr {
+		case addr:
+			counter |= uint32(op.Reg.Value)
+		case addr + 1:
+			counter |= uint32(op.Reg.Value) << 16
+		}
+	}
+	return counter, nil
+}
+
+// SetChThreshold sets the trigger threshold of the channel
+func (d *Device) SetChThreshold(ch int, thr int) error {
+	if err := checkCh(ch); err != nil {
+		return err
+	}
+	d.ChSettings[ch].TriggerThreshold = thr
+	return d.WriteChReg(ch, MemMap[MemChThr], uint32(d.TruncateValue(thr)))
+}
+
+func (d *Device) setChTrigger(ch int, enabled bool) error {
+	d.ChSettings[ch].TriggerEnabled = enabled
+	return d.WriteChReg(ch, MemMap[MemChCtrl], uint32(d.encodeChCtrlRegValue(ch)))
+}
+
+func setThresholdScanDefaults(setup *layers.ThresholdScanSetup) error {
+	if setup.Step <= 0 {
+		setup.Step = ThresholdScanStepDefault
+	}
+	if setup.DwellMs <= 0 {
+		setup.DwellMs = ThresholdScanDwellDefault
+	}
+	if setup.NSigma <= 0 {
+		setup.NSigma = ThresholdScanNSigmaDefault
+	}
+	if setup.From >= setup.To {
+		return ErrScanRange{From: setup.From, To: setup.To}
+	}
+	if len(setup.Channels) == 0 {
+		setup.Channels = allChannels()
+	}
+	for _, ch := range setup.Channels {
+		if err := checkCh(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// analyzeSCurve derives noise parameters from the S-curve. The rate falls down when
+// the threshold goes above the noise, so the negative derivative of the S-curve
+// is the noise distribution.
+func analyzeSCurve(result *layers.ThresholdScanChannel, nSigma float64) {
+	var sum, sumThr, sumThr2 float64
+	points := result.Points
+	for i := 0; i+1 < len(points); i++ {
+		w := points[i].Rate - points[i+1].Rate
+		if w <= 0 {
+			continue
+		}
+		thr := float64(points[i].Thr+points[i+1].Thr) / 2
+		sum += w
+		sumThr += w * thr
+		sumThr2 += w * thr * thr
+	}
+	if sum == 0 {
+		return
+	}
+	result.Mean = sumThr / sum
+	result.Sigma = math.Sqrt(math.Max(sumThr2/sum-result.Mean*result.Mean, 0))
+	result.Proposed = int(math.Ceil(result.Mean + nSigma*result.Sigma))
+	result.Ok = true
+}
+
+// ScanThreshold sweeps the trigger threshold of every channel while only this channel can trigger
+// and measures the trigger rate at each threshold. Thresholds and channel triggers are restored
+// when the scan is finished unless proposed thresholds are to be applied.
+// The progress function is called with the fraction of the scan done.
+// The device must be running, otherwise the event counter does not count triggers.
+// It must be called outside Do, every step takes the device queue by itself.
+func (d *Device) ScanThreshold(ctx context.Context, setup *layers.ThresholdScanSetup, progress func(float64)) (*layers.ThresholdScanResult, error) {
+	if err := setThresholdScanDefaults(setup); err != nil {
+		return nil, err
+	}
+	finish, err := d.startJob("threshold scan")
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+	log.Info("Scanning trigger thresholds: device: %s from: %d to: %d step: %d",
+		d.Name, setup.From, setup.To, setup.Step)
+
+	var trigCtrl *layers.Reg
+	var thresholds [Nch]int
+	var triggers [Nch]bool
+	err = d.DoContext(ctx, func() (err error) {
+		running, _, err := d.ReadRunStatus()
+		if err != nil {
+			return err
+		}
+		if !running {
+			return ErrNotRunning{Device: d.Name}
+		}
+		trigCtrl, err = d.RegRead(RegMap[RegTrigCtrl])
+		if err != nil {
+			return err
+		}
+		for ch := 0; ch < Nch; ch++ {
+			thresholds[ch] = d.ChSettings[ch].TriggerThreshold
+			triggers[ch] = d.ChSettings[ch].TriggerEnabled
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &layers.ThresholdScanResult{Device: d.Name, Setup: setup}
+	defer func() {
+		for _, ch := range result.Channels {
+			if setup.Apply && ch.Ok {
+				thresholds[ch.Ch] = ch.Proposed
+			}
+		}
+		// the setup is restored even if the scan is cancelled
+		err := d.Do(func() error {
+			for ch := 0; ch < Nch; ch++ {
+				d.ChSettings[ch].TriggerEnabled = triggers[ch]
+				d.SetChThreshold(ch, thresholds[ch])
+				d.WriteChReg(ch, MemMap[MemChCtrl], uint32(d.encodeChCtrlRegValue(ch)))
+			}
+			return d.RegWrite(&layers.Reg{Addr: RegMap[RegTrigCtrl], Value: trigCtrl.Value})
+		})
+		if err != nil {
+			log.Error("Error while restoring trigger setup: device: %s error: %s", d.Name, err)
+		}
+	}()
+
+	for ch := 0; ch < Nch; ch++ {
+		ch := ch
+		if err := d.DoContext(ctx, func() error { return d.setChTrigger(ch, false) }); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.DoContext(ctx, func() error { return d.SetTrigger(RegTrigStatusBitThreshold, true) }); err != nil {
+		return nil, err
+	}
+
+	dwell := time.Duration(setup.DwellMs) * time.Millisecond
+	steps := (setup.To-setup.From)/setup.Step + 1
+	total := float64(steps * len(setup.Channels))
+	done := 0
+	for _, ch := range setup.Channels {
+		ch := ch
+		chResult := &layers.ThresholdScanChannel{Ch: ch}
+		result.Channels = append(result.Channels, chResult)
+		if err := d.DoContext(ctx, func() error { return d.setChTrigger(ch, true) }); err != nil {
+			return nil, err
+		}
+		for thr := setup.From; thr <= setup.To; thr += setup.Step {
+			thr := thr
+			var start, stop uint32
+			err := d.DoContext(ctx, func() (err error) {
+				if err := d.SetChThreshold(ch, thr); err != nil {
+					return err
+				}
+				start, err = d.EventCounter()
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := sleep(ctx, dwell); err != nil {
+				return nil, err
+			}
+			err = d.DoContext(ctx, func() (err error) {
+				stop, err = d.EventCounter()
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			chResult.Points = append(chResult.Points, &layers.ThresholdScanPoint{
+				Thr:  thr,
+				Rate: float64(stop-start) / dwell.Seconds(),
+			})
+			done++
+			if progress != nil {
+				progress(float64(done) / total)
+			}
+		}
+		if err := d.DoContext(ctx, func() error { return d.setChTrigger(ch, false) }); err != nil {
+			return nil, err
+		}
+		analyzeSCurve(chResult, setup.NSigma)
+		if !chResult.Ok {
+			log.Warning("No noise found in threshold scan: device: %s channel: %d", d.Name, ch)
+		}
+	}
+	return result, nil
+}
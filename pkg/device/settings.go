@@ -0,0 +1,175 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"reflect"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	// SettingsBucket is the state bucket where software settings are stored by device name
+	SettingsBucket = "settings"
+)
+
+// Settings returns software settings of the device
+func (d *Device) Settings() *layers.DeviceSettings {
+	fir := d.dspParams.fir
+	settings := &layers.DeviceSettings{
+		Device:      d.Name,
+		Timestamp:   d.settingsTimestamp,
+		DspEnabled:  d.dspParams.Enabled,
+		BlcThr:      d.dspParams.BlcThr,
+		MafEnabled:  d.dspParams.MafEnabled,
+		MafTapSel:   d.dspParams.MafTapSel,
+		TestEnabled: d.dspParams.TestEnabled,
+		Fir: &layers.FirState{
+			Enabled:  fir.Enabled,
+			Preset:   fir.PresetKey,
+			Roundoff: fir.Roundoff,
+			Coef:     make([]int, len(fir.Coef)),
+		},
+		InvertInput:                    d.InvertInput,
+		ZeroSuppressionEnabled:         d.ZeroSuppressionEnabled,
+		InvertThresholdTrigger:         d.InvertThresholdTrigger,
+		InvertZeroSupperssionThreshold: d.InvertZeroSupperssionThreshold,
+		TriggerDelay:                   d.TriggerDelay,
+	}
+	for i, c := range fir.Coef {
+		settings.Fir.Coef[i] = int(int16(c))
+	}
+	if d.baselineSetup != nil {
+		setup := copyBaselineSetup(d.baselineSetup)
+		settings.Baseline = &layers.BaselineSettings{
+			Target:    setup.Target,
+			Tolerance: setup.Tolerance,
+			Dac:       setup.Dac,
+			Digital:   setup.Digital,
+		}
+	}
+	for ch, chSettings := range d.ChSettings {
+		settings.Channels = append(settings.Channels, layers.Channel{
+			Id:       ch,
+			En:       chSettings.Enabled,
+			TrigEn:   chSettings.TriggerEnabled,
+			Baseline: chSettings.BaseLine,
+			TrigThr:  chSettings.TriggerThreshold,
+			ZsThr:    chSettings.ZeroThreshold,
+		})
+	}
+	return settings
+}
+
+// saveSettings stores software settings in the state database if they have changed since they were saved.
+// Settings are not saved until they are loaded, otherwise defaults would overwrite stored settings.
+func (d *Device) saveSettings() error {
+	if d.savedSettings == nil {
+		return nil
+	}
+	settings := d.Settings()
+	if reflect.DeepEqual(settings, d.savedSettings) {
+		return nil
+	}
+	settings.Timestamp = srv.Now()
+	if err := d.state.Put(SettingsBucket, d.Name, settings); err != nil {
+		return err
+	}
+	d.settingsTimestamp = settings.Timestamp
+	d.savedSettings = settings
+	return nil
+}
+
+// LoadSettings reads software settings from the state database and applies them to the device.
+// Nothing is done if there are no stored settings. If the stored settings can not be read,
+// ErrSettingsRead is returned and settings are not saved, so the stored ones are not overwritten.
+// If they are read but can not be written to the device, ErrSettingsApply is returned
+// and settings are saved from now on as usual.
+func (d *Device) LoadSettings() error {
+	settings := &layers.DeviceSettings{}
+	if err := d.state.Get(SettingsBucket, d.Name, settings); err != nil {
+		if _, ok := err.(srv.ErrNotFound); ok {
+			log.Info("No stored settings: device: %s", d.Name)
+			d.savedSettings = d.Settings()
+			return nil
+		}
+		return ErrSettingsRead{Device: d.Name, Err: err}
+	}
+	log.Info("Loading stored settings: device: %s", d.Name)
+	err := d.applySettings(settings)
+	// settings are saved from now on even if they are not fully written to the device
+	d.savedSettings = d.Settings()
+	if err != nil {
+		return ErrSettingsApply{Device: d.Name, Err: err}
+	}
+	return nil
+}
+
+// applySettings sets software settings and writes them to the device
+func (d *Device) applySettings(settings *layers.DeviceSettings) error {
+	d.dspParams.Enabled = settings.DspEnabled
+	d.dspParams.BlcThr = settings.BlcThr
+	d.dspParams.MafEnabled = settings.MafEnabled
+	d.dspParams.MafTapSel = settings.MafTapSel
+	d.dspParams.TestEnabled = settings.TestEnabled
+	d.InvertInput = settings.InvertInput
+	d.ZeroSuppressionEnabled = settings.ZeroSuppressionEnabled
+	d.InvertThresholdTrigger = settings.InvertThresholdTrigger
+	d.InvertZeroSupperssionThreshold = settings.InvertZeroSupperssionThreshold
+	d.TriggerDelay = settings.TriggerDelay
+	d.settingsTimestamp = settings.Timestamp
+
+	if fir := settings.Fir; fir != nil {
+		d.dspParams.fir.Enabled = fir.Enabled
+		d.dspParams.fir.setRoundoff(fir.Roundoff)
+		if err := d.SetFirCoef(fir.Coef); err != nil {
+			return err
+		}
+		d.dspParams.fir.PresetKey = fir.Preset
+	}
+
+	// channel control words depend on DSP settings, so they are written for all channels
+	setup := layers.ChannelsSetup{}
+	for _, ch := range settings.Channels {
+		if checkCh(ch.Id) == nil {
+			setup.Channels = append(setup.Channels, ch)
+		}
+	}
+	if err := d.SetChannels(setup); err != nil {
+		return err
+	}
+	for ch := 0; ch < Nch; ch++ {
+		if err := d.WriteChCtrl(ch); err != nil {
+			return err
+		}
+	}
+
+	// calibrated baselines take precedence over the baseline setup in config
+	if baseline := settings.Baseline; baseline != nil {
+		d.baselineSetup = copyBaselineSetup(&config.BaselineSetup{
+			Target:    baseline.Target,
+			Tolerance: baseline.Tolerance,
+			Dac:       baseline.Dac,
+			Digital:   baseline.Digital,
+		})
+		if err := d.ApplyBaselineSetup(d.GetBaselineSetup()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
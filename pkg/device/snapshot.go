@@ -0,0 +1,289 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+)
+
+const (
+	SnapshotVersion = 1
+)
+
+// SnapshotRestoreRegs are configuration registers which are written when a snapshot is restored.
+// Other registers are either status registers or written by dedicated procedures (FIR, DAC, SPI).
+// Only these registers are compared when snapshots are diffed.
+var SnapshotRestoreRegs = []RegAlias{
+	RegDeviceRlat,
+	RegTrigCtrl,
+	RegMstreamDataSizeBytes,
+	RegMstreamReadoutChannelEn,
+	RegMstreamSparseCtrl,
+	RegMstreamSparseOffset,
+	RegMstreamSparsePeriod,
+	RegMstreamMtuSize,
+	RegTrigCsrTrigInDelay,
+	RegAdcStatusMask,
+}
+
+// SnapshotChMem are channel memory registers saved in snapshots
+var SnapshotChMem = []MemAlias{
+	MemChCtrl,
+	MemChThr,
+	MemChZsThr,
+	MemChBaseline,
+	MemChBlcThrHi,
+	MemChBlcThrLo,
+}
+
+// Snapshot reads the configurable state of the device
+func (d *Device) Snapshot() (*layers.Snapshot, error) {
+	log.Info("Taking snapshot: device: %s", d.Name)
+	var addrs []uint16
+	for alias := RegAlias(0); alias < RegAliasLimit; alias++ {
+		addrs = append(addrs, RegMap[alias])
+	}
+	values, err := d.readRegs(addrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &layers.Snapshot{
+		Version:   SnapshotVersion,
+		Device:    d.Name,
+		Timestamp: uint64(time.Now().UnixNano()) / uint64(time.Millisecond),
+		Regs:      make(map[string]uint16),
+	}
+	for alias := RegAlias(0); alias < RegAliasLimit; alias++ {
+		if value, ok := values[RegMap[alias]]; ok {
+			snapshot.Regs[RegNames[alias]] = value
+		}
+	}
+
+	for ch := 0; ch < Nch; ch++ {
+		chSnapshot := &layers.SnapshotChannel{Ch: ch, Mem: make(map[string]uint16)}
+		for _, alias := range SnapshotChMem {
+			value, err := d.ReadChReg(ch, MemMap[alias])
+			if err != nil {
+				return nil, err
+			}
+			chSnapshot.Mem[MemNames[alias]] = uint16(value)
+		}
+		snapshot.Channels = append(snapshot.Channels, chSnapshot)
+	}
+
+	snapshot.Fir, err = d.ReadFir()
+	if err != nil {
+		return nil, err
+	}
+
+	software := &layers.SnapshotSoftware{
+		DspEnabled:                     d.dspParams.Enabled,
+		BlcThr:                         d.dspParams.BlcThr,
+		MafEnabled:                     d.dspParams.MafEnabled,
+		MafTapSel:                      d.dspParams.MafTapSel,
+		TestEnabled:                    d.dspParams.TestEnabled,
+		InvertInput:                    d.InvertInput,
+		ZeroSuppressionEnabled:         d.ZeroSuppressionEnabled,
+		InvertThresholdTrigger:         d.InvertThresholdTrigger,
+		InvertZeroSupperssionThreshold: d.InvertZeroSupperssionThreshold,
+		TriggerDelay:                   d.TriggerDelay,
+		Dac:                            append([]uint16{}, d.GetBaselineSetup().Dac...),
+	}
+	for ch, settings := range d.ChSettings {
+		software.Channels = append(software.Channels, layers.Channel{
+			Id:       ch,
+			En:       settings.Enabled,
+			TrigEn:   settings.TriggerEnabled,
+			Baseline: settings.BaseLine,
+			TrigThr:  settings.TriggerThreshold,
+			ZsThr:    settings.ZeroThreshold,
+		})
+	}
+	snapshot.Software = software
+	return snapshot, nil
+}
+
+// Restore writes the snapshot to the device. The snapshot can be taken from another device.
+func (d *Device) Restore(snapshot *layers.Snapshot) error {
+	if snapshot.Version != SnapshotVersion {
+		return ErrSnapshotVersion{Version: snapshot.Version}
+	}
+	log.Info("Restoring snapshot: device: %s snapshot device: %s", d.Name, snapshot.Device)
+
+	if software := snapshot.Software; software != nil {
+		d.dspParams.Enabled = software.DspEnabled
+		d.dspParams.BlcThr = software.BlcThr
+		d.dspParams.MafEnabled = software.MafEnabled
+		d.dspParams.MafTapSel = software.MafTapSel
+		d.dspParams.TestEnabled = software.TestEnabled
+		d.InvertInput = software.InvertInput
+		d.ZeroSuppressionEnabled = software.ZeroSuppressionEnabled
+		d.InvertThresholdTrigger = software.InvertThresholdTrigger
+		d.InvertZeroSupperssionThreshold = software.InvertZeroSupperssionThreshold
+		d.TriggerDelay = software.TriggerDelay
+		for _, ch := range software.Channels {
+			if checkCh(ch.Id) != nil {
+				continue
+			}
+			d.ChSettings[ch.Id].Enabled = ch.En
+			d.ChSettings[ch.Id].TriggerEnabled = ch.TrigEn
+			d.ChSettings[ch.Id].BaseLine = ch.Baseline
+			d.ChSettings[ch.Id].TriggerThreshold = ch.TrigThr
+			d.ChSettings[ch.Id].ZeroThreshold = ch.ZsThr
+		}
+		for ch, code := range software.Dac {
+			if ch >= Nch {
+				break
+			}
+			if err := d.SetDac(ch, code); err != nil {
+				return err
+			}
+		}
+	}
+
+	var ops []*layers.RegOp
+	for _, alias := range SnapshotRestoreRegs {
+		if value, ok := snapshot.Regs[RegNames[alias]]; ok {
+			ops = append(ops, &layers.RegOp{Reg: &layers.Reg{Addr: RegMap[alias], Value: value}})
+		}
+	}
+	if len(ops) > 0 {
+		if err := d.ctrl.RegRequest(ops, d.IP); err != nil {
+			return err
+		}
+	}
+
+	if fir := snapshot.Fir; fir != nil {
+		d.dspParams.fir.Enabled = fir.Enabled
+		d.dspParams.fir.setRoundoff(fir.Roundoff)
+		if err := d.SetFirCoef(fir.Coef); err != nil {
+			return err
+		}
+		d.dspParams.fir.PresetKey = fir.Preset
+	}
+
+	memAliases := make(map[string]MemAlias)
+	for _, alias := range SnapshotChMem {
+		memAliases[MemNames[alias]] = alias
+	}
+	for _, ch := range snapshot.Channels {
+		if err := checkCh(ch.Ch); err != nil {
+			return err
+		}
+		for name, value := range ch.Mem {
+			alias, ok := memAliases[name]
+			if !ok {
+				continue
+			}
+			if err := d.WriteChReg(ch.Ch, MemMap[alias], uint32(value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flatten converts a JSON document to the map of values by path
+func flatten(prefix string, value interface{}, result map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			flatten(fmt.Sprintf("%s.%s", prefix, key), item, result)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), item, result)
+		}
+	default:
+		result[prefix] = fmt.Sprint(v)
+	}
+}
+
+// flattenSnapshot returns comparable settings of the snapshot
+func flattenSnapshot(snapshot *layers.Snapshot) (map[string]string, error) {
+	regs := make(map[string]uint16)
+	for _, alias := range SnapshotRestoreRegs {
+		if value, ok := snapshot.Regs[RegNames[alias]]; ok {
+			regs[RegNames[alias]] = value
+		}
+	}
+	comparable := struct {
+		Regs     map[string]uint16         `json:"regs"`
+		Channels []*layers.SnapshotChannel `json:"channels"`
+		Fir      *layers.FirState          `json:"fir"`
+		Software *layers.SnapshotSoftware  `json:"software"`
+	}{regs, snapshot.Channels, snapshot.Fir, snapshot.Software}
+
+	data, err := json.Marshal(comparable)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for key, value := range doc {
+		flatten(key, value, result)
+	}
+	return result, nil
+}
+
+// DiffSnapshots returns settings which differ in two snapshots sorted by name.
+// Status registers are not compared.
+func DiffSnapshots(left, right *layers.Snapshot) ([]*layers.SnapshotDiff, error) {
+	leftValues, err := flattenSnapshot(left)
+	if err != nil {
+		return nil, err
+	}
+	rightValues, err := flattenSnapshot(right)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]bool)
+	for setting := range leftValues {
+		settings[setting] = true
+	}
+	for setting := range rightValues {
+		settings[setting] = true
+	}
+	var diffs []*layers.SnapshotDiff
+	for setting := range settings {
+		l, lok := leftValues[setting]
+		r, rok := rightValues[setting]
+		if lok && rok && l == r {
+			continue
+		}
+		if !lok {
+			l = "-"
+		}
+		if !rok {
+			r = "-"
+		}
+		diffs = append(diffs, &layers.SnapshotDiff{Setting: setting, Left: l, Right: r})
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Setting < diffs[j].Setting
+	})
+	return diffs, nil
+}
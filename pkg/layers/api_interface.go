@@ -26,3 +26,541 @@ type Channel struct {
 type ChannelsSetup struct {
 	Channels []Channel
 }
+
+// DesChannel is the result of the deserializer link training for a single channel
+type DesChannel struct {
+	Ch int `json:"ch"`
+	// Tap is the IDELAY tap value loaded to the channel
+	Tap uint16 `json:"tap"`
+	// EyeStart and EyeWidth describe the widest range of taps without pattern mismatches
+	EyeStart int  `json:"eyeStart"`
+	EyeWidth int  `json:"eyeWidth"`
+	Ok       bool `json:"ok"`
+}
+
+// DesTrainResult is the result of the deserializer link training for a device
+type DesTrainResult struct {
+	Device    string        `json:"device"`
+	Timestamp uint64        `json:"timestamp"`
+	Channels  []*DesChannel `json:"channels"`
+}
+
+// Failed returns the list of channels which have not been aligned
+func (r *DesTrainResult) Failed() []int {
+	var failed []int
+	for _, ch := range r.Channels {
+		if !ch.Ok {
+			failed = append(failed, ch.Ch)
+		}
+	}
+	return failed
+}
+
+// BaselineChannel is the baseline state of a single channel
+type BaselineChannel struct {
+	Ch int `json:"ch"`
+	// Dac is the analog offset DAC code
+	Dac uint16 `json:"dac"`
+	// Digital is the baseline subtracted by firmware
+	Digital int `json:"digital"`
+	// Pedestal and Rms are measured from raw ADC samples
+	Pedestal float64 `json:"pedestal"`
+	Rms      float64 `json:"rms"`
+	Ok       bool    `json:"ok"`
+}
+
+// BaselineResult is the baseline state of a device
+type BaselineResult struct {
+	Device    string             `json:"device"`
+	Target    int                `json:"target"`
+	Tolerance int                `json:"tolerance"`
+	Channels  []*BaselineChannel `json:"channels"`
+}
+
+// ThresholdScanSetup defines the range of trigger thresholds to sweep
+type ThresholdScanSetup struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	Step int `json:"step"`
+	// DwellMs is how long triggers are counted at each threshold
+	DwellMs int `json:"dwellMs"`
+	// NSigma defines how far above the noise the proposed threshold is
+	NSigma float64 `json:"nSigma"`
+	// Channels to scan. All channels if empty
+	Channels []int `json:"channels,omitempty"`
+	// Apply proposed thresholds when the scan is finished
+	Apply bool `json:"apply"`
+}
+
+type ThresholdScanPoint struct {
+	Thr  int     `json:"thr"`
+	Rate float64 `json:"rate"` // Hz
+}
+
+// ThresholdScanChannel is the S-curve of a channel and the noise parameters derived from it
+type ThresholdScanChannel struct {
+	Ch       int                   `json:"ch"`
+	Points   []*ThresholdScanPoint `json:"points"`
+	Mean     float64               `json:"mean"`
+	Sigma    float64               `json:"sigma"`
+	Proposed int                   `json:"proposed"`
+	Ok       bool                  `json:"ok"`
+}
+
+type ThresholdScanResult struct {
+	Device   string                  `json:"device"`
+	Setup    *ThresholdScanSetup     `json:"setup"`
+	Channels []*ThresholdScanChannel `json:"channels"`
+}
+
+// FirState is the FIR filter setup loaded to a device
+type FirState struct {
+	Enabled  bool   `json:"enabled"`
+	Preset   string `json:"preset,omitempty"`
+	Roundoff uint16 `json:"roundoff"`
+	Coef     []int  `json:"coef"`
+}
+
+// Drift is a setting which differs on a device from config
+type Drift struct {
+	Setting string `json:"setting"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+}
+
+// DriftReport is the result of comparing a device state with config
+type DriftReport struct {
+	Device    string   `json:"device"`
+	Timestamp uint64   `json:"timestamp"`
+	Drifts    []*Drift `json:"drifts"`
+	// Applied is true if config has been re-applied because of drift
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SnapshotChannel contains channel memory registers by name
+type SnapshotChannel struct {
+	Ch  int               `json:"ch"`
+	Mem map[string]uint16 `json:"mem"`
+}
+
+// SnapshotSoftware contains settings which are kept by go-adc and can not be read from a device
+type SnapshotSoftware struct {
+	DspEnabled                     bool      `json:"dspEnabled"`
+	BlcThr                         int       `json:"blcThr"`
+	MafEnabled                     bool      `json:"mafEnabled"`
+	MafTapSel                      int       `json:"mafTapSel"`
+	TestEnabled                    bool      `json:"testEnabled"`
+	InvertInput                    bool      `json:"invertInput"`
+	ZeroSuppressionEnabled         bool      `json:"zeroSuppressionEnabled"`
+	InvertThresholdTrigger         bool      `json:"invertThresholdTrigger"`
+	InvertZeroSupperssionThreshold bool      `json:"invertZeroSuppressionThreshold"`
+	TriggerDelay                   int       `json:"triggerDelay"`
+	Channels                       []Channel `json:"channels"`
+	Dac                            []uint16  `json:"dac"`
+}
+
+// Snapshot is the configurable state of a device
+type Snapshot struct {
+	Version   int                `json:"version"`
+	Device    string             `json:"device"`
+	Timestamp uint64             `json:"timestamp"`
+	Regs      map[string]uint16  `json:"regs"`
+	Channels  []*SnapshotChannel `json:"channels"`
+	Fir       *FirState          `json:"fir"`
+	Software  *SnapshotSoftware  `json:"software"`
+}
+
+// DeviceSettings are settings kept by go-adc in memory and in the state database.
+// They can not be read back from a device and are applied to it when the control server starts.
+type DeviceSettings struct {
+	Device string `json:"device"`
+	// Timestamp is when the settings were saved last time
+	Timestamp                      uint64    `json:"timestamp"`
+	DspEnabled                     bool      `json:"dspEnabled"`
+	BlcThr                         int       `json:"blcThr"`
+	MafEnabled                     bool      `json:"mafEnabled"`
+	MafTapSel                      int       `json:"mafTapSel"`
+	TestEnabled                    bool      `json:"testEnabled"`
+	Fir                            *FirState `json:"fir"`
+	InvertInput                    bool      `json:"invertInput"`
+	ZeroSuppressionEnabled         bool      `json:"zeroSuppressionEnabled"`
+	InvertThresholdTrigger         bool      `json:"invertThresholdTrigger"`
+	InvertZeroSupperssionThreshold bool      `json:"invertZeroSuppressionThreshold"`
+	TriggerDelay                   int       `json:"triggerDelay"`
+	Channels                       []Channel `json:"channels"`
+	// Baseline is the baseline setup in use, e.g. after the baseline equalization
+	Baseline *BaselineSettings `json:"baseline,omitempty"`
+}
+
+// BaselineSettings are analog offset DAC codes and digital baselines indexed by channel
+type BaselineSettings struct {
+	Target    int      `json:"target"`
+	Tolerance int      `json:"tolerance"`
+	Dac       []uint16 `json:"dac,omitempty"`
+	Digital   []int    `json:"digital,omitempty"`
+}
+
+// SnapshotDiff is a setting which differs in two snapshots
+type SnapshotDiff struct {
+	Setting string `json:"setting"`
+	Left    string `json:"left"`
+	Right   string `json:"right"`
+}
+
+// LeaseRequest acquires or renews the exclusive lease of a device or of all devices in a crate.
+// Either Device or Crate must be given.
+type LeaseRequest struct {
+	Device string  `json:"device,omitempty"`
+	Crate  *uint16 `json:"crate,omitempty"`
+	Owner  string  `json:"owner"`
+	TTL    int     `json:"ttl"` // seconds
+}
+
+// Lease gives the owner the exclusive right to change device settings until it expires
+type Lease struct {
+	Device   string  `json:"device,omitempty"`
+	Crate    *uint16 `json:"crate,omitempty"`
+	Owner    string  `json:"owner"`
+	Acquired uint64  `json:"acquired"`
+	Expires  uint64  `json:"expires"`
+}
+
+// Run states
+const (
+	RunIdle       = "idle"
+	RunConfigured = "configured"
+	RunRunning    = "running"
+	RunStopping   = "stopping"
+	RunStopped    = "stopped"
+	RunError      = "error"
+)
+
+// RunSetup describes the run requested by the operator
+type RunSetup struct {
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
+	// Dir and FilePrefix define where mstream data files are written
+	Dir        string `json:"dir,omitempty"`
+	FilePrefix string `json:"filePrefix,omitempty"`
+}
+
+// Run is the state of the current (or the last) data taking run
+type Run struct {
+	// Number is assigned when the run starts and is never reused
+	Number uint32 `json:"number"`
+	State  string `json:"state"`
+	RunSetup
+	Devices    []string `json:"devices,omitempty"`
+	Configured uint64   `json:"configured,omitempty"`
+	Started    uint64   `json:"started,omitempty"`
+	Stopped    uint64   `json:"stopped,omitempty"`
+	// StartTaiSec is the White Rabbit time all devices have been armed to start at.
+	// It is zero if devices have been started one by one.
+	StartTaiSec uint32 `json:"startTaiSec,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// RunDeviceState is the state of a device captured when a run starts or stops
+type RunDeviceState struct {
+	// Timestamp is the wall clock time in milliseconds, TaiSec is the device White Rabbit time
+	Timestamp    uint64    `json:"timestamp"`
+	TaiSec       uint32    `json:"taiSec"`
+	Firmware     string    `json:"firmware"`
+	EventCounter uint32    `json:"eventCounter"`
+	WrSyncLost   uint32    `json:"wrSyncLost"`
+	WrLinkErrors uint32    `json:"wrLinkErrors"`
+	TrigOnXoff   uint32    `json:"trigOnXoff"`
+	Snapshot     *Snapshot `json:"snapshot"`
+}
+
+// RunFile is a data file of a run
+type RunFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// RunAnnotation is a comment added to a run after it has been taken
+type RunAnnotation struct {
+	Timestamp uint64 `json:"timestamp"`
+	Text      string `json:"text"`
+}
+
+// RunRecord is the run catalog entry
+type RunRecord struct {
+	Run
+	Files       []*RunFile       `json:"files,omitempty"`
+	Annotations []*RunAnnotation `json:"annotations,omitempty"`
+}
+
+// RunFilter selects runs from the catalog. Zero values match any run.
+type RunFilter struct {
+	// From and To limit the run start time in milliseconds
+	From   uint64 `json:"from,omitempty"`
+	To     uint64 `json:"to,omitempty"`
+	State  string `json:"state,omitempty"`
+	Device string `json:"device,omitempty"`
+}
+
+// Match returns true if the run satisfies the filter
+func (f *RunFilter) Match(record *RunRecord) bool {
+	if f.From != 0 && record.Started < f.From {
+		return false
+	}
+	if f.To != 0 && record.Started > f.To {
+		return false
+	}
+	if f.State != "" && record.State != f.State {
+		return false
+	}
+	if f.Device != "" {
+		for _, device := range record.Devices {
+			if device == f.Device {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// AuditRecord is a write operation done through the control API
+type AuditRecord struct {
+	Seq       uint64 `json:"seq"`
+	Timestamp uint64 `json:"timestamp"`
+	Client    string `json:"client"`
+	Owner     string `json:"owner,omitempty"`
+	Method    string `json:"method"`
+	Endpoint  string `json:"endpoint"`
+	Device    string `json:"device,omitempty"`
+	Operation string `json:"operation"`
+	// Request is the request body, e.g. register address and value
+	Request string `json:"request,omitempty"`
+	// Register and Value are decoded from register writes, both are hexadecimal
+	Register string `json:"register,omitempty"`
+	Value    string `json:"value,omitempty"`
+	// Action is the run or MStream action, e.g. start
+	Action string `json:"action,omitempty"`
+	// Channels are decoded from requests changing channel settings
+	Channels []int  `json:"channels,omitempty"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AuditFilter selects audit records. Zero values match any record.
+type AuditFilter struct {
+	// From and To limit the record time in milliseconds
+	From      uint64 `json:"from,omitempty"`
+	To        uint64 `json:"to,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	// Register is the hexadecimal address of the written register, e.g. 0x0040
+	Register string `json:"register,omitempty"`
+	// Limit is the maximum number of the latest records to return
+	Limit int `json:"limit,omitempty"`
+}
+
+// Match returns true if the record satisfies the filter
+func (f *AuditFilter) Match(record *AuditRecord) bool {
+	if f.From != 0 && record.Timestamp < f.From {
+		return false
+	}
+	if f.To != 0 && record.Timestamp > f.To {
+		return false
+	}
+	if f.Device != "" && record.Device != f.Device {
+		return false
+	}
+	if f.Owner != "" && record.Owner != f.Owner {
+		return false
+	}
+	if f.Operation != "" && record.Operation != f.Operation {
+		return false
+	}
+	if f.Register != "" && record.Register != f.Register {
+		return false
+	}
+	return true
+}
+
+// RegSample is a register value recorded in the history. When the history is downsampled
+// Value is the last value in the interval, Min and Max are the extreme values and
+// Count is the number of recorded values in the interval.
+type RegSample struct {
+	Timestamp uint64 `json:"timestamp"` // milliseconds
+	Value     uint16 `json:"value"`
+	Min       uint16 `json:"min"`
+	Max       uint16 `json:"max"`
+	Count     int    `json:"count"`
+}
+
+// RegHistory is the history of a register value
+type RegHistory struct {
+	Device  string       `json:"device"`
+	Addr    string       `json:"addr"`
+	Step    uint64       `json:"step,omitempty"` // milliseconds, 0 if not downsampled
+	Samples []*RegSample `json:"samples"`
+}
+
+// Alarm is raised by the health monitor when a device violates a rule
+type Alarm struct {
+	Device   string `json:"device"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Value    uint16 `json:"value"`
+	// Raised and Cleared are times in milliseconds, Cleared is zero while the alarm is active
+	Raised  uint64 `json:"raised"`
+	Cleared uint64 `json:"cleared,omitempty"`
+	// Increased is the time the counter of an increase alarm has grown the last time in milliseconds
+	Increased uint64 `json:"increased,omitempty"`
+	// Acknowledged is the time the alarm was acknowledged in milliseconds
+	Acknowledged uint64 `json:"acknowledged,omitempty"`
+}
+
+// AlarmFilter selects alarms from the history. Zero values match any alarm.
+type AlarmFilter struct {
+	// From and To limit the time the alarm was raised in milliseconds
+	From   uint64 `json:"from,omitempty"`
+	To     uint64 `json:"to,omitempty"`
+	Device string `json:"device,omitempty"`
+	// Limit is the maximum number of the latest alarms to return
+	Limit int `json:"limit,omitempty"`
+}
+
+// Match returns true if the alarm satisfies the filter
+func (f *AlarmFilter) Match(alarm *Alarm) bool {
+	if f.From != 0 && alarm.Raised < f.From {
+		return false
+	}
+	if f.To != 0 && alarm.Raised > f.To {
+		return false
+	}
+	if f.Device != "" && alarm.Device != f.Device {
+		return false
+	}
+	return true
+}
+
+// IdentityReport is the result of the verification that the device at the configured IP
+// has the serial number declared in config. Serial numbers are hexadecimal.
+type IdentityReport struct {
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	Expected   string `json:"expected,omitempty"`
+	Register   string `json:"register,omitempty"`   // value of the serial number register, read if the device is not discovered
+	Discovered string `json:"discovered,omitempty"` // serial ID from MLDP
+	Verified   bool   `json:"verified"`
+	Error      string `json:"error,omitempty"`
+	Timestamp  uint64 `json:"timestamp"`
+}
+
+// Discovered device events
+const (
+	DeviceAppeared    = "appeared"
+	DeviceDisappeared = "disappeared"
+	DeviceChanged     = "changed"
+)
+
+// FieldChange is a changed field of the discovered device description
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// DeviceEvent is recorded when a discovered device appears, disappears or its description changes
+type DeviceEvent struct {
+	Timestamp uint64         `json:"timestamp"`
+	Serial    string         `json:"serial"`
+	Event     string         `json:"event"`
+	Changes   []*FieldChange `json:"changes,omitempty"`
+}
+
+// DeviceFilter selects discovered devices. Zero values match any device.
+type DeviceFilter struct {
+	Online   *bool   `json:"online,omitempty"`
+	IP       string  `json:"ip,omitempty"`
+	Firmware string  `json:"firmware,omitempty"`
+	Hardware string  `json:"hardware,omitempty"`
+	Slot     *uint16 `json:"slot,omitempty"`
+}
+
+// Match returns true if the device satisfies the filter
+func (f *DeviceFilter) Match(dd *DeviceDescription) bool {
+	if f.Online != nil && dd.Online != *f.Online {
+		return false
+	}
+	if f.IP != "" && dd.MasterIP.String() != f.IP && dd.Address.String() != f.IP {
+		return false
+	}
+	if f.Firmware != "" && dd.FirmwareRevision != f.Firmware {
+		return false
+	}
+	if f.Hardware != "" && dd.HardwareRevision != f.Hardware {
+		return false
+	}
+	if f.Slot != nil && dd.ChassisSlot != *f.Slot {
+		return false
+	}
+	return true
+}
+
+const (
+	IssueDuplicateIP   = "duplicateIP"
+	IssueDuplicateMac  = "duplicateMac"
+	IssueDuplicateSlot = "duplicateSlot"
+	IssueFirmware      = "firmware"
+	IssueHardware      = "hardware"
+)
+
+// DiscoverIssue is a conflict between discovered devices or a device with a revision not allowed in config
+type DiscoverIssue struct {
+	Kind    string   `json:"kind"`
+	Value   string   `json:"value"`
+	Serials []string `json:"serials"`
+	Message string   `json:"message"`
+}
+
+// DiscoverReport is the result of checking discovered devices
+type DiscoverReport struct {
+	Timestamp uint64           `json:"timestamp"`
+	Devices   int              `json:"devices"`
+	Issues    []*DiscoverIssue `json:"issues"`
+}
+
+// OK returns true if no issues have been found
+func (r *DiscoverReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// SyncStartDevice is the result of the synchronised start for a device
+type SyncStartDevice struct {
+	Device  string `json:"device"`
+	Running bool   `json:"running"`
+	// SeenRunningTaiSec is the device time when the device has been seen running for the first time.
+	// The device may have started up to the poll interval earlier.
+	SeenRunningTaiSec uint32 `json:"seenRunningTaiSec,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// SyncStartReport is the result of the synchronised start of several devices
+type SyncStartReport struct {
+	// TaiSec is the requested start time. It is zero if devices are started one by one
+	// because some of them do not support the timed start.
+	TaiSec  uint32             `json:"taiSec"`
+	Devices []*SyncStartDevice `json:"devices"`
+}
+
+// Failed returns the list of devices which have not started or have started at a wrong time
+func (r *SyncStartReport) Failed() []string {
+	var failed []string
+	for _, d := range r.Devices {
+		if !d.Running || d.Error != "" {
+			failed = append(failed, d.Device)
+		}
+	}
+	return failed
+}
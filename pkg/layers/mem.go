@@ -16,6 +16,7 @@ package layers
 
 import (
 	"encoding/binary"
+	"errors"
 	"hash/crc32"
 
 	"github.com/google/gopacket"
@@ -58,14 +59,18 @@ func (mem *MemLayer) Serialize(buf []byte) {
 		binary.LittleEndian.PutUint32(buf[0:4], 0x00000000|((mem.Size&0x1ff)<<22)|(mem.Addr&0x3fffff))
 		for i, word := range mem.Data {
 			offset := (i + 1) * 4
+			if offset+4 > len(buf) {
+				break
+			}
 			binary.LittleEndian.PutUint32(buf[offset:offset+4], word)
 		}
 	}
 }
 
 // SerializeTo serializes the register read/write request layer into bytes and writes the bytes to the SerializeBuffer
+// The layer takes one word for the header and Size words for data as it is defined by MLink frame length.
 func (mem *MemLayer) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
-	bytes, err := b.AppendBytes(4)
+	bytes, err := b.AppendBytes(int(1+mem.Size) * 4)
 	if err != nil {
 		return err
 	}
@@ -78,6 +83,11 @@ func (mem *MemLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) er
 		Contents: data,
 		Payload:  []byte{},
 	}
+	if len(data) < 4 {
+		df.SetTruncated()
+		return errors.New("Mem packet too short")
+	}
+	mem.MemOp = &MemOp{}
 	hdr := binary.LittleEndian.Uint32(data[0:4])
 	if int8((hdr&0x80000000)>>31) == 1 {
 		mem.Read = true
@@ -86,8 +96,9 @@ func (mem *MemLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) er
 	}
 	mem.Addr = hdr & 0x3fffff
 	mem.Size = (hdr >> 22) & 0x1ff
-	for i := uint32(0); i < mem.Size; i++ {
-		mem.Data = append(mem.Data, binary.LittleEndian.Uint32(data[i+4:i+8]))
+	for i := uint32(0); i < mem.Size && int(i+2)*4 <= len(data); i++ {
+		offset := (i + 1) * 4
+		mem.Data = append(mem.Data, binary.LittleEndian.Uint32(data[offset:offset+4]))
 	}
 	return nil
 }
@@ -108,6 +108,10 @@ type DeviceDescription struct {
 	Address          net.IP `json:"address"`
 	Port             uint16 `json:"port"`
 	Timestamp        uint64 `json:"timestamp,omitempty"`
+	// FirstSeen is when the device was discovered for the first time, Timestamp is when it was seen last time.
+	// Online is false if the device has not been seen for the offline timeout.
+	FirstSeen uint64 `json:"firstSeen,omitempty"`
+	Online    bool   `json:"online"`
 }
 
 func (dd *DeviceDescription) SetSource(udpAddr *net.UDPAddr) error {
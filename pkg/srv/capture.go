@@ -0,0 +1,49 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package srv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapngMagic is the type of the section header block starting pcapng files
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// NewCaptureSource returns the source of packets read from a pcap or pcapng capture.
+// The format is detected by the file header.
+func NewCaptureSource(r io.Reader) (*gopacket.PacketSource, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(magic, pcapngMagic) {
+		reader, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, err
+		}
+		return gopacket.NewPacketSource(reader, reader.LinkType()), nil
+	}
+	reader, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return gopacket.NewPacketSource(reader, reader.LinkType()), nil
+}
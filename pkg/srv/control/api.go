@@ -46,12 +46,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"jinr.ru/greenlab/go-adc/pkg/config"
 	devicepkg "jinr.ru/greenlab/go-adc/pkg/device"
+	deviceifc "jinr.ru/greenlab/go-adc/pkg/device/ifc"
 	"jinr.ru/greenlab/go-adc/pkg/layers"
 	"jinr.ru/greenlab/go-adc/pkg/log"
 	"jinr.ru/greenlab/go-adc/pkg/srv"
@@ -84,6 +87,42 @@ type ReqBadRequest struct {
 type RegHex struct {
 	Addr  string // hexadecimal
 	Value string // hexadecimal
+	// Updated is the time (milliseconds) when the value was read from the device
+	// and Age is how old the value is (milliseconds). Both are zero if unknown.
+	Updated uint64 `json:",omitempty"`
+	Age     uint64 `json:",omitempty"`
+}
+
+// setAge fills the time when the value was read from the device and how old it is
+func (r *RegHex) setAge(updated uint64) {
+	if updated == 0 {
+		return
+	}
+	r.Updated = updated
+	if now := uint64(time.Now().UnixNano()) / uint64(time.Millisecond); now > updated {
+		r.Age = now - updated
+	}
+}
+
+// AdcSpiValue ...
+type AdcSpiValue struct {
+	Adc   int
+	Reg   string
+	Addr  string // hexadecimal
+	Value string // hexadecimal
+}
+
+// BaselineValue ...
+type BaselineValue struct {
+	Ch      int
+	Dac     *uint16 `json:",omitempty"`
+	Digital *int    `json:",omitempty"`
+}
+
+// BaselineEqualize ...
+type BaselineEqualize struct {
+	Target    int
+	Tolerance int
 }
 
 type TrigSetup struct {
@@ -101,9 +140,13 @@ type InvertSetup struct {
 	Invert bool
 }
 
+// FirSetup loads the preset if it is given, otherwise coefficients and roundoff
+// which are given. The filter is enabled/disabled only when Enabled is given.
 type FirSetup struct {
-	Coef     []uint16
-	Roundoff uint16
+	Coef     []int   `json:",omitempty"`
+	Roundoff *uint16 `json:",omitempty"`
+	Preset   string  `json:",omitempty"`
+	Enabled  *bool   `json:",omitempty"`
 }
 
 type ReadoutWindowSetup struct {
@@ -119,7 +162,10 @@ type ApiServer struct {
 	context.Context
 	*config.Config
 	*mux.Router
-	ctrl ifc.ControlServer
+	ctrl   ifc.ControlServer
+	jobs   *JobManager
+	leases *LeaseManager
+	audit  *AuditLog
 }
 
 var _ ifc.ApiServer = &ApiServer{}
@@ -131,7 +177,14 @@ func NewApiServer(ctx context.Context, cfg *config.Config, ctrl ifc.ControlServe
 		Context: ctx,
 		Config:  cfg,
 		ctrl:    ctrl,
+		jobs:    NewJobManager(ctx),
+		leases:  NewLeaseManager(cfg),
 	}
+	audit, err := NewAuditLog(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.audit = audit
 	return s, nil
 }
 
@@ -140,15 +193,26 @@ func (s *ApiServer) regReadHex(addr uint16, device string) (*RegHex, error) {
 	if err != nil {
 		return nil, err
 	}
-	reg, err := d.RegRead(addr)
+	var reg *layers.Reg
+	var times map[uint16]uint64
+	err = d.Do(func() (err error) {
+		reg, err = d.RegRead(addr)
+		if err != nil {
+			return err
+		}
+		times, err = d.RegTimes([]uint16{addr})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	hexAddr, hexValue := reg.Hex()
-	return &RegHex{
+	regHex := &RegHex{
 		Addr:  hexAddr,
 		Value: hexValue,
-	}, nil
+	}
+	regHex.setAge(times[addr])
+	return regHex, nil
 }
 
 func (s *ApiServer) regReadAllHex(device string) ([]*RegHex, error) {
@@ -156,14 +220,29 @@ func (s *ApiServer) regReadAllHex(device string) ([]*RegHex, error) {
 	if err != nil {
 		return nil, err
 	}
-	regs, err := d.RegReadAll()
+	var regs []*layers.Reg
+	var times map[uint16]uint64
+	err = d.Do(func() (err error) {
+		regs, err = d.RegReadAll()
+		if err != nil {
+			return err
+		}
+		var addrs []uint16
+		for _, reg := range regs {
+			addrs = append(addrs, reg.Addr)
+		}
+		times, err = d.RegTimes(addrs)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	regsHex := []*RegHex{}
 	for _, reg := range regs {
 		hexAddr, hexValue := reg.Hex()
-		regsHex = append(regsHex, &RegHex{Addr: hexAddr, Value: hexValue})
+		regHex := &RegHex{Addr: hexAddr, Value: hexValue}
+		regHex.setAge(times[reg.Addr])
+		regsHex = append(regsHex, regHex)
 	}
 	return regsHex, nil
 }
@@ -176,6 +255,10 @@ func (s *ApiServer) Run() error {
 		Handler: s.Router,
 		Addr:    fmt.Sprintf("%s:%d", s.Config.IP, ApiPort),
 	}
+	go func() {
+		<-s.Done()
+		httpServer.Close()
+	}()
 	return httpServer.ListenAndServe()
 }
 
@@ -211,7 +294,17 @@ func (s *ApiServer) configureRouter() {
 	//     "$ref": "#/responses/okResp"
 	//   "400":
 	//     "$ref": "#/responses/badReq"
-	subRouter.HandleFunc("/reg/w/{device}", s.handleRegWrite()).Methods("POST")
+	// swagger:operation GET /reg/history/device/addr register history
+	// ---
+	// summary: read history of register values
+	// description: from and to (RFC3339) limit the sample time, step (e.g. 1m) downsamples the history
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/reg/history/{device}/{addr:0x[0-9abcdef]{4}}", s.handleRegHistory()).Methods("GET")
+	subRouter.HandleFunc("/reg/w/{device}", s.audited("reg write", s.leased(s.handleRegWrite()))).Methods("POST")
 	// swagger:operation GET /mstream/{action:start|stop}/device start/stop
 	// ---
 	// summary: start/stop acquisition for device
@@ -221,24 +314,160 @@ func (s *ApiServer) configureRouter() {
 	//     "$ref": "#/responses/okResp"
 	//   "400":
 	//     "$ref": "#/responses/badReq"
-	subRouter.HandleFunc("/mstream/{action:start|stop}/{device}", s.handleMStreamAction()).Methods("GET")
+	subRouter.HandleFunc("/mstream/{action:start|stop}/{device}", s.audited("mstream", s.leased(s.handleMStreamAction()))).Methods("GET")
 	// swagger:operation GET /mstream/{action:start|stop}
 	// ---
 	// summary: start/stop acquisition for all devices
-	// description:
+	// description: devices are started at the same White Rabbit time delay seconds ahead
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/mstream/{action:start|stop}", s.audited("mstream all", s.leasedAll(s.handleMStreamActionAll()))).Methods("GET")
+	subRouter.HandleFunc("/trigger/{device}", s.audited("trigger", s.leased(s.handleTrigger()))).Methods("POST")
+	subRouter.HandleFunc("/maf/{device}", s.audited("maf", s.leased(s.handleMAF()))).Methods("POST")
+	subRouter.HandleFunc("/invert_signal/{device}", s.audited("invert", s.leased(s.handleInvert()))).Methods("POST")
+	subRouter.HandleFunc("/fir/{device}", s.audited("fir", s.leased(s.handleFir()))).Methods("POST")
+	subRouter.HandleFunc("/fir/{device}", s.handleFirRead()).Methods("GET")
+	subRouter.HandleFunc("/fir_presets/{device}", s.handleFirPresets()).Methods("GET")
+	subRouter.HandleFunc("/readout_window/{device}", s.audited("readout window", s.leased(s.handleReadoutWindow()))).Methods("POST")
+	subRouter.HandleFunc("/channels/{device}", s.audited("channels", s.leased(s.handleChannels()))).Methods("POST")
+	subRouter.HandleFunc("/zs/{device}", s.audited("zs", s.leased(s.handleZs()))).Methods("POST")
+	subRouter.HandleFunc("/adc_spi/{device}", s.handleAdcSpiChip()).Methods("GET")
+	subRouter.HandleFunc("/adc_spi/{device}/{adc:[0-9]+}/{reg}", s.handleAdcSpiRead()).Methods("GET")
+	subRouter.HandleFunc("/adc_spi/{device}/{adc:[0-9]+}/{reg}", s.audited("adc spi write", s.leased(s.handleAdcSpiWrite()))).Methods("POST")
+	subRouter.HandleFunc("/baseline/{device}", s.handleBaselineGet()).Methods("GET")
+	subRouter.HandleFunc("/baseline/{device}", s.audited("baseline", s.leased(s.handleBaselineSet()))).Methods("POST")
+	subRouter.HandleFunc("/baseline/equalize/{device}", s.audited("baseline equalize", s.leased(s.handleBaselineEqualize()))).Methods("POST")
+	subRouter.HandleFunc("/scan/threshold/{device}", s.audited("threshold scan", s.leased(s.handleScanThreshold()))).Methods("POST")
+	subRouter.HandleFunc("/jobs", s.handleJobs()).Methods("GET")
+	subRouter.HandleFunc("/jobs/{id}", s.handleJob()).Methods("GET")
+	subRouter.HandleFunc("/jobs/{id}", s.audited("job cancel", s.handleJobCancel())).Methods("DELETE")
+	subRouter.HandleFunc("/drift/{device}", s.handleDrift()).Methods("GET")
+	subRouter.HandleFunc("/drift/apply/{device}", s.audited("drift apply", s.leased(s.handleDriftApply()))).Methods("POST")
+	subRouter.HandleFunc("/snapshot/{device}", s.handleSnapshotSave()).Methods("GET")
+	subRouter.HandleFunc("/snapshot/{device}", s.audited("snapshot restore", s.leased(s.handleSnapshotRestore()))).Methods("POST")
+	subRouter.HandleFunc("/snapshot/diff/{device}", s.handleSnapshotDiff()).Methods("POST")
+	subRouter.HandleFunc("/des/train/{device}", s.audited("des train", s.leased(s.handleDesTrain()))).Methods("POST")
+	subRouter.HandleFunc("/des/{device}", s.handleDesResult()).Methods("GET")
+	// swagger:operation GET /settings/{device} settings getSettings
+	// ---
+	// summary: software settings of the device
+	// description: settings kept by go-adc which can not be read from the device, they survive restarts
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "404":
+	//     description: device not found
+	subRouter.HandleFunc("/settings/{device}", s.handleSettings()).Methods("GET")
+	// swagger:operation GET /run run getRun
+	// ---
+	// summary: current run state
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	subRouter.HandleFunc("/run", s.handleRun()).Methods("GET")
+	// swagger:operation POST /run/{action} run postRunAction
+	// ---
+	// summary: configure, start or stop the run on all devices
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	//   "409":
+	//     description: action is not allowed in the current run state
+	subRouter.HandleFunc("/run/{action:configure|start|stop}", s.audited("run", s.leasedAll(s.handleRunAction()))).Methods("POST")
+	// swagger:operation GET /runs runs getRuns
+	// ---
+	// summary: list cataloged runs
+	// description: from and to (RFC3339) limit the run start time, state and device select runs
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/runs", s.handleRuns()).Methods("GET")
+	subRouter.HandleFunc("/runs/{number:[0-9]+}", s.handleRunRecord()).Methods("GET")
+	subRouter.HandleFunc("/runs/{number:[0-9]+}/annotate", s.audited("run annotate", s.handleRunAnnotate())).Methods("POST")
+	// swagger:operation GET /leases leases getLeases
+	// ---
+	// summary: list active device and crate leases
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	subRouter.HandleFunc("/leases", s.handleLeases()).Methods("GET")
+	// swagger:operation POST /leases leases postLease
+	// ---
+	// summary: acquire or renew the exclusive lease of a device or a crate
+	// description: write endpoints of a device require the lease of the device or its crate (X-Lease-Owner header)
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	//   "423":
+	//     description: leased by another owner
+	subRouter.HandleFunc("/leases", s.audited("lease acquire", s.handleLeaseAcquire())).Methods("POST")
+	subRouter.HandleFunc("/leases/device/{device}", s.audited("lease release", s.handleLeaseRelease())).Methods("DELETE")
+	subRouter.HandleFunc("/leases/crate/{crate:[0-9]+}", s.audited("lease release", s.handleLeaseRelease())).Methods("DELETE")
+	// swagger:operation GET /audit audit getAudit
+	// ---
+	// summary: list write operations done through the API
+	// description: from and to (RFC3339) limit the record time, device, owner, operation and register select records, limit keeps the latest ones
 	// responses:
 	//   "200":
 	//     "$ref": "#/responses/okResp"
 	//   "400":
 	//     "$ref": "#/responses/badReq"
-	subRouter.HandleFunc("/mstream/{action:start|stop}", s.handleMStreamActionAll()).Methods("GET")
-	subRouter.HandleFunc("/trigger/{device}", s.handleTrigger()).Methods("POST")
-	subRouter.HandleFunc("/maf/{device}", s.handleMAF()).Methods("POST")
-	subRouter.HandleFunc("/invert_signal/{device}", s.handleInvert()).Methods("POST")
-	subRouter.HandleFunc("/fir/{device}", s.handleFir()).Methods("POST")
-	subRouter.HandleFunc("/readout_window/{device}", s.handleReadoutWindow()).Methods("POST")
-	subRouter.HandleFunc("/channels/{device}", s.handleChannels()).Methods("POST")
-	subRouter.HandleFunc("/zs/{device}", s.handleZs()).Methods("POST")
+	subRouter.HandleFunc("/audit", s.handleAudit()).Methods("GET")
+	// swagger:operation GET /identity identity getIdentity
+	// ---
+	// summary: list results of the device identity verification
+	// description: --
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	subRouter.HandleFunc("/identity", s.handleIdentity()).Methods("GET")
+	// swagger:operation GET /identity/device identity verifyIdentity
+	// ---
+	// summary: verify that the device has the serial number declared in config
+	// description: --
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/identity/{device}", s.handleVerifyIdentity()).Methods("GET")
+	// swagger:operation GET /alarms alarms getAlarms
+	// ---
+	// summary: list active alarms raised by the health monitor
+	// description: --
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	subRouter.HandleFunc("/alarms", s.handleAlarms()).Methods("GET")
+	// swagger:operation GET /alarms/history alarms getAlarmHistory
+	// ---
+	// summary: list raised and cleared alarms
+	// description: from and to (RFC3339) limit the time the alarm was raised, device selects alarms, limit keeps the latest ones
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/alarms/history", s.handleAlarmHistory()).Methods("GET")
+	// swagger:operation POST /alarms/ack/{device}/{rule} alarms postAlarmAck
+	// ---
+	// summary: acknowledge the active alarm
+	// description: increase alarms stay active until they are acknowledged or the hold time passes
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/alarms/ack/{device}/{rule}", s.audited("alarm ack", s.handleAlarmAck())).Methods("POST")
 	s.Router.PathPrefix("/swagger/").Handler(http.StripPrefix("/swagger/", http.FileServer(http.Dir("./swaggerui/"))))
 }
 
@@ -279,6 +508,42 @@ func (s *ApiServer) handleRegReadAll() http.HandlerFunc {
 	}
 }
 
+func (s *ApiServer) handleRegHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		log.Debug("Handling reg history request: device: %s, addr: %s", vars["device"], vars["addr"])
+
+		addr, err := strconv.ParseUint(vars["addr"], 0, 16)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		from, to, err := parseTimeRange(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var step time.Duration
+		if value := query.Get("step"); value != "" {
+			step, err = time.ParseDuration(value)
+			if err != nil || step < 0 {
+				http.Error(w, fmt.Sprintf("Wrong step: %s", value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		history, err := s.ctrl.GetRegHistory(vars["device"], uint16(addr), from, to, uint64(step/time.Millisecond))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
 func (s *ApiServer) handleRegWrite() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -304,7 +569,9 @@ func (s *ApiServer) handleRegWrite() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		err = device.RegWrite(reg)
+		err = device.DoContext(r.Context(), func() error {
+			return device.RegWrite(reg)
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
@@ -323,13 +590,13 @@ func (s *ApiServer) handleMStreamAction() http.HandlerFunc {
 		}
 		switch vars["action"] {
 		case "start":
-			err = device.MStreamStart()
+			err = device.Do(device.MStreamStart)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadGateway)
 				return
 			}
 		case "stop":
-			err := device.MStreamStop()
+			err := device.Do(device.MStreamStop)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadGateway)
 				return
@@ -347,18 +614,39 @@ func (s *ApiServer) handleMStreamActionAll() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		log.Debug("Handling MStream action request for all devices: action: %s", vars["action"])
+		// devices are handled in the config order
+		var names []string
+		for _, d := range s.Config.Devices {
+			names = append(names, d.Name)
+		}
 		switch vars["action"] {
 		case "start":
-			for _, d := range s.ctrl.GetAllDevices() {
-				err := d.MStreamStart()
-				if err != nil {
+			delay := 0
+			if value := r.URL.Query().Get("delay"); value != "" {
+				var err error
+				if delay, err = strconv.Atoi(value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			report, err := s.ctrl.SyncStart(names, delay)
+			if err != nil {
+				if report == nil {
 					http.Error(w, err.Error(), http.StatusBadGateway)
 					return
 				}
+				// the report tells which devices have not started
+				w.WriteHeader(http.StatusBadGateway)
 			}
+			json.NewEncoder(w).Encode(report)
 		case "stop":
-			for _, d := range s.ctrl.GetAllDevices() {
-				err := d.MStreamStop()
+			for _, name := range names {
+				d, err := s.ctrl.GetDeviceByName(name)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				err = d.Do(d.MStreamStop)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusBadGateway)
 					return
@@ -389,18 +677,27 @@ func (s *ApiServer) handleTrigger() http.HandlerFunc {
 			return
 		}
 
-		if setup.Timer != "" {
-			val, _ := strconv.ParseBool(setup.Timer)
-			err = device.SetTrigger(devicepkg.RegTrigStatusBitTimer, val)
-		}
-		if setup.Threshold != "" {
-			val, _ := strconv.ParseBool(setup.Threshold)
-			err = device.SetTrigger(devicepkg.RegTrigStatusBitThreshold, val)
-		}
-		if setup.Lemo != "" {
-			val, _ := strconv.ParseBool(setup.Lemo)
-			err = device.SetTrigger(devicepkg.RegTrigStatusBitLemo, val)
-		}
+		err = device.DoContext(r.Context(), func() error {
+			if setup.Timer != "" {
+				val, _ := strconv.ParseBool(setup.Timer)
+				if err := device.SetTrigger(devicepkg.RegTrigStatusBitTimer, val); err != nil {
+					return err
+				}
+			}
+			if setup.Threshold != "" {
+				val, _ := strconv.ParseBool(setup.Threshold)
+				if err := device.SetTrigger(devicepkg.RegTrigStatusBitThreshold, val); err != nil {
+					return err
+				}
+			}
+			if setup.Lemo != "" {
+				val, _ := strconv.ParseBool(setup.Lemo)
+				if err := device.SetTrigger(devicepkg.RegTrigStatusBitLemo, val); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
@@ -426,8 +723,12 @@ func (s *ApiServer) handleMAF() http.HandlerFunc {
 			return
 		}
 
-		err = device.SetMafSelector(setup.Selector)
-		err = device.SetMafBlcThresh(setup.BLC)
+		err = device.DoContext(r.Context(), func() error {
+			if err := device.SetMafSelector(setup.Selector); err != nil {
+				return err
+			}
+			return device.SetMafBlcThresh(setup.BLC)
+		})
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
@@ -453,7 +754,9 @@ func (s *ApiServer) handleInvert() http.HandlerFunc {
 			return
 		}
 
-		err = device.SetInvert(setup.Invert)
+		err = device.DoContext(r.Context(), func() error {
+			return device.SetInvert(setup.Invert)
+		})
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
@@ -479,13 +782,97 @@ func (s *ApiServer) handleFir() http.HandlerFunc {
 			return
 		}
 
-		err = device.SetRoundoff(setup.Roundoff)
-		err = device.SetFirCoef(setup.Coef)
+		// nothing is written unless the whole setup is valid
+		if setup.Preset != "" {
+			if _, ok := device.GetFirPresets()[setup.Preset]; !ok {
+				http.Error(w, devicepkg.ErrFirPreset{Name: setup.Preset}.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if len(setup.Coef) > 0 {
+			if _, err := devicepkg.FirCoefToRegs(setup.Coef); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// the filter must not be seen half-loaded, so the whole setup is one device operation
+		// and the filter is disabled before loading and enabled after it
+		err = device.DoContext(r.Context(), func() error {
+			if setup.Enabled != nil && !*setup.Enabled {
+				if err := device.SetFirEnabled(false); err != nil {
+					return err
+				}
+			}
+			if setup.Preset != "" {
+				if err := device.SetFirPreset(setup.Preset); err != nil {
+					return err
+				}
+			} else {
+				if setup.Roundoff != nil {
+					if err := device.SetRoundoff(*setup.Roundoff); err != nil {
+						return err
+					}
+				}
+				if len(setup.Coef) > 0 {
+					if err := device.SetFirCoef(setup.Coef); err != nil {
+						return err
+					}
+				}
+			}
+			if setup.Enabled != nil && *setup.Enabled {
+				return device.SetFirEnabled(true)
+			}
+			return nil
+		})
+		if err != nil {
+			switch err.(type) {
+			case devicepkg.ErrFirPreset, devicepkg.ErrFirCoefNum, devicepkg.ErrFirCoefRange:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
+	}
+}
+
+func (s *ApiServer) handleFirRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling FIR read request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 
+		var state *layers.FirState
+		err = device.DoContext(r.Context(), func() (err error) {
+			state, err = device.ReadFir()
+			return err
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
 		}
+
+		json.NewEncoder(w).Encode(state)
+	}
+}
+
+func (s *ApiServer) handleFirPresets() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling FIR presets request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(device.GetFirPresets())
 	}
 }
 
@@ -506,8 +893,12 @@ func (s *ApiServer) handleReadoutWindow() http.HandlerFunc {
 			return
 		}
 
-		err = device.SetWindowSize(setup.Size)
-		err = device.SetLatency(setup.Latency)
+		err = device.DoContext(r.Context(), func() error {
+			if err := device.SetWindowSize(setup.Size); err != nil {
+				return err
+			}
+			return device.SetLatency(setup.Latency)
+		})
 
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
@@ -533,9 +924,15 @@ func (s *ApiServer) handleChannels() http.HandlerFunc {
 			return
 		}
 
-		err = device.SetChannels(*setup)
+		err = device.DoContext(r.Context(), func() error {
+			return device.SetChannels(*setup)
+		})
 
 		if err != nil {
+			if _, ok := err.(devicepkg.ErrChIndex); ok {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
 		}
@@ -559,11 +956,892 @@ func (s *ApiServer) handleZs() http.HandlerFunc {
 			return
 		}
 
-		err = device.SetZs(setup.Zs)
+		err = device.DoContext(r.Context(), func() error {
+			return device.SetZs(setup.Zs)
+		})
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+}
+
+func (s *ApiServer) handleAdcSpiChip() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling ADC SPI chip request: device: %s", vars["device"])
+
+		cfgDevice, err := s.Config.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		chip, err := devicepkg.AdcChipFor(cfgDevice)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(chip)
+	}
+}
+
+// adcSpiTarget parses request variables and returns the device, the ADC chip index and the ADC chip register
+func (s *ApiServer) adcSpiTarget(vars map[string]string) (deviceifc.Device, int, *devicepkg.AdcSpiReg, int, error) {
+	cfgDevice, err := s.Config.GetDeviceByName(vars["device"])
+	if err != nil {
+		return nil, 0, nil, http.StatusNotFound, err
+	}
+	chip, err := devicepkg.AdcChipFor(cfgDevice)
+	if err != nil {
+		return nil, 0, nil, http.StatusBadRequest, err
+	}
+	adc, err := strconv.Atoi(vars["adc"])
+	if err != nil {
+		return nil, 0, nil, http.StatusBadRequest, err
+	}
+	reg, err := chip.GetReg(vars["reg"])
+	if err != nil {
+		return nil, 0, nil, http.StatusBadRequest, err
+	}
+	device, err := s.ctrl.GetDeviceByName(vars["device"])
+	if err != nil {
+		return nil, 0, nil, http.StatusNotFound, err
+	}
+	return device, adc, reg, http.StatusOK, nil
+}
+
+func (s *ApiServer) handleAdcSpiRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling ADC SPI read request: device: %s adc: %s reg: %s", vars["device"], vars["adc"], vars["reg"])
+
+		device, adc, reg, status, err := s.adcSpiTarget(vars)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		var value uint8
+		err = device.DoContext(r.Context(), func() (err error) {
+			value, err = device.AdcSpiRead(adc, reg.Addr)
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(&AdcSpiValue{
+			Adc:   adc,
+			Reg:   reg.Name,
+			Addr:  fmt.Sprintf("0x%02x", reg.Addr),
+			Value: fmt.Sprintf("0x%02x", value),
+		})
+	}
+}
+
+func (s *ApiServer) handleAdcSpiWrite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		spiValue := &AdcSpiValue{}
+		err := json.NewDecoder(r.Body).Decode(spiValue)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Debug("Handling ADC SPI write request: device: %s adc: %s reg: %s value: %s",
+			vars["device"], vars["adc"], vars["reg"], spiValue.Value)
 
+		device, adc, reg, status, err := s.adcSpiTarget(vars)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if reg.ReadOnly {
+			http.Error(w, fmt.Sprintf("Register is read only: %s", reg.Name), http.StatusBadRequest)
+			return
+		}
+
+		value, err := strconv.ParseUint(spiValue.Value, 0, 8)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = device.DoContext(r.Context(), func() error {
+			return device.AdcSpiWrite(adc, reg.Addr, uint8(value))
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
 		}
 	}
 }
+
+func (s *ApiServer) handleDesTrain() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling deserializer training request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		result, err := device.TrainDes(r.Context())
+		if err != nil {
+			if _, ok := err.(devicepkg.ErrDeviceBusy); ok {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (s *ApiServer) handleDesResult() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling deserializer result request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		result, err := device.GetDesResult()
+		if err != nil {
+			if _, ok := err.(srv.ErrNotFound); ok {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (s *ApiServer) handleBaselineGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling baseline request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		var result *layers.BaselineResult
+		err = device.DoContext(r.Context(), func() (err error) {
+			result, err = device.MeasureBaseline()
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (s *ApiServer) handleBaselineSet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		value := &BaselineValue{}
+		err := json.NewDecoder(r.Body).Decode(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling baseline set request: device: %s channel: %d", vars["device"], value.Ch)
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = device.DoContext(r.Context(), func() error {
+			if value.Dac != nil {
+				if err := device.SetDac(value.Ch, *value.Dac); err != nil {
+					return err
+				}
+			}
+			if value.Digital != nil {
+				return device.SetDigitalBaseline(value.Ch, *value.Digital)
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+func (s *ApiServer) handleBaselineEqualize() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		setup := &BaselineEqualize{}
+		err := json.NewDecoder(r.Body).Decode(setup)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling baseline equalization request: device: %s target: %d tolerance: %d",
+			vars["device"], setup.Target, setup.Tolerance)
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		result, err := device.EqualizeBaseline(r.Context(), setup.Target, setup.Tolerance)
+		if err != nil {
+			if _, ok := err.(devicepkg.ErrDeviceBusy); ok {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (s *ApiServer) handleScanThreshold() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		setup := &layers.ThresholdScanSetup{}
+		err := json.NewDecoder(r.Body).Decode(setup)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling threshold scan request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		// the scan is useless if triggers are not counted, so it is rejected before the job starts
+		var running bool
+		err = device.DoContext(r.Context(), func() (err error) {
+			running, _, err = device.ReadRunStatus()
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !running {
+			http.Error(w, devicepkg.ErrNotRunning{Device: device.GetName()}.Error(), http.StatusConflict)
+			return
+		}
+
+		job := s.jobs.Start("threshold-scan", device.GetName(), func(ctx context.Context, progress func(float64)) (interface{}, error) {
+			return device.ScanThreshold(ctx, setup, progress)
+		})
+
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+func (s *ApiServer) handleJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling job list request")
+		json.NewEncoder(w).Encode(s.jobs.List())
+	}
+}
+
+func (s *ApiServer) handleJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling job request: id: %s", vars["id"])
+
+		job, err := s.jobs.Get(vars["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+func (s *ApiServer) handleJobCancel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling job cancel request: id: %s", vars["id"])
+
+		job, err := s.jobs.Cancel(vars["id"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+func (s *ApiServer) handleDrift() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+		log.Debug("Handling drift request: device: %s refresh: %t", vars["device"], refresh)
+
+		if _, err := s.ctrl.GetDeviceByName(vars["device"]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		var report *layers.DriftReport
+		var err error
+		if refresh {
+			report, err = s.ctrl.CheckDrift(vars["device"], false)
+		} else {
+			report, err = s.ctrl.GetDrift(vars["device"])
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func (s *ApiServer) handleDriftApply() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling drift apply request: device: %s", vars["device"])
+
+		if _, err := s.ctrl.GetDeviceByName(vars["device"]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		report, err := s.ctrl.CheckDrift(vars["device"], true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func (s *ApiServer) handleSnapshotSave() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling snapshot request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		var snapshot *layers.Snapshot
+		err = device.DoContext(r.Context(), func() (err error) {
+			snapshot, err = device.Snapshot()
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+func (s *ApiServer) handleSnapshotRestore() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		snapshot := &layers.Snapshot{}
+		err := json.NewDecoder(r.Body).Decode(snapshot)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling snapshot restore request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = device.DoContext(r.Context(), func() error {
+			return device.Restore(snapshot)
+		})
+		if err != nil {
+			switch err.(type) {
+			case devicepkg.ErrSnapshotVersion, devicepkg.ErrChIndex, devicepkg.ErrFirCoefNum, devicepkg.ErrFirCoefRange, devicepkg.ErrDacCode:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
+	}
+}
+
+func (s *ApiServer) handleSnapshotDiff() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		snapshot := &layers.Snapshot{}
+		err := json.NewDecoder(r.Body).Decode(snapshot)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling snapshot diff request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		var live *layers.Snapshot
+		err = device.DoContext(r.Context(), func() (err error) {
+			live, err = device.Snapshot()
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		diffs, err := devicepkg.DiffSnapshots(snapshot, live)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(diffs)
+	}
+}
+
+func (s *ApiServer) handleRun() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling run state request")
+		json.NewEncoder(w).Encode(s.ctrl.GetRun())
+	}
+}
+
+func (s *ApiServer) handleRunAction() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling run action request: action: %s", vars["action"])
+
+		var setup *layers.RunSetup
+		if vars["action"] != "stop" && r.ContentLength != 0 {
+			setup = &layers.RunSetup{}
+			if err := json.NewDecoder(r.Body).Decode(setup); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var run *layers.Run
+		var err error
+		switch vars["action"] {
+		case "configure":
+			if setup == nil {
+				setup = &layers.RunSetup{}
+			}
+			run, err = s.ctrl.ConfigureRun(setup)
+		case "start":
+			run, err = s.ctrl.StartRun(setup)
+		case "stop":
+			run, err = s.ctrl.StopRun()
+		}
+		if err != nil {
+			switch err.(type) {
+			case srv.ErrRunState:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(run)
+	}
+}
+
+// parseRunFilter reads the run filter from query parameters
+func parseRunFilter(r *http.Request) (*layers.RunFilter, error) {
+	query := r.URL.Query()
+	filter := &layers.RunFilter{
+		State:  query.Get("state"),
+		Device: query.Get("device"),
+	}
+	var err error
+	filter.From, filter.To, err = parseTimeRange(query)
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (s *ApiServer) handleRuns() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseRunFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling run list request: filter: %+v", filter)
+
+		records, err := s.ctrl.ListRuns(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(records)
+	}
+}
+
+func (s *ApiServer) handleRunRecord() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling run record request: number: %s", vars["number"])
+		number, err := strconv.ParseUint(vars["number"], 10, 32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		record, err := s.ctrl.GetRunRecord(uint32(number))
+		if err != nil {
+			switch err.(type) {
+			case srv.ErrNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(record)
+	}
+}
+
+func (s *ApiServer) handleRunAnnotate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		number, err := strconv.ParseUint(vars["number"], 10, 32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		annotation := &layers.RunAnnotation{}
+		err = json.NewDecoder(r.Body).Decode(annotation)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling run annotate request: number: %d", number)
+
+		record, err := s.ctrl.AnnotateRun(uint32(number), annotation.Text)
+		if err != nil {
+			switch err.(type) {
+			case srv.ErrNotFound:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(record)
+	}
+}
+
+func (s *ApiServer) handleSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling settings request: device: %s", vars["device"])
+
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		var settings *layers.DeviceSettings
+		device.Do(func() error {
+			settings = device.Settings()
+			return nil
+		})
+
+		json.NewEncoder(w).Encode(settings)
+	}
+}
+
+// leaseError writes the response for errors of lease checks
+func leaseError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case srv.ErrLeased:
+		http.Error(w, err.Error(), http.StatusLocked)
+	case srv.ErrLeaseRequired:
+		http.Error(w, err.Error(), http.StatusPreconditionRequired)
+	case srv.ErrNotFound, srv.ErrDeviceNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// leased wraps the write endpoint of a device. The caller must hold the lease of the device or of its crate.
+func (s *ApiServer) leased(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		device, err := s.ctrl.GetDeviceByName(vars["device"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := s.leases.Check(vars["device"], r.Header.Get(LeaseOwnerHeader)); err != nil {
+			log.Warning("Write request rejected: device: %s owner: %s error: %s",
+				vars["device"], r.Header.Get(LeaseOwnerHeader), err)
+			leaseError(w, err)
+			return
+		}
+		// writes during a long job would be overwritten when the job restores the setup
+		if err := device.Busy(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// leasedAll wraps endpoints changing all devices. They are rejected if any device is leased by another owner.
+func (s *ApiServer) leasedAll(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.leases.CheckAll(r.Header.Get(LeaseOwnerHeader)); err != nil {
+			log.Warning("Write request rejected: owner: %s error: %s", r.Header.Get(LeaseOwnerHeader), err)
+			leaseError(w, err)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *ApiServer) handleLeases() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling lease list request")
+		json.NewEncoder(w).Encode(s.leases.List())
+	}
+}
+
+func (s *ApiServer) handleLeaseAcquire() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := &layers.LeaseRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Owner == "" {
+			request.Owner = r.Header.Get(LeaseOwnerHeader)
+		}
+		log.Debug("Handling lease request: device: %s owner: %s ttl: %d", request.Device, request.Owner, request.TTL)
+
+		lease, err := s.leases.Acquire(request)
+		if err != nil {
+			leaseError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(lease)
+	}
+}
+
+func (s *ApiServer) handleLeaseRelease() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		request := &layers.LeaseRequest{
+			Device: vars["device"],
+			Owner:  r.Header.Get(LeaseOwnerHeader),
+		}
+		if value, ok := vars["crate"]; ok {
+			crate, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c := uint16(crate)
+			request.Crate = &c
+		}
+		log.Debug("Handling lease release request: device: %s crate: %s owner: %s", vars["device"], vars["crate"], request.Owner)
+
+		if err := s.leases.Release(request); err != nil {
+			leaseError(w, err)
+			return
+		}
+	}
+}
+
+// parseTimeRange reads from and to (RFC3339) query parameters and returns them in milliseconds
+func parseTimeRange(query url.Values) (uint64, uint64, error) {
+	var from, to uint64
+	for param, value := range map[string]*uint64{"from": &from, "to": &to} {
+		if query.Get(param) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, query.Get(param))
+		if err != nil {
+			return 0, 0, err
+		}
+		*value = uint64(t.UnixNano()) / uint64(time.Millisecond)
+	}
+	return from, to, nil
+}
+
+// parseAuditFilter reads the audit filter from query parameters
+func parseAuditFilter(r *http.Request) (*layers.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := &layers.AuditFilter{
+		Device:    query.Get("device"),
+		Owner:     query.Get("owner"),
+		Operation: query.Get("operation"),
+	}
+	var err error
+	filter.From, filter.To, err = parseTimeRange(query)
+	if err != nil {
+		return nil, err
+	}
+	if value := query.Get("register"); value != "" {
+		addr, err := strconv.ParseUint(value, 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		filter.Register = AuditRegister(uint16(addr))
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+func (s *ApiServer) handleAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Debug("Handling audit request: filter: %+v", filter)
+
+		records, err := s.audit.List(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(records)
+	}
+}
+
+func (s *ApiServer) handleIdentity() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling identity request")
+		json.NewEncoder(w).Encode(s.ctrl.GetIdentity())
+	}
+}
+
+func (s *ApiServer) handleVerifyIdentity() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling verify identity request: device: %s", vars["device"])
+
+		report, err := s.ctrl.VerifyIdentity(vars["device"])
+		if err != nil {
+			if _, ok := err.(srv.ErrDeviceNotFound); ok {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func (s *ApiServer) handleAlarms() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling alarms request")
+		json.NewEncoder(w).Encode(s.ctrl.GetAlarms())
+	}
+}
+
+func (s *ApiServer) handleAlarmAck() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log.Debug("Handling alarm acknowledge request: device: %s rule: %s", vars["device"], vars["rule"])
+
+		if err := s.ctrl.AcknowledgeAlarm(vars["device"], vars["rule"]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+}
+
+func (s *ApiServer) handleAlarmHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		from, to, err := parseTimeRange(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter := &layers.AlarmFilter{
+			From:   from,
+			To:     to,
+			Device: query.Get("device"),
+		}
+		if value := query.Get("limit"); value != "" {
+			filter.Limit, err = strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		log.Debug("Handling alarm history request: filter: %+v", filter)
+
+		json.NewEncoder(w).Encode(s.ctrl.GetAlarmHistory(filter))
+	}
+}
@@ -0,0 +1,266 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"go.etcd.io/bbolt"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	AuditBucket = "audit"
+	// AuditRequestMaxSize limits the size of the request body saved in the audit record
+	AuditRequestMaxSize = 4096
+)
+
+// AuditLog is the append-only log of write operations done through the control API.
+// Records are kept in a separate database and optionally appended to a JSON lines file.
+type AuditLog struct {
+	DB   *bbolt.DB
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewAuditLog(cfg *config.Config) (*AuditLog, error) {
+	db, err := bbolt.Open(cfg.AuditDBPath(), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(AuditBucket))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	a := &AuditLog{DB: db}
+	if cfg.AuditFile != "" {
+		a.file, err = os.OpenFile(cfg.AuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Close ...
+func (a *AuditLog) Close() {
+	a.DB.Close()
+	if a.file != nil {
+		a.file.Close()
+	}
+}
+
+func auditKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Append assigns the next sequence number to the record and saves it
+func (a *AuditLog) Append(record *layers.AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(AuditBucket))
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		record.Seq = seq
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return b.Put(auditKey(seq), data)
+	}); err != nil {
+		return err
+	}
+	if a.file != nil {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err = a.file.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns records matching the filter in chronological order.
+// If the filter limits the number of records, the latest ones are returned.
+func (a *AuditLog) List(filter *layers.AuditFilter) ([]*layers.AuditRecord, error) {
+	records := []*layers.AuditRecord{}
+	err := a.DB.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(AuditBucket)).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			record := &layers.AuditRecord{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return err
+			}
+			if !filter.Match(record) {
+				continue
+			}
+			records = append(records, record)
+			if filter.Limit > 0 && len(records) >= filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+// auditResponseWriter keeps the status and the error message written by the handler
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.status >= http.StatusBadRequest && w.body.Len() < AuditRequestMaxSize {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// audited wraps a write endpoint and records the operation in the audit log.
+// The action (start, stop, etc.) is appended to the operation name. Rejected requests are recorded as well.
+func (s *ApiServer) audited(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		operation := name
+		if action, ok := vars["action"]; ok {
+			operation = fmt.Sprintf("%s %s", name, action)
+		}
+		var request []byte
+		if r.Body != nil {
+			var err error
+			request, err = ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(request))
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		record := &layers.AuditRecord{
+			Timestamp: srv.Now(),
+			Client:    client,
+			Owner:     r.Header.Get(LeaseOwnerHeader),
+			Method:    r.Method,
+			Endpoint:  r.URL.RequestURI(),
+			Device:    vars["device"],
+			Operation: operation,
+			Action:    vars["action"],
+		}
+		if len(request) > AuditRequestMaxSize {
+			request = request[:AuditRequestMaxSize]
+		}
+		compact := &bytes.Buffer{}
+		if json.Compact(compact, request) == nil {
+			record.Request = compact.String()
+		} else {
+			record.Request = string(request)
+		}
+
+		decodeAuditRequest(record, name, vars, request)
+
+		recorder := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+
+		record.Status = recorder.status
+		record.Error = strings.TrimSpace(recorder.body.String())
+		if err := s.audit.Append(record); err != nil {
+			log.Error("Error while appending audit record: operation: %s device: %s error: %s", operation, record.Device, err)
+		}
+	}
+}
+
+// AuditRegister formats the register address as it is saved in audit records
+func AuditRegister(addr uint16) string {
+	return fmt.Sprintf("0x%04x", addr)
+}
+
+// decodeAuditRequest sets the fields of the record decoded from the request body of known operations,
+// so records can be selected by them. Requests which can not be decoded are kept only as the body.
+func decodeAuditRequest(record *layers.AuditRecord, name string, vars map[string]string, request []byte) {
+	switch name {
+	case "reg write":
+		regHex := &RegHex{}
+		if json.Unmarshal(request, regHex) != nil {
+			return
+		}
+		reg, err := layers.NewRegFromHex(regHex.Addr, regHex.Value)
+		if err != nil {
+			return
+		}
+		record.Register = AuditRegister(reg.Addr)
+		record.Value = fmt.Sprintf("0x%04x", reg.Value)
+	case "channels":
+		setup := &layers.ChannelsSetup{}
+		if json.Unmarshal(request, setup) != nil {
+			return
+		}
+		for _, ch := range setup.Channels {
+			record.Channels = append(record.Channels, ch.Id)
+		}
+	case "baseline":
+		value := &BaselineValue{}
+		if json.Unmarshal(request, value) != nil {
+			return
+		}
+		record.Channels = []int{value.Ch}
+	case "threshold scan":
+		setup := &layers.ThresholdScanSetup{}
+		if json.Unmarshal(request, setup) != nil {
+			return
+		}
+		record.Channels = setup.Channels
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"go.etcd.io/bbolt"
+	"sigs.k8s.io/yaml"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	RunsBucket = "runs"
+)
+
+// Catalog keeps records of all runs in a separate database, so it can be
+// copied or backed up independently of the device state
+type Catalog struct {
+	DB *bbolt.DB
+	// mu makes read-modify-write updates of records atomic
+	mu sync.Mutex
+}
+
+func NewCatalog(cfg *config.Config) (*Catalog, error) {
+	db, err := bbolt.Open(cfg.RunsDBPath(), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(RunsBucket))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &Catalog{DB: db}, nil
+}
+
+// Close ...
+func (c *Catalog) Close() {
+	c.DB.Close()
+}
+
+// runKey is big endian, so records are iterated in the run number order
+func runKey(number uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, number)
+	return b
+}
+
+func (c *Catalog) get(tx *bbolt.Tx, number uint32) (*layers.RunRecord, error) {
+	data := tx.Bucket([]byte(RunsBucket)).Get(runKey(number))
+	if data == nil {
+		return nil, srv.ErrNotFound{What: fmt.Sprintf("run %d", number)}
+	}
+	record := &layers.RunRecord{}
+	if err := yaml.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (c *Catalog) put(tx *bbolt.Tx, record *layers.RunRecord) error {
+	data, err := yaml.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(RunsBucket)).Put(runKey(record.Number), data)
+}
+
+// update applies the function to the record creating the record if it does not exist
+func (c *Catalog) update(number uint32, fn func(record *layers.RunRecord)) (*layers.RunRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var record *layers.RunRecord
+	err := c.DB.Update(func(tx *bbolt.Tx) error {
+		var err error
+		record, err = c.get(tx, number)
+		if err != nil {
+			if _, ok := err.(srv.ErrNotFound); !ok {
+				return err
+			}
+			record = &layers.RunRecord{}
+		}
+		fn(record)
+		return c.put(tx, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// UpdateRun saves the run keeping files and annotations of the record
+func (c *Catalog) UpdateRun(run *layers.Run) error {
+	log.Debug("Updating run catalog: number: %d state: %s", run.Number, run.State)
+	_, err := c.update(run.Number, func(record *layers.RunRecord) {
+		record.Run = *run
+	})
+	return err
+}
+
+// AddFiles saves data files of the run
+func (c *Catalog) AddFiles(number uint32, files []*layers.RunFile) error {
+	_, err := c.update(number, func(record *layers.RunRecord) {
+		record.Files = append(record.Files, files...)
+	})
+	return err
+}
+
+// Annotate adds the operator comment to the run
+func (c *Catalog) Annotate(number uint32, text string) (*layers.RunRecord, error) {
+	if _, err := c.Get(number); err != nil {
+		return nil, err
+	}
+	return c.update(number, func(record *layers.RunRecord) {
+		record.Annotations = append(record.Annotations, &layers.RunAnnotation{
+			Timestamp: srv.Now(),
+			Text:      text,
+		})
+	})
+}
+
+// Get returns the run record
+func (c *Catalog) Get(number uint32) (*layers.RunRecord, error) {
+	var record *layers.RunRecord
+	err := c.DB.View(func(tx *bbolt.Tx) error {
+		var err error
+		record, err = c.get(tx, number)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// List returns records matching the filter sorted by run number
+func (c *Catalog) List(filter *layers.RunFilter) ([]*layers.RunRecord, error) {
+	records := []*layers.RunRecord{}
+	err := c.DB.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(RunsBucket)).ForEach(func(k, v []byte) error {
+			record := &layers.RunRecord{}
+			if err := yaml.Unmarshal(v, record); err != nil {
+				return err
+			}
+			if filter.Match(record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
@@ -27,24 +29,48 @@ import (
 	pkgdevice "jinr.ru/greenlab/go-adc/pkg/device"
 	deviceifc "jinr.ru/greenlab/go-adc/pkg/device/ifc"
 	"jinr.ru/greenlab/go-adc/pkg/srv/control/ifc"
+	"jinr.ru/greenlab/go-adc/pkg/srv/mstream"
 
 	"jinr.ru/greenlab/go-adc/pkg/config"
 	"jinr.ru/greenlab/go-adc/pkg/layers"
 	"jinr.ru/greenlab/go-adc/pkg/log"
 	"jinr.ru/greenlab/go-adc/pkg/srv"
+	"jinr.ru/greenlab/go-adc/pkg/srv/discover"
 )
 
 const (
-	RegPort         = 33300
-	RegReadInterval = 30
+	RegPort = 33300
+	// ResponseTimeout is how long we wait for a device to answer a synchronous request
+	ResponseTimeout = 1 * time.Second
 )
 
+// pendingKey identifies a request which is waiting for the response
+type pendingKey struct {
+	deviceName string
+	seq        uint16
+}
+
 type ControlServer struct {
 	srv.Server
-	seq     uint16
-	state   ifc.State
-	api     ifc.ApiServer
-	devices map[string]*pkgdevice.Device
+	// seqs are request sequence numbers per device, they are incremented atomically
+	seqs      map[string]*uint32
+	state     ifc.State
+	api       ifc.ApiServer
+	devices   map[string]*pkgdevice.Device
+	pending   map[pendingKey]chan gopacket.Packet
+	pendingMu sync.Mutex
+	drift     map[string]*layers.DriftReport
+	driftMu   sync.Mutex
+	run       *layers.Run
+	runMu     sync.Mutex
+	catalog   *Catalog
+	polling   *config.Polling
+	health    *HealthMonitor
+	// identity are the latest identity verification reports by device name
+	identity   map[string]*layers.IdentityReport
+	identityMu sync.Mutex
+	// runMetadata is collected while the run is active and written next to data files
+	runMetadata *mstream.RunMetadata
 }
 
 var _ ifc.ControlServer = &ControlServer{}
@@ -58,11 +84,22 @@ func NewControlServer(ctx context.Context, cfg *config.Config) (ifc.ControlServe
 		return nil, err
 	}
 
+	if cfg.Identity != nil && cfg.Identity.ResolveIPs {
+		if err = discover.ResolveDeviceIPs(cfg); err != nil {
+			return nil, err
+		}
+	}
+
 	state, err := NewState(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	catalog, err := NewCatalog(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &ControlServer{
 		Server: srv.Server{
 			Context: ctx,
@@ -71,8 +108,13 @@ func NewControlServer(ctx context.Context, cfg *config.Config) (ifc.ControlServe
 			ChIn:    make(chan srv.InPacket),
 			ChOut:   make(chan srv.OutPacket),
 		},
-		seq:   0,
-		state: state,
+		seqs:     make(map[string]*uint32),
+		state:    state,
+		pending:  make(map[pendingKey]chan gopacket.Packet),
+		drift:    make(map[string]*layers.DriftReport),
+		catalog:  catalog,
+		polling:  cfg.GetPolling(),
+		identity: make(map[string]*layers.IdentityReport),
 	}
 
 	devices := make(map[string]*pkgdevice.Device)
@@ -81,16 +123,17 @@ func NewControlServer(ctx context.Context, cfg *config.Config) (ifc.ControlServe
 		if newdevErr != nil {
 			return nil, err
 		}
-
-		setErr := device.SetDeviceSettingsFromConfig(cfgDevice)
-		if setErr != nil {
-			return nil, setErr
-		}
-
+		device.SetFirPresets(cfg.FirPresets)
 		devices[cfgDevice.Name] = device
+		s.seqs[cfgDevice.Name] = new(uint32)
 	}
 
 	s.devices = devices
+	s.loadRun()
+	s.health, err = NewHealthMonitor(s)
+	if err != nil {
+		return nil, err
+	}
 
 	apiServer, err := NewApiServer(ctx, cfg, s)
 	if err != nil {
@@ -109,6 +152,7 @@ func (s *ControlServer) Run() error {
 
 	defer conn.Close()
 	defer s.state.Close()
+	defer s.catalog.Close()
 
 	errChan := make(chan error, 1)
 	buffer := make([]byte, 65536)
@@ -177,6 +221,7 @@ func (s *ControlServer) Run() error {
 					}
 				}
 			}
+			s.deliverResponse(deviceName, packet)
 		}
 	}()
 
@@ -198,24 +243,49 @@ func (s *ControlServer) Run() error {
 		s.api.Run()
 	}()
 
-	// Periodically read all registers from all devices
+	// Device settings can only be applied once packets are being sent and received
 	go func() {
-		for {
-			var ops []*layers.RegOp
-			for i := pkgdevice.RegAlias(0); i < pkgdevice.RegAliasLimit; i++ {
-				addr := pkgdevice.RegMap[i]
-				ops = append(ops, &layers.RegOp{Read: true, Reg: &layers.Reg{Addr: addr}})
+		for _, cfgDevice := range s.Config.Devices {
+			device := s.devices[cfgDevice.Name]
+			cfgDevice := cfgDevice
+			apply, verifyErr := s.verifyOnStart(cfgDevice.Name)
+			if verifyErr != nil {
+				errChan <- verifyErr
+				return
+			}
+			if !apply {
+				continue
 			}
-			for _, device := range s.devices {
-				regreqErr := s.RegRequest(ops, device.IP)
-				if regreqErr != nil {
-					log.Error("Error while sending reg request to device %s", device.IP)
+			loadErr := device.Do(func() error {
+				// config is applied on top of the stored settings
+				if loadErr := device.LoadSettings(); loadErr != nil {
+					// settings which can not be read are never saved again, so the server must not go on
+					if _, ok := loadErr.(pkgdevice.ErrSettingsRead); ok {
+						return loadErr
+					}
+					log.Error("Error while loading stored settings: %s", loadErr)
+				}
+				if setErr := device.SetDeviceSettingsFromConfig(cfgDevice); setErr != nil {
+					log.Error("Error while applying settings from config: device: %s error: %s", cfgDevice.Name, setErr)
 				}
+				if loadErr := device.LoadDesTaps(); loadErr != nil {
+					log.Error("Error while loading deserializer taps: device: %s error: %s", cfgDevice.Name, loadErr)
+				}
+				return nil
+			})
+			if loadErr != nil {
+				errChan <- loadErr
+				return
 			}
-			time.Sleep(RegReadInterval * time.Second)
 		}
 	}()
 
+	go s.reconcile()
+	go s.pruneRegHistory()
+
+	go s.poll()
+	go s.health.Run()
+
 	select {
 	case <-s.Context.Done():
 		return s.Context.Err()
@@ -224,20 +294,48 @@ func (s *ControlServer) Run() error {
 	}
 }
 
-// NextSeq ...
-func (s *ControlServer) NextSeq() uint16 {
-	seq := s.seq
-	s.seq++
-	return seq
+// NextSeq returns the next request sequence number for the device.
+// It is safe to call from concurrent goroutines.
+func (s *ControlServer) NextSeq(deviceName string) (uint16, error) {
+	seq, ok := s.seqs[deviceName]
+	if !ok {
+		return 0, srv.ErrDeviceNotFound{What: deviceName}
+	}
+	return uint16(atomic.AddUint32(seq, 1) - 1), nil
+}
+
+// nextSeqByIP returns the device name and the next request sequence number for the device
+func (s *ControlServer) nextSeqByIP(ip *net.IP) (string, uint16, error) {
+	deviceName, err := s.deviceNameByIP(ip)
+	if err != nil {
+		return "", 0, err
+	}
+	seq, err := s.NextSeq(deviceName)
+	if err != nil {
+		return "", 0, err
+	}
+	return deviceName, seq, nil
 }
 
 // RegRequest ...
 func (s *ControlServer) RegRequest(ops []*layers.RegOp, ip *net.IP) error {
+	_, seq, err := s.nextSeqByIP(ip)
+	if err != nil {
+		return err
+	}
+	if err = s.regRequest(ops, ip, seq); err != nil {
+		return err
+	}
+	s.readBack(ops, ip)
+	return nil
+}
+
+func (s *ControlServer) regRequest(ops []*layers.RegOp, ip *net.IP, seq uint16) error {
 	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", ip, RegPort))
 	if err != nil {
 		return err
 	}
-	bytes, err := layers.RegOpsToBytes(ops, s.NextSeq())
+	bytes, err := layers.RegOpsToBytes(ops, seq)
 	if err != nil {
 		log.Error("Error while serializing layers when sending register r/w request to %s", udpAddr)
 		return err
@@ -250,13 +348,136 @@ func (s *ControlServer) RegRequest(ops []*layers.RegOp, ip *net.IP) error {
 	return nil
 }
 
+// RegRequestSync sends register r/w request and waits for the device to respond.
+// It returns register operations from the response. For read operations
+// the response contains actual register values.
+func (s *ControlServer) RegRequestSync(ops []*layers.RegOp, ip *net.IP) ([]*layers.RegOp, error) {
+	deviceName, seq, err := s.nextSeqByIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	respCh := s.addPending(deviceName, seq)
+	defer s.removePending(deviceName, seq)
+
+	if err = s.regRequest(ops, ip, seq); err != nil {
+		return nil, err
+	}
+
+	packet, err := s.waitResponse(deviceName, seq, respCh)
+	if err != nil {
+		return nil, err
+	}
+	layer := packet.Layer(layers.RegLayerType)
+	if layer == nil {
+		return nil, srv.ErrUnexpectedResponse{Device: deviceName, Seq: seq}
+	}
+	regLayer, ok := layer.(*layers.RegLayer)
+	if !ok {
+		return nil, srv.ErrUnexpectedResponse{Device: deviceName, Seq: seq}
+	}
+	s.readBack(ops, ip)
+	return regLayer.RegOps, nil
+}
+
+func (s *ControlServer) deviceNameByIP(ip *net.IP) (string, error) {
+	device, err := s.Config.GetDeviceByIP(*ip)
+	if err != nil {
+		return "", err
+	}
+	return device.Name, nil
+}
+
+func (s *ControlServer) addPending(deviceName string, seq uint16) chan gopacket.Packet {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	ch := make(chan gopacket.Packet, 1)
+	s.pending[pendingKey{deviceName: deviceName, seq: seq}] = ch
+	return ch
+}
+
+func (s *ControlServer) removePending(deviceName string, seq uint16) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	delete(s.pending, pendingKey{deviceName: deviceName, seq: seq})
+}
+
+// deliverResponse passes the packet to the request waiting for it (if any)
+func (s *ControlServer) deliverResponse(deviceName string, packet gopacket.Packet) {
+	layer := packet.Layer(layers.MLinkLayerType)
+	if layer == nil {
+		return
+	}
+	ml, ok := layer.(*layers.MLinkLayer)
+	if !ok {
+		return
+	}
+	s.pendingMu.Lock()
+	ch, ok := s.pending[pendingKey{deviceName: deviceName, seq: ml.Seq}]
+	s.pendingMu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- packet:
+	default:
+	}
+}
+
+func (s *ControlServer) waitResponse(deviceName string, seq uint16, respCh <-chan gopacket.Packet) (gopacket.Packet, error) {
+	select {
+	case packet := <-respCh:
+		return packet, nil
+	case <-time.After(ResponseTimeout):
+		return nil, srv.ErrResponseTimeout{Device: deviceName, Seq: seq}
+	case <-s.Context.Done():
+		return nil, s.Context.Err()
+	}
+}
+
+// MemRequestSync sends memory r/w request and waits for the device to respond.
+// For read requests the returned operation contains the data read from the device memory.
+func (s *ControlServer) MemRequestSync(op *layers.MemOp, ip *net.IP) (*layers.MemOp, error) {
+	deviceName, seq, err := s.nextSeqByIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	respCh := s.addPending(deviceName, seq)
+	defer s.removePending(deviceName, seq)
+
+	if err = s.memRequest(op, ip, seq); err != nil {
+		return nil, err
+	}
+
+	packet, err := s.waitResponse(deviceName, seq, respCh)
+	if err != nil {
+		return nil, err
+	}
+	layer := packet.Layer(layers.MemLayerType)
+	if layer == nil {
+		return nil, srv.ErrUnexpectedResponse{Device: deviceName, Seq: seq}
+	}
+	memLayer, ok := layer.(*layers.MemLayer)
+	if !ok {
+		return nil, srv.ErrUnexpectedResponse{Device: deviceName, Seq: seq}
+	}
+	return memLayer.MemOp, nil
+}
+
 // MemRequest ...
 func (s *ControlServer) MemRequest(op *layers.MemOp, ip *net.IP) error {
+	_, seq, err := s.nextSeqByIP(ip)
+	if err != nil {
+		return err
+	}
+	return s.memRequest(op, ip, seq)
+}
+
+func (s *ControlServer) memRequest(op *layers.MemOp, ip *net.IP, seq uint16) error {
 	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", ip, RegPort))
 	if err != nil {
 		return err
 	}
-	bytes, err := layers.MemOpToBytes(op, s.NextSeq())
+	bytes, err := layers.MemOpToBytes(op, seq)
 	if err != nil {
 		log.Error("Error while serializing layers when sending memory r/w request to %s", udpAddr)
 		return err
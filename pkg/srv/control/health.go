@@ -0,0 +1,427 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imroc/req"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	pkgdevice "jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	// AlarmBucket is the state bucket where the alarm history is stored
+	AlarmBucket     = "alarms"
+	alarmHistoryKey = "history"
+	// AlarmNotifyTimeout limits how long the webhook and the command may take
+	AlarmNotifyTimeout = 10 * time.Second
+)
+
+// alarmRule is the configured rule with register names resolved to addresses
+type alarmRule struct {
+	*config.AlarmRule
+	addr     uint16
+	maskAddr *uint16
+	devices  map[string]bool
+	hold     uint64 // milliseconds
+}
+
+// HealthMonitor periodically checks cached register values of all devices against
+// alarm rules, keeps active alarms and their history and notifies about changes
+type HealthMonitor struct {
+	*config.Health
+	ctrl     *ControlServer
+	rules    []*alarmRule
+	mu       sync.Mutex
+	active   map[string]*layers.Alarm
+	history  []*layers.Alarm
+	counters map[string]uint16
+	started  time.Time
+}
+
+// NewHealthMonitor resolves alarm rules and returns an error if any of them is wrong,
+// so a mistake in config is not found only when the alarm is not raised
+func NewHealthMonitor(ctrl *ControlServer) (*HealthMonitor, error) {
+	h := &HealthMonitor{
+		Health:   ctrl.Config.GetHealth(),
+		ctrl:     ctrl,
+		active:   make(map[string]*layers.Alarm),
+		counters: make(map[string]uint16),
+		started:  time.Now(),
+	}
+	aliases := map[string]pkgdevice.RegAlias{}
+	for alias, name := range pkgdevice.RegNames {
+		aliases[name] = alias
+	}
+	names := map[string]bool{}
+	for _, cfgRule := range h.Rules {
+		if cfgRule.Name == "" {
+			return nil, srv.ErrAlarmRule{Rule: cfgRule.Name, Reason: "name is not set"}
+		}
+		if names[cfgRule.Name] {
+			return nil, srv.ErrAlarmRule{Rule: cfgRule.Name, Reason: "name is not unique"}
+		}
+		names[cfgRule.Name] = true
+		switch cfgRule.Condition {
+		case config.AlarmAbove, config.AlarmBelow, config.AlarmIncrease, config.AlarmBitsSet,
+			config.AlarmBitsClear, config.AlarmStale, config.AlarmNotRunning:
+		default:
+			return nil, srv.ErrAlarmRule{Rule: cfgRule.Name, Reason: fmt.Sprintf("unknown condition %q", cfgRule.Condition)}
+		}
+		switch cfgRule.Severity {
+		case config.AlarmWarning, config.AlarmError:
+		default:
+			return nil, srv.ErrAlarmRule{Rule: cfgRule.Name, Reason: fmt.Sprintf("unknown severity %q", cfgRule.Severity)}
+		}
+
+		rule := &alarmRule{AlarmRule: cfgRule, hold: config.DefaultAlarmHold * 1000}
+		if cfgRule.Hold > 0 {
+			rule.hold = uint64(cfgRule.Hold) * 1000
+		}
+		switch {
+		case cfgRule.Register != "":
+			alias, ok := aliases[cfgRule.Register]
+			if !ok {
+				return nil, srv.ErrAlarmRule{Rule: cfgRule.Name, Reason: fmt.Sprintf("unknown register %q", cfgRule.Register)}
+			}
+			rule.addr = pkgdevice.RegMap[alias]
+		case cfgRule.Condition == config.AlarmNotRunning:
+			// the running bit is always in the run status register
+			rule.addr = pkgdevice.RegMap[pkgdevice.RegRunStatus]
+		default:
+			return nil, srv.ErrAlarmRule{Rule: cfgRule.Name, Reason: "register is not set"}
+		}
+		if cfgRule.MaskRegister != "" {
+			alias, ok := aliases[cfgRule.MaskRegister]
+			if !ok {
+				return nil, srv.ErrAlarmRule{Rule: cfgRule.Name, Reason: fmt.Sprintf("unknown mask register %q", cfgRule.MaskRegister)}
+			}
+			maskAddr := pkgdevice.RegMap[alias]
+			rule.maskAddr = &maskAddr
+		}
+		if len(cfgRule.Devices) > 0 {
+			rule.devices = map[string]bool{}
+			for _, name := range cfgRule.Devices {
+				rule.devices[name] = true
+			}
+		}
+		h.rules = append(h.rules, rule)
+	}
+	h.load()
+	return h, nil
+}
+
+func alarmKey(deviceName, rule string) string {
+	return fmt.Sprintf("%s/%s", deviceName, rule)
+}
+
+// load restores the alarm history. Alarms which were not cleared become active again
+// and are cleared on the first check if the condition is no longer true.
+// Alarms of rules which are not configured anymore are cleared.
+func (h *HealthMonitor) load() {
+	var history []*layers.Alarm
+	if err := h.ctrl.state.Get(AlarmBucket, alarmHistoryKey, &history); err != nil {
+		if _, ok := err.(srv.ErrNotFound); !ok {
+			log.Error("Error while loading alarm history: %s", err)
+		}
+		return
+	}
+	h.history = history
+	rules := map[string]bool{}
+	for _, rule := range h.rules {
+		rules[rule.Name] = true
+	}
+	for _, alarm := range history {
+		if alarm.Cleared != 0 {
+			continue
+		}
+		// the rule has been removed from config since the alarm was raised
+		if !rules[alarm.Rule] {
+			alarm.Cleared = srv.Now()
+			continue
+		}
+		h.active[alarmKey(alarm.Device, alarm.Rule)] = alarm
+	}
+}
+
+func (h *HealthMonitor) save() {
+	if err := h.ctrl.state.Put(AlarmBucket, alarmHistoryKey, h.history); err != nil {
+		log.Error("Error while saving alarm history: %s", err)
+	}
+}
+
+// Run checks all devices every interval until the context is done
+func (h *HealthMonitor) Run() {
+	log.Info("Starting health monitor: interval: %ds rules: %d", h.Interval, len(h.rules))
+	ticker := time.NewTicker(time.Duration(h.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.ctrl.Context.Done():
+			return
+		case <-ticker.C:
+			h.check()
+		}
+	}
+}
+
+func (h *HealthMonitor) check() {
+	var names []string
+	for name := range h.ctrl.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	run := h.ctrl.GetRun()
+	for _, name := range names {
+		for _, rule := range h.rules {
+			if rule.devices != nil && !rule.devices[name] {
+				continue
+			}
+			violated, value, message, err := h.evaluate(rule, name, run)
+			if err != nil {
+				log.Debug("Unable to check alarm rule: device: %s rule: %s error: %s", name, rule.Name, err)
+				continue
+			}
+			h.update(name, rule, violated, value, message)
+		}
+	}
+}
+
+// evaluate returns true if the cached register values of the device violate the rule
+func (h *HealthMonitor) evaluate(rule *alarmRule, deviceName string, run *layers.Run) (bool, uint16, string, error) {
+	state := h.ctrl.state
+	if rule.Condition == config.AlarmStale {
+		// values cached before the start are not expected to be fresh
+		if time.Since(h.started) < time.Duration(rule.Value)*time.Second {
+			return false, 0, "", nil
+		}
+		times, err := state.GetRegTimes(deviceName, []uint16{rule.addr})
+		if err != nil {
+			return false, 0, "", err
+		}
+		updated, ok := times[rule.addr]
+		if !ok {
+			return true, 0, fmt.Sprintf("%s has never been read", rule.Register), nil
+		}
+		age := (srv.Now() - updated) / 1000
+		return age > uint64(rule.Value), 0, fmt.Sprintf("%s has not been read for %ds", rule.Register, age), nil
+	}
+
+	reg, err := state.GetReg(rule.addr, deviceName)
+	if err != nil {
+		return false, 0, "", err
+	}
+	value := reg.Value
+	switch rule.Condition {
+	case config.AlarmAbove:
+		return int(value) > rule.Value, value, fmt.Sprintf("%s = %d is above %d", rule.Register, value, rule.Value), nil
+	case config.AlarmBelow:
+		return int(value) < rule.Value, value, fmt.Sprintf("%s = %d is below %d", rule.Register, value, rule.Value), nil
+	case config.AlarmIncrease:
+		key := alarmKey(deviceName, rule.Name)
+		prev, ok := h.counters[key]
+		h.counters[key] = value
+		// the counter may wrap around
+		diff := value - prev
+		return ok && diff != 0, value, fmt.Sprintf("%s increased by %d", rule.Register, diff), nil
+	case config.AlarmBitsSet, config.AlarmBitsClear:
+		mask := uint16(rule.Value)
+		if rule.maskAddr != nil {
+			maskReg, err := state.GetReg(*rule.maskAddr, deviceName)
+			if err != nil {
+				return false, 0, "", err
+			}
+			mask = maskReg.Value
+		}
+		if rule.Condition == config.AlarmBitsSet {
+			return value&mask != 0, value, fmt.Sprintf("%s = 0x%04x has bits set under mask 0x%04x", rule.Register, value, mask), nil
+		}
+		return value&mask != mask, value, fmt.Sprintf("%s = 0x%04x has bits clear under mask 0x%04x", rule.Register, value, mask), nil
+	case config.AlarmNotRunning:
+		inRun := false
+		for _, name := range run.Devices {
+			inRun = inRun || name == deviceName
+		}
+		running := value&pkgdevice.RegRunStatusBitRunning != 0
+		return run.State == layers.RunRunning && inRun && !running, value, fmt.Sprintf("Device is not running during run %d", run.Number), nil
+	}
+	return false, 0, "", fmt.Errorf("Unknown alarm condition: %s", rule.Condition)
+}
+
+// update raises the alarm if the rule is violated and clears it if the rule is satisfied again.
+// Increase alarms are cleared only when they are acknowledged or the hold time has passed,
+// otherwise a counter which grows once would be reported for a single check interval.
+func (h *HealthMonitor) update(deviceName string, rule *alarmRule, violated bool, value uint16, message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := alarmKey(deviceName, rule.Name)
+	alarm, active := h.active[key]
+	increase := rule.Condition == config.AlarmIncrease
+	now := srv.Now()
+	switch {
+	case violated && !active:
+		alarm = &layers.Alarm{
+			Device:   deviceName,
+			Rule:     rule.Name,
+			Severity: rule.Severity,
+			Message:  message,
+			Value:    value,
+			Raised:   now,
+		}
+		if increase {
+			alarm.Increased = now
+		}
+		h.active[key] = alarm
+		h.history = append(h.history, alarm)
+		if len(h.history) > h.HistorySize {
+			h.history = h.history[len(h.history)-h.HistorySize:]
+		}
+		log.Info("Alarm raised: device: %s rule: %s severity: %s message: %s", deviceName, rule.Name, rule.Severity, message)
+	case violated && active:
+		if !increase {
+			return
+		}
+		// the counter has grown again, so the alarm is to be acknowledged again
+		alarm.Message = message
+		alarm.Value = value
+		alarm.Increased = now
+		alarm.Acknowledged = 0
+		log.Info("Alarm updated: device: %s rule: %s message: %s", deviceName, rule.Name, message)
+		h.save()
+		return
+	case !violated && active:
+		if increase && alarm.Acknowledged == 0 && now-alarm.Increased < rule.hold {
+			return
+		}
+		alarm.Cleared = now
+		delete(h.active, key)
+		log.Info("Alarm cleared: device: %s rule: %s", deviceName, rule.Name)
+	default:
+		return
+	}
+	h.save()
+	go h.notify(*alarm)
+}
+
+// notify sends the alarm to the webhook and passes it to the command
+func (h *HealthMonitor) notify(alarm layers.Alarm) {
+	ctx, cancel := context.WithTimeout(h.ctrl.Context, AlarmNotifyTimeout)
+	defer cancel()
+	if h.Webhook != "" {
+		if err := checkResponse(req.Post(h.Webhook, req.BodyJSON(&alarm), ctx)); err != nil {
+			log.Error("Error while sending alarm to webhook: %s", err)
+		}
+	}
+	if h.Command != "" {
+		data, err := json.Marshal(&alarm)
+		if err != nil {
+			log.Error("Error while serializing alarm: %s", err)
+			return
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("ALARM_DEVICE=%s", alarm.Device),
+			fmt.Sprintf("ALARM_RULE=%s", alarm.Rule),
+			fmt.Sprintf("ALARM_SEVERITY=%s", alarm.Severity),
+			fmt.Sprintf("ALARM_MESSAGE=%s", alarm.Message),
+			fmt.Sprintf("ALARM_CLEARED=%t", alarm.Cleared != 0),
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			log.Error("Error while executing alarm command: %s output: %s", err, output)
+		}
+	}
+}
+
+// Acknowledge marks the active alarm as acknowledged. Increase alarms are cleared on the next check
+// unless the counter grows again, other alarms are cleared when the rule is satisfied.
+func (h *HealthMonitor) Acknowledge(deviceName, rule string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := alarmKey(deviceName, rule)
+	alarm, ok := h.active[key]
+	if !ok {
+		return srv.ErrNotFound{What: fmt.Sprintf("active alarm %s", key)}
+	}
+	alarm.Acknowledged = srv.Now()
+	log.Info("Alarm acknowledged: device: %s rule: %s", deviceName, rule)
+	h.save()
+	return nil
+}
+
+// Alarms returns active alarms sorted by device and rule
+func (h *HealthMonitor) Alarms() []*layers.Alarm {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []string
+	for key := range h.active {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	alarms := []*layers.Alarm{}
+	for _, key := range keys {
+		alarm := *h.active[key]
+		alarms = append(alarms, &alarm)
+	}
+	return alarms
+}
+
+// History returns alarms matching the filter, the oldest first
+func (h *HealthMonitor) History(filter *layers.AlarmFilter) []*layers.Alarm {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	alarms := []*layers.Alarm{}
+	for i := len(h.history) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(alarms) >= filter.Limit {
+			break
+		}
+		if filter.Match(h.history[i]) {
+			alarm := *h.history[i]
+			alarms = append(alarms, &alarm)
+		}
+	}
+	for i, j := 0, len(alarms)-1; i < j; i, j = i+1, j-1 {
+		alarms[i], alarms[j] = alarms[j], alarms[i]
+	}
+	return alarms
+}
+
+// GetAlarms returns active alarms of all devices
+func (s *ControlServer) GetAlarms() []*layers.Alarm {
+	return s.health.Alarms()
+}
+
+// AcknowledgeAlarm marks the active alarm of the device as acknowledged
+func (s *ControlServer) AcknowledgeAlarm(deviceName, rule string) error {
+	return s.health.Acknowledge(deviceName, rule)
+}
+
+// GetAlarmHistory returns raised and cleared alarms matching the filter
+func (s *ControlServer) GetAlarmHistory(filter *layers.AlarmFilter) []*layers.Alarm {
+	return s.health.History(filter)
+}
@@ -0,0 +1,223 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	// RegHistoryPruneInterval is how often samples older than the retention time are removed
+	RegHistoryPruneInterval = 1 * time.Hour
+)
+
+func regHistoryBucketName(deviceName string) string {
+	return fmt.Sprintf("%s%s", RegHistoryBucketPrefix, deviceName)
+}
+
+// regSampleKey is the register address followed by the timestamp in milliseconds,
+// so that the samples of a register are sorted by time
+func regSampleKey(addr uint16, timestamp uint64) []byte {
+	key := make([]byte, 10)
+	binary.BigEndian.PutUint16(key[:2], addr)
+	binary.BigEndian.PutUint64(key[2:], timestamp)
+	return key
+}
+
+func regTimeBucketName(deviceName string) string {
+	return fmt.Sprintf("%s%s", RegTimeBucketPrefix, deviceName)
+}
+
+// setRegTime saves the time when the register has been read from the device within the transaction
+func setRegTime(tx *bbolt.Tx, deviceName string, addr uint16) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(regTimeBucketName(deviceName)))
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, srv.Now())
+	return b.Put(uint16ToByte(addr), value)
+}
+
+// GetRegTimes returns the time (milliseconds) when the registers were last read from the device.
+// Registers which have never been read are omitted.
+func (s *State) GetRegTimes(deviceName string, addrs []uint16) (map[uint16]uint64, error) {
+	times := map[uint16]uint64{}
+	if err := s.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(regTimeBucketName(deviceName)))
+		if b == nil {
+			return nil
+		}
+		for _, addr := range addrs {
+			if value := b.Get(uint16ToByte(addr)); value != nil {
+				times[addr] = binary.BigEndian.Uint64(value)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return times, nil
+}
+
+// addRegSample records the register value in the history within the transaction
+func addRegSample(tx *bbolt.Tx, deviceName string, reg *layers.Reg) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(regHistoryBucketName(deviceName)))
+	if err != nil {
+		return err
+	}
+	return b.Put(regSampleKey(reg.Addr, srv.Now()), uint16ToByte(reg.Value))
+}
+
+// GetRegHistory returns the recorded values of the register between from and to (milliseconds).
+// Zero from or to means no limit.
+func (s *State) GetRegHistory(deviceName string, addr uint16, from, to uint64) ([]*layers.RegSample, error) {
+	log.Debug("Getting register history: device: %s Addr: 0x%04x from: %d to: %d", deviceName, addr, from, to)
+	if to == 0 {
+		to = ^uint64(0)
+	}
+	samples := []*layers.RegSample{}
+	if err := s.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(regHistoryBucketName(deviceName)))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(regSampleKey(addr, from)); k != nil; k, v = c.Next() {
+			if binary.BigEndian.Uint16(k[:2]) != addr {
+				break
+			}
+			timestamp := binary.BigEndian.Uint64(k[2:])
+			if timestamp > to {
+				break
+			}
+			value := binary.BigEndian.Uint16(v)
+			samples = append(samples, &layers.RegSample{
+				Timestamp: timestamp,
+				Value:     value,
+				Min:       value,
+				Max:       value,
+				Count:     1,
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// PruneRegHistory removes the samples recorded before the given time (milliseconds) for all devices
+func (s *State) PruneRegHistory(before uint64) error {
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if !strings.HasPrefix(string(name), RegHistoryBucketPrefix) {
+				return nil
+			}
+			var expired [][]byte
+			if err := b.ForEach(func(k, v []byte) error {
+				if binary.BigEndian.Uint64(k[2:]) < before {
+					expired = append(expired, k)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			if len(expired) > 0 {
+				log.Debug("Removed expired register history samples: bucket: %s count: %d", name, len(expired))
+			}
+			return nil
+		})
+	})
+}
+
+// downsample merges samples into intervals of the given length (milliseconds)
+func downsample(samples []*layers.RegSample, step uint64) []*layers.RegSample {
+	if step == 0 {
+		return samples
+	}
+	result := []*layers.RegSample{}
+	var current *layers.RegSample
+	for _, sample := range samples {
+		start := sample.Timestamp - sample.Timestamp%step
+		if current == nil || current.Timestamp != start {
+			current = &layers.RegSample{
+				Timestamp: start,
+				Min:       sample.Min,
+				Max:       sample.Max,
+			}
+			result = append(result, current)
+		}
+		current.Value = sample.Value
+		if sample.Min < current.Min {
+			current.Min = sample.Min
+		}
+		if sample.Max > current.Max {
+			current.Max = sample.Max
+		}
+		current.Count += sample.Count
+	}
+	return result
+}
+
+// GetRegHistory returns the history of the register downsampled to intervals of step milliseconds
+func (s *ControlServer) GetRegHistory(deviceName string, addr uint16, from, to, step uint64) (*layers.RegHistory, error) {
+	if _, ok := s.devices[deviceName]; !ok {
+		return nil, srv.ErrDeviceNotFound{What: deviceName}
+	}
+	samples, err := s.state.GetRegHistory(deviceName, addr, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &layers.RegHistory{
+		Device:  deviceName,
+		Addr:    fmt.Sprintf("0x%04x", addr),
+		Step:    step,
+		Samples: downsample(samples, step),
+	}, nil
+}
+
+// pruneRegHistory periodically removes register history samples older than the retention time
+func (s *ControlServer) pruneRegHistory() {
+	retention := uint64(s.Config.GetRegHistory().Retention) * 1000
+	ticker := time.NewTicker(RegHistoryPruneInterval)
+	defer ticker.Stop()
+	for {
+		if now := srv.Now(); now > retention {
+			if err := s.state.PruneRegHistory(now - retention); err != nil {
+				log.Error("Error while removing expired register history: %s", err)
+			}
+		}
+		select {
+		case <-s.Context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+	"sort"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+	"jinr.ru/greenlab/go-adc/pkg/srv/discover"
+)
+
+// VerifyIdentity checks that the device at the configured IP has the serial number declared in config.
+// If the discover server has seen a device at the IP, its full MLDP serial is compared. Otherwise
+// the serial number register is compared with the lower 16 bits of the declared serial.
+func (s *ControlServer) VerifyIdentity(deviceName string) (*layers.IdentityReport, error) {
+	device, ok := s.devices[deviceName]
+	if !ok {
+		return nil, srv.ErrDeviceNotFound{What: deviceName}
+	}
+	report := &layers.IdentityReport{
+		Device:    deviceName,
+		IP:        device.IP.String(),
+		Expected:  device.Serial,
+		Timestamp: srv.Now(),
+	}
+	defer func() {
+		s.identityMu.Lock()
+		s.identity[deviceName] = report
+		s.identityMu.Unlock()
+	}()
+
+	serial, declared, err := device.SerialID()
+	if err != nil {
+		return nil, err
+	}
+	if !declared {
+		report.Error = "Serial is not declared in config"
+		return report, nil
+	}
+
+	discovered, err := s.discoveredDevices()
+	if err != nil {
+		log.Debug("Serial is not compared with discovered devices: device: %s error: %s", deviceName, err)
+	}
+	claims := discovered[deviceName]
+	if len(claims) > 1 {
+		report.Error = addressConflict(deviceName, claims).Error()
+		return report, nil
+	}
+	if len(claims) == 1 {
+		dd := claims[0]
+		report.Discovered = fmt.Sprintf("0x%x", dd.SerialID)
+		if !discover.MatchSerial(dd, device.Device) {
+			report.Error = srv.ErrSerialMismatch{Device: deviceName, Source: "discovery", Expected: device.Serial, Actual: report.Discovered}.Error()
+			return report, nil
+		}
+		report.Verified = true
+		return report, nil
+	}
+
+	// the register is a fallback since it holds only a part of the serial
+	var value uint16
+	err = device.Do(func() (err error) {
+		value, err = device.ReadSerialNum()
+		return err
+	})
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.Register = fmt.Sprintf("0x%04x", value)
+	if value != uint16(serial) {
+		report.Error = srv.ErrSerialMismatch{Device: deviceName, Source: "register", Expected: device.Serial, Actual: report.Register}.Error()
+		return report, nil
+	}
+	report.Verified = true
+	return report, nil
+}
+
+// GetIdentity returns the latest identity reports of all devices sorted by device name
+func (s *ControlServer) GetIdentity() []*layers.IdentityReport {
+	s.identityMu.Lock()
+	defer s.identityMu.Unlock()
+	var names []string
+	for name := range s.identity {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reports := []*layers.IdentityReport{}
+	for _, name := range names {
+		report := *s.identity[name]
+		reports = append(reports, &report)
+	}
+	return reports
+}
+
+// verifyOnStart verifies the identity of the device declared by serial number before config is applied to it.
+// It returns false if config must not be applied, and an error if the server must stop.
+func (s *ControlServer) verifyOnStart(deviceName string) (bool, error) {
+	report, err := s.VerifyIdentity(deviceName)
+	if err != nil {
+		return false, err
+	}
+	if report.Expected == "" || report.Verified {
+		return true, nil
+	}
+	log.Error("Device identity is not verified, settings are not applied: device: %s error: %s", deviceName, report.Error)
+	if s.Config.Identity != nil && s.Config.Identity.Strict {
+		return false, fmt.Errorf("Device identity is not verified: device: %s error: %s", deviceName, report.Error)
+	}
+	return false, nil
+}
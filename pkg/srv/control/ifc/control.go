@@ -27,9 +27,42 @@ type ControlServer interface {
 	// deviceName is used to get device IP from config
 	RegRequestByDeviceName(ops []*layers.RegOp, deviceName string) error
 	RegRequest(ops []*layers.RegOp, IP *net.IP) error
+	// RegRequestSync waits for the response and returns register operations from it
+	RegRequestSync(ops []*layers.RegOp, IP *net.IP) ([]*layers.RegOp, error)
 	// deviceName is used to get device IP from config
 	MemRequestByDeviceName(op *layers.MemOp, deviceName string) error
 	MemRequest(op *layers.MemOp, IP *net.IP) error
+	// MemRequestSync waits for the response and returns memory operation from it
+	MemRequestSync(op *layers.MemOp, IP *net.IP) (*layers.MemOp, error)
+
+	// CheckDrift compares the device state with config and re-applies config if apply is true
+	CheckDrift(deviceName string, apply bool) (*layers.DriftReport, error)
+	GetDrift(deviceName string) (*layers.DriftReport, error)
+
+	// GetRegHistory returns the register history downsampled to intervals of step milliseconds, 0 means raw samples
+	GetRegHistory(deviceName string, addr uint16, from, to, step uint64) (*layers.RegHistory, error)
+
+	// SyncStart arms devices to start streaming at the same White Rabbit time and waits until they are running
+	SyncStart(deviceNames []string, delay int) (*layers.SyncStartReport, error)
+
+	// Run control. Runs are configured, started and stopped on all devices at once.
+	GetRun() *layers.Run
+	ConfigureRun(setup *layers.RunSetup) (*layers.Run, error)
+	StartRun(setup *layers.RunSetup) (*layers.Run, error)
+	StopRun() (*layers.Run, error)
+	// Run catalog keeps records of all numbered runs
+	ListRuns(filter *layers.RunFilter) ([]*layers.RunRecord, error)
+	GetRunRecord(number uint32) (*layers.RunRecord, error)
+	AnnotateRun(number uint32, text string) (*layers.RunRecord, error)
+
+	// VerifyIdentity checks that the device at the configured IP has the declared serial number
+	VerifyIdentity(deviceName string) (*layers.IdentityReport, error)
+	GetIdentity() []*layers.IdentityReport
+
+	// Health monitor alarms
+	GetAlarms() []*layers.Alarm
+	GetAlarmHistory(filter *layers.AlarmFilter) []*layers.Alarm
+	AcknowledgeAlarm(deviceName, rule string) error
 
 	GetDeviceByName(deviceName string) (deviceifc.Device, error)
 	GetAllDevices() map[string]deviceifc.Device
@@ -21,6 +21,14 @@ type State interface {
 	GetReg(addr uint16, deviceName string) (*layers.Reg, error)
 	GetRegAll(deviceName string) ([]*layers.Reg, error)
 	GetRegs(deviceName string, regsToGet []uint16) ([]*layers.Reg, error)
+	// GetRegTimes returns the time (milliseconds) when the registers were last read from the device
+	GetRegTimes(deviceName string, addrs []uint16) (map[uint16]uint64, error)
+	// GetRegHistory returns recorded register values between from and to (milliseconds)
+	GetRegHistory(deviceName string, addr uint16, from, to uint64) ([]*layers.RegSample, error)
+	PruneRegHistory(before uint64) error
 	CreateBucket(name string) error
+	// Put and Get save/load arbitrary serializable objects
+	Put(bucket, key string, obj interface{}) error
+	Get(bucket, key string, obj interface{}) error
 	Close()
 }
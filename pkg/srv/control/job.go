@@ -0,0 +1,162 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long running operation started via API
+type Job struct {
+	ID       string      `json:"id"`
+	Kind     string      `json:"kind"`
+	Device   string      `json:"device"`
+	State    string      `json:"state"`
+	Progress float64     `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Started  time.Time   `json:"started"`
+	Finished *time.Time  `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// JobFunc does the job and reports progress as a fraction of work done.
+// It must stop when the context is done.
+type JobFunc func(ctx context.Context, progress func(float64)) (interface{}, error)
+
+// JobManager runs jobs and keeps their state in memory.
+// Jobs are cancelled when the context is done.
+type JobManager struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	nextID int
+	jobs   map[string]*Job
+}
+
+func NewJobManager(ctx context.Context) *JobManager {
+	return &JobManager{
+		ctx:  ctx,
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Start runs the job function in background and returns the job
+func (m *JobManager) Start(kind, device string, fn JobFunc) *Job {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.mu.Lock()
+	m.nextID++
+	job := &Job{
+		ID:      fmt.Sprintf("%d", m.nextID),
+		Kind:    kind,
+		Device:  device,
+		State:   JobRunning,
+		Started: time.Now(),
+		cancel:  cancel,
+	}
+	m.jobs[job.ID] = job
+	started := *job
+	m.mu.Unlock()
+
+	log.Info("Job started: id: %s kind: %s device: %s", job.ID, kind, device)
+	go func() {
+		defer cancel()
+		result, err := fn(ctx, func(progress float64) {
+			m.mu.Lock()
+			job.Progress = progress
+			m.mu.Unlock()
+		})
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		finished := time.Now()
+		job.Finished = &finished
+		if err != nil && ctx.Err() != nil {
+			log.Info("Job cancelled: id: %s kind: %s device: %s", job.ID, kind, device)
+			job.State = JobCancelled
+			job.Error = err.Error()
+			return
+		}
+		if err != nil {
+			log.Error("Job failed: id: %s kind: %s device: %s error: %s", job.ID, kind, device, err)
+			job.State = JobFailed
+			job.Error = err.Error()
+			return
+		}
+		log.Info("Job done: id: %s kind: %s device: %s", job.ID, kind, device)
+		job.State = JobDone
+		job.Progress = 1
+		job.Result = result
+	}()
+	return &started
+}
+
+// Get returns a copy of the job
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, srv.ErrNotFound{What: fmt.Sprintf("job %s", id)}
+	}
+	result := *job
+	return &result, nil
+}
+
+// Cancel cancels the job if it is running and returns a copy of the job.
+// The job is stopped in background, its state is changed when it is stopped.
+func (m *JobManager) Cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, srv.ErrNotFound{What: fmt.Sprintf("job %s", id)}
+	}
+	if job.State == JobRunning {
+		log.Info("Cancelling job: id: %s kind: %s device: %s", job.ID, job.Kind, job.Device)
+		job.cancel()
+	}
+	result := *job
+	return &result, nil
+}
+
+// List returns copies of all jobs without results sorted by start time
+func (m *JobManager) List() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []*Job
+	for _, job := range m.jobs {
+		j := *job
+		j.Result = nil
+		jobs = append(jobs, &j)
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Started.Before(jobs[k].Started)
+	})
+	return jobs
+}
@@ -0,0 +1,248 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	// LeaseOwnerHeader is the HTTP header identifying the caller of write endpoints
+	LeaseOwnerHeader = "X-Lease-Owner"
+	// LeaseTTLDefault is used when the lease request does not define TTL (seconds)
+	LeaseTTLDefault = 600
+	// LeaseTTLMax limits how long a lease can be held without renewal (seconds)
+	LeaseTTLMax = 24 * 3600
+)
+
+// LeaseManager keeps exclusive leases of devices and crates in memory.
+// Expired leases are released when leases are accessed.
+type LeaseManager struct {
+	*config.Config
+	mu     sync.Mutex
+	leases map[string]*layers.Lease
+}
+
+func NewLeaseManager(cfg *config.Config) *LeaseManager {
+	return &LeaseManager{
+		Config: cfg,
+		leases: make(map[string]*layers.Lease),
+	}
+}
+
+func deviceLeaseKey(device string) string {
+	return fmt.Sprintf("device %s", device)
+}
+
+func crateLeaseKey(crate uint16) string {
+	return fmt.Sprintf("crate %d", crate)
+}
+
+// expire must be called with mu held
+func (m *LeaseManager) expire() {
+	now := srv.Now()
+	for key, lease := range m.leases {
+		if lease.Expires <= now {
+			log.Info("Lease expired: %s owner: %s", key, lease.Owner)
+			delete(m.leases, key)
+		}
+	}
+}
+
+// crateDevices returns names of devices in the crate
+func (m *LeaseManager) crateDevices(crate uint16) []string {
+	var names []string
+	for _, device := range m.Devices {
+		if device.DeviceInventory != nil && device.CrateID == crate {
+			names = append(names, device.Name)
+		}
+	}
+	return names
+}
+
+// deviceCrate returns the crate of the device if it is known
+func (m *LeaseManager) deviceCrate(name string) (uint16, bool) {
+	device, err := m.GetDeviceByName(name)
+	if err != nil || device.DeviceInventory == nil {
+		return 0, false
+	}
+	return device.CrateID, true
+}
+
+// heldByOther returns the lease of the key if it is held by another owner. It must be called with mu held.
+func (m *LeaseManager) heldByOther(key, owner string) *layers.Lease {
+	if lease, ok := m.leases[key]; ok && lease.Owner != owner {
+		return lease
+	}
+	return nil
+}
+
+// Acquire creates the lease or renews it if it is already held by the same owner
+func (m *LeaseManager) Acquire(request *layers.LeaseRequest) (*layers.Lease, error) {
+	if request.Owner == "" {
+		return nil, srv.ErrUnknownOperation{What: "lease owner is not given"}
+	}
+	ttl := request.TTL
+	if ttl <= 0 {
+		ttl = LeaseTTLDefault
+	}
+	if ttl > LeaseTTLMax {
+		ttl = LeaseTTLMax
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	// the crate lease conflicts with leases of its devices and vice versa
+	var key string
+	var conflicts []string
+	switch {
+	case request.Device != "" && request.Crate == nil:
+		if _, err := m.GetDeviceByName(request.Device); err != nil {
+			return nil, srv.ErrDeviceNotFound{What: request.Device}
+		}
+		key = deviceLeaseKey(request.Device)
+		if crate, ok := m.deviceCrate(request.Device); ok {
+			conflicts = append(conflicts, crateLeaseKey(crate))
+		}
+	case request.Device == "" && request.Crate != nil:
+		devices := m.crateDevices(*request.Crate)
+		if len(devices) == 0 {
+			return nil, srv.ErrNotFound{What: crateLeaseKey(*request.Crate)}
+		}
+		key = crateLeaseKey(*request.Crate)
+		for _, device := range devices {
+			conflicts = append(conflicts, deviceLeaseKey(device))
+		}
+	default:
+		return nil, srv.ErrUnknownOperation{What: "either device or crate must be leased"}
+	}
+	for _, k := range append([]string{key}, conflicts...) {
+		if lease := m.heldByOther(k, request.Owner); lease != nil {
+			return nil, srv.ErrLeased{What: k, Owner: lease.Owner}
+		}
+	}
+
+	now := srv.Now()
+	lease, ok := m.leases[key]
+	if !ok {
+		lease = &layers.Lease{
+			Device:   request.Device,
+			Crate:    request.Crate,
+			Owner:    request.Owner,
+			Acquired: now,
+		}
+		m.leases[key] = lease
+		log.Info("Lease acquired: %s owner: %s ttl: %d", key, request.Owner, ttl)
+	}
+	lease.Expires = now + uint64(ttl)*uint64(time.Second/time.Millisecond)
+	result := *lease
+	return &result, nil
+}
+
+// Release releases the lease. Only the owner can release it.
+func (m *LeaseManager) Release(request *layers.LeaseRequest) error {
+	var key string
+	if request.Crate != nil {
+		key = crateLeaseKey(*request.Crate)
+	} else {
+		key = deviceLeaseKey(request.Device)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	lease, ok := m.leases[key]
+	if !ok {
+		return srv.ErrNotFound{What: fmt.Sprintf("lease of %s", key)}
+	}
+	if lease.Owner != request.Owner {
+		return srv.ErrLeased{What: key, Owner: lease.Owner}
+	}
+	delete(m.leases, key)
+	log.Info("Lease released: %s owner: %s", key, request.Owner)
+	return nil
+}
+
+// List returns active leases
+func (m *LeaseManager) List() []*layers.Lease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	var leases []*layers.Lease
+	for _, key := range sortedLeaseKeys(m.leases) {
+		lease := *m.leases[key]
+		leases = append(leases, &lease)
+	}
+	return leases
+}
+
+// Check returns nil if the owner holds the lease of the device or of its crate
+func (m *LeaseManager) Check(device, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	keys := []string{deviceLeaseKey(device)}
+	if crate, ok := m.deviceCrate(device); ok {
+		keys = append(keys, crateLeaseKey(crate))
+	}
+	for _, key := range keys {
+		if lease := m.heldByOther(key, owner); lease != nil {
+			return srv.ErrLeased{What: key, Owner: lease.Owner}
+		}
+	}
+	for _, key := range keys {
+		if _, ok := m.leases[key]; ok {
+			return nil
+		}
+	}
+	return srv.ErrLeaseRequired{Device: device}
+}
+
+// CheckAll returns nil if none of the devices is leased by another owner.
+// It is used by operations on all devices (start/stop, runs) which do not require leases.
+func (m *LeaseManager) CheckAll(owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	for _, key := range sortedLeaseKeys(m.leases) {
+		if lease := m.heldByOther(key, owner); lease != nil {
+			return srv.ErrLeased{What: key, Owner: lease.Owner}
+		}
+	}
+	return nil
+}
+
+func sortedLeaseKeys(leases map[string]*layers.Lease) []string {
+	var keys []string
+	for key := range leases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,135 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"net"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+
+	pkgdevice "jinr.ru/greenlab/go-adc/pkg/device"
+)
+
+const (
+	// PollTick is how often the poller checks which register groups are due
+	PollTick = 1 * time.Second
+	// PollDefaultGroup is the name of the group of registers which are not in any configured group
+	PollDefaultGroup = "default"
+)
+
+// pollGroup is a set of register addresses polled with the same interval
+type pollGroup struct {
+	name     string
+	interval time.Duration
+	addrs    []uint16
+	next     time.Time
+}
+
+// pollGroups resolves configured register names to addresses. Every address
+// belongs to the first group it is found in. Registers which are not in any
+// group are put into the default group.
+func (s *ControlServer) pollGroups() []*pollGroup {
+	aliases := map[string]pkgdevice.RegAlias{}
+	for alias, name := range pkgdevice.RegNames {
+		aliases[name] = alias
+	}
+	assigned := map[uint16]bool{}
+	var groups []*pollGroup
+	for _, cfgGroup := range s.polling.Groups {
+		group := &pollGroup{name: cfgGroup.Name, interval: time.Duration(cfgGroup.Interval) * time.Second}
+		for _, name := range cfgGroup.Registers {
+			alias, ok := aliases[name]
+			if !ok {
+				log.Error("Unknown register in poll group: group: %s register: %s", cfgGroup.Name, name)
+				continue
+			}
+			addr := pkgdevice.RegMap[alias]
+			if !assigned[addr] {
+				assigned[addr] = true
+				group.addrs = append(group.addrs, addr)
+			}
+		}
+		groups = append(groups, group)
+	}
+	group := &pollGroup{name: PollDefaultGroup, interval: time.Duration(s.polling.Interval) * time.Second}
+	for alias := pkgdevice.RegAlias(0); alias < pkgdevice.RegAliasLimit; alias++ {
+		addr := pkgdevice.RegMap[alias]
+		if !assigned[addr] {
+			assigned[addr] = true
+			group.addrs = append(group.addrs, addr)
+		}
+	}
+	return append(groups, group)
+}
+
+// poll periodically reads registers from devices. All groups are read on start,
+// then every group is read when its interval expires. Groups with zero interval are only read on start.
+func (s *ControlServer) poll() {
+	groups := s.pollGroups()
+	for _, group := range groups {
+		log.Info("Polling registers: group: %s interval: %s registers: %d", group.name, group.interval, len(group.addrs))
+	}
+	ticker := time.NewTicker(PollTick)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		var ops []*layers.RegOp
+		for _, group := range groups {
+			if group.next.IsZero() || (group.interval > 0 && !now.Before(group.next)) {
+				for _, addr := range group.addrs {
+					ops = append(ops, &layers.RegOp{Read: true, Reg: &layers.Reg{Addr: addr}})
+				}
+				group.next = now.Add(group.interval)
+			}
+		}
+		if len(ops) > 0 {
+			for _, device := range s.devices {
+				if !device.PollEnabled() {
+					continue
+				}
+				if err := s.RegRequest(ops, device.IP); err != nil {
+					log.Error("Error while sending reg request to device %s", device.IP)
+				}
+			}
+		}
+		select {
+		case <-s.Context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readBack requests written registers to be read from the device
+// so that cached values reflect what the device actually has
+func (s *ControlServer) readBack(ops []*layers.RegOp, ip *net.IP) {
+	if !s.polling.AfterWrite {
+		return
+	}
+	var reads []*layers.RegOp
+	for _, op := range ops {
+		if !op.Read {
+			reads = append(reads, &layers.RegOp{Read: true, Reg: &layers.Reg{Addr: op.Addr}})
+		}
+	}
+	if len(reads) == 0 {
+		return
+	}
+	if err := s.RegRequest(reads, ip); err != nil {
+		log.Error("Error while reading back registers from device %s: %s", ip, err)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+)
+
+// CheckDrift compares the device state with config and saves the report.
+// If apply is true and drift is found config is re-applied. Config is not applied
+// while a long job is running, since the job changes settings on purpose.
+func (s *ControlServer) CheckDrift(deviceName string, apply bool) (*layers.DriftReport, error) {
+	device, err := s.GetDeviceByName(deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the check and the re-apply are one device operation, so settings can not change in between
+	var report *layers.DriftReport
+	var checkErr error
+	err = device.Do(func() error {
+		if apply {
+			if err := device.Busy(); err != nil {
+				return err
+			}
+		}
+		report, checkErr = device.CheckDrift()
+		if checkErr != nil {
+			return nil
+		}
+		if apply && len(report.Drifts) > 0 {
+			log.Info("Re-applying config: device: %s", deviceName)
+			if err := device.SetDeviceSettingsFromConfig(device.GetConfig()); err != nil {
+				report.Error = err.Error()
+				return err
+			}
+			report.Applied = true
+		}
+		return nil
+	})
+	if checkErr != nil {
+		report = &layers.DriftReport{
+			Device:    deviceName,
+			Timestamp: uint64(time.Now().UnixNano()) / uint64(time.Millisecond),
+			Error:     checkErr.Error(),
+		}
+	}
+	for _, drift := range report.Drifts {
+		log.Warning("Drift from config: device: %s setting: %s desired: %s actual: %s",
+			deviceName, drift.Setting, drift.Desired, drift.Actual)
+	}
+
+	s.driftMu.Lock()
+	s.drift[deviceName] = report
+	s.driftMu.Unlock()
+
+	if checkErr != nil {
+		return report, checkErr
+	}
+	return report, err
+}
+
+// GetDrift returns the last drift report for the device. If there is none, the device is checked.
+func (s *ControlServer) GetDrift(deviceName string) (*layers.DriftReport, error) {
+	s.driftMu.Lock()
+	report, ok := s.drift[deviceName]
+	s.driftMu.Unlock()
+	if ok {
+		return report, nil
+	}
+	return s.CheckDrift(deviceName, false)
+}
+
+// reconcile periodically checks all devices for drift from config
+func (s *ControlServer) reconcile() {
+	cfg := s.Config.Reconcile
+	if cfg == nil || cfg.Interval <= 0 {
+		return
+	}
+	log.Info("Starting reconciler: interval: %ds auto apply: %t", cfg.Interval, cfg.AutoApply)
+	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.Context.Done():
+			return
+		case <-ticker.C:
+			for name, device := range s.devices {
+				if err := device.Busy(); err != nil {
+					log.Debug("Drift is not checked: %s", err)
+					continue
+				}
+				if _, err := s.CheckDrift(name, cfg.AutoApply); err != nil {
+					log.Error("Error while checking drift: device: %s error: %s", name, err)
+				}
+			}
+		}
+	}
+}
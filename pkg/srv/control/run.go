@@ -0,0 +1,437 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/imroc/req"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+	"jinr.ru/greenlab/go-adc/pkg/srv/discover"
+	"jinr.ru/greenlab/go-adc/pkg/srv/mstream"
+)
+
+const (
+	// RunBucket is the state bucket where the run number and the last run are stored
+	RunBucket    = "run"
+	runNumberKey = "number"
+	runLastKey   = "last"
+	// RunDrainTimeout is how long the mstream server is given to write received data when a run stops
+	RunDrainTimeout = 10 * time.Second
+)
+
+func (s *ControlServer) mstreamUrl(path string) string {
+	return fmt.Sprintf("http://%s:%d/api/%s", s.Config.IP, mstream.ApiPort, path)
+}
+
+func checkResponse(r *req.Resp, err error) error {
+	if err != nil {
+		return err
+	}
+	if r.Response().StatusCode != 200 {
+		return errors.New(r.Response().Status)
+	}
+	return nil
+}
+
+func (s *ControlServer) mstreamPersist(run *layers.Run) error {
+	persist := &mstream.Persist{
+		Dir:        run.Dir,
+		FilePrefix: run.FilePrefix,
+		RunNumber:  run.Number,
+	}
+	return checkResponse(req.Post(s.mstreamUrl("persist"), req.BodyJSON(persist)))
+}
+
+func (s *ControlServer) mstreamDrain(timeout time.Duration) error {
+	param := req.QueryParam{"timeout": int(timeout / time.Millisecond)}
+	return checkResponse(req.Post(s.mstreamUrl("drain"), param))
+}
+
+// mstreamFlush closes data files and returns their sizes and checksums
+func (s *ControlServer) mstreamFlush() ([]*layers.RunFile, error) {
+	r, err := req.Get(s.mstreamUrl("flush"))
+	if err = checkResponse(r, err); err != nil {
+		return nil, err
+	}
+	var files []*layers.RunFile
+	if err = r.ToJSON(&files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// closeRunFiles closes data files and saves them to the run catalog.
+// A run which failed before it got the number has no files and is not cataloged.
+func (s *ControlServer) closeRunFiles() error {
+	if s.run.Number == 0 {
+		return nil
+	}
+	files, err := s.mstreamFlush()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		log.Info("Run file: number: %d file: %s size: %d sha256: %s", s.run.Number, file.Path, file.Size, file.Sha256)
+	}
+	return s.catalog.AddFiles(s.run.Number, files)
+}
+
+func (s *ControlServer) mstreamMetadata(metadata *mstream.RunMetadata) error {
+	return checkResponse(req.Post(s.mstreamUrl("metadata"), req.BodyJSON(metadata)))
+}
+
+// discoveredDevices returns online descriptions of configured devices found by IP, keyed by device name.
+// Offline descriptions are skipped, e.g. of a board which has been swapped. More than one description
+// of a device means several devices claim its IP.
+func (s *ControlServer) discoveredDevices() (map[string][]*layers.DeviceDescription, error) {
+	descriptions, err := discover.GetDiscoveredDevices(s.Config)
+	if err != nil {
+		return nil, err
+	}
+	discovered := make(map[string][]*layers.DeviceDescription)
+	for _, dd := range descriptions {
+		if !dd.Online {
+			continue
+		}
+		if device, err := s.Config.GetDeviceByIP(dd.Address); err == nil {
+			discovered[device.Name] = append(discovered[device.Name], dd)
+		}
+	}
+	return discovered, nil
+}
+
+func addressConflict(deviceName string, claims []*layers.DeviceDescription) error {
+	err := srv.ErrAddressConflict{Device: deviceName, IP: claims[0].Address.String()}
+	for _, dd := range claims {
+		err.Serials = append(err.Serials, dd.SerialNumber)
+	}
+	return err
+}
+
+// newRunMetadata collects the effective config, discovered device descriptions
+// and the state of devices before the run starts
+func (s *ControlServer) newRunMetadata() *mstream.RunMetadata {
+	metadata := &mstream.RunMetadata{Config: s.Config}
+	discovered, err := s.discoveredDevices()
+	if err != nil {
+		log.Warning("Discovered devices are not saved to run metadata: %s", err)
+	}
+	for _, name := range s.run.Devices {
+		device := &mstream.RunDevice{Name: name}
+		claims := discovered[name]
+		if len(claims) == 1 {
+			device.Discovered = claims[0]
+		} else if len(claims) > 1 {
+			log.Warning("Discovered device is not saved to run metadata: %s", addressConflict(name, claims))
+		}
+		metadata.Devices = append(metadata.Devices, device)
+	}
+	s.captureRunStates(metadata, false)
+	return metadata
+}
+
+// captureRunStates reads the state of all run devices into the metadata
+func (s *ControlServer) captureRunStates(metadata *mstream.RunMetadata, stop bool) {
+	for _, device := range metadata.Devices {
+		var state *layers.RunDeviceState
+		d := s.devices[device.Name]
+		err := d.Do(func() (err error) {
+			state, err = d.RunState()
+			return err
+		})
+		if err != nil {
+			log.Error("Error while reading device state for run metadata: device: %s error: %s", device.Name, err)
+			device.Error = err.Error()
+			continue
+		}
+		if stop {
+			device.Stop = state
+		} else {
+			device.Start = state
+		}
+	}
+}
+
+// writeRunMetadata sends the metadata with the copy of the run to the mstream server.
+// Failing to write metadata does not stop the run.
+func (s *ControlServer) writeRunMetadata(run layers.Run) {
+	if s.runMetadata == nil {
+		return
+	}
+	s.runMetadata.Run = &run
+	if err := s.mstreamMetadata(s.runMetadata); err != nil {
+		log.Error("Error while writing run metadata: number: %d error: %s", run.Number, err)
+	}
+}
+
+// loadRun restores the last run from the state database. A run which was active
+// when the server went down is reported as failed since its files were never closed properly.
+func (s *ControlServer) loadRun() {
+	run := &layers.Run{}
+	if err := s.state.Get(RunBucket, runLastKey, run); err != nil {
+		if _, ok := err.(srv.ErrNotFound); !ok {
+			log.Error("Error while loading the last run: %s", err)
+		}
+		s.run = &layers.Run{State: layers.RunIdle}
+		return
+	}
+	switch run.State {
+	case layers.RunConfigured, layers.RunRunning, layers.RunStopping:
+		run.State = layers.RunError
+		run.Error = "interrupted by control server restart"
+	}
+	s.run = run
+}
+
+func (s *ControlServer) saveRun() {
+	if err := s.state.Put(RunBucket, runLastKey, s.run); err != nil {
+		log.Error("Error while saving run: number: %d error: %s", s.run.Number, err)
+	}
+}
+
+// setRunState updates the run state and saves it. runMu must be held.
+func (s *ControlServer) setRunState(state string, err error) {
+	s.run.State = state
+	if err != nil {
+		s.run.Error = err.Error()
+		log.Error("Run failed: number: %d error: %s", s.run.Number, err)
+	}
+	log.Info("Run state: number: %d state: %s", s.run.Number, state)
+	s.saveRun()
+	// runs are cataloged once they get the number
+	if s.run.Number > 0 {
+		if err := s.catalog.UpdateRun(s.run); err != nil {
+			log.Error("Error while updating run catalog: number: %d error: %s", s.run.Number, err)
+		}
+	}
+}
+
+// nextRunNumber increments the persistent run counter and returns its new value
+func (s *ControlServer) nextRunNumber() (uint32, error) {
+	var number uint32
+	if err := s.state.Get(RunBucket, runNumberKey, &number); err != nil {
+		if _, ok := err.(srv.ErrNotFound); !ok {
+			return 0, err
+		}
+	}
+	number++
+	if err := s.state.Put(RunBucket, runNumberKey, number); err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// runDevices returns devices taking part in the run. If the run has been
+// interrupted before devices were configured, all known devices are returned.
+func (s *ControlServer) runDevices() []string {
+	var names []string
+	for _, name := range s.run.Devices {
+		if _, ok := s.devices[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		return names
+	}
+	for _, cfgDevice := range s.Config.Devices {
+		names = append(names, cfgDevice.Name)
+	}
+	return names
+}
+
+// GetRun returns a copy of the current run
+func (s *ControlServer) GetRun() *layers.Run {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	run := *s.run
+	return &run
+}
+
+// ConfigureRun applies config to all devices and prepares a new run
+func (s *ControlServer) ConfigureRun(setup *layers.RunSetup) (*layers.Run, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if err := s.configureRun(setup); err != nil {
+		return nil, err
+	}
+	run := *s.run
+	return &run, nil
+}
+
+// configureRun must be called with runMu held
+func (s *ControlServer) configureRun(setup *layers.RunSetup) error {
+	switch s.run.State {
+	case layers.RunIdle, layers.RunConfigured, layers.RunStopped, layers.RunError:
+	default:
+		return srv.ErrRunState{Action: "configure", State: s.run.State}
+	}
+
+	s.run = &layers.Run{
+		State:      layers.RunIdle,
+		RunSetup:   *setup,
+		Configured: srv.Now(),
+	}
+	for _, cfgDevice := range s.Config.Devices {
+		log.Info("Configuring device for run: device: %s", cfgDevice.Name)
+		d := s.devices[cfgDevice.Name]
+		if err := d.Do(func() error { return d.SetDeviceSettingsFromConfig(cfgDevice) }); err != nil {
+			s.setRunState(layers.RunError, err)
+			return err
+		}
+		s.run.Devices = append(s.run.Devices, cfgDevice.Name)
+	}
+	s.setRunState(layers.RunConfigured, nil)
+	return nil
+}
+
+// StartRun configures devices unless it is already done, assigns the next run number,
+// opens data files named by the run number and starts streaming on all devices
+func (s *ControlServer) StartRun(setup *layers.RunSetup) (*layers.Run, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	switch s.run.State {
+	case layers.RunConfigured:
+		if setup != nil {
+			s.run.RunSetup = *setup
+		}
+	case layers.RunIdle, layers.RunStopped, layers.RunError:
+		if setup == nil {
+			setup = &layers.RunSetup{}
+		}
+		if err := s.configureRun(setup); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, srv.ErrRunState{Action: "start", State: s.run.State}
+	}
+
+	number, err := s.nextRunNumber()
+	if err != nil {
+		s.setRunState(layers.RunError, err)
+		return nil, err
+	}
+	s.run.Number = number
+	log.Info("Starting run: number: %d type: %s comment: %s", number, s.run.Type, s.run.Comment)
+
+	if err = s.mstreamPersist(s.run); err != nil {
+		s.setRunState(layers.RunError, err)
+		return nil, err
+	}
+	s.runMetadata = s.newRunMetadata()
+
+	report, err := s.SyncStart(s.run.Devices, 0)
+	s.run.Started = srv.Now()
+	if s.runMetadata != nil {
+		s.runMetadata.SyncStart = report
+	}
+	if err != nil {
+		// devices may be armed or running even if the start failed, stop all of them so the files are consistent
+		for _, name := range s.run.Devices {
+			if stopErr := s.devices[name].Do(s.devices[name].MStreamStop); stopErr != nil {
+				log.Error("Error while stopping device: device: %s error: %s", name, stopErr)
+			}
+		}
+		if flushErr := s.closeRunFiles(); flushErr != nil {
+			log.Error("Error while closing run files: %s", flushErr)
+		}
+		s.run.Stopped = srv.Now()
+		s.runMetadata = nil
+		s.setRunState(layers.RunError, err)
+		return nil, err
+	}
+	s.run.StartTaiSec = report.TaiSec
+	s.setRunState(layers.RunRunning, nil)
+	s.writeRunMetadata(*s.run)
+	run := *s.run
+	return &run, nil
+}
+
+// StopRun stops streaming on all devices, waits until the mstream server writes
+// all the received data and closes data files. A run in the error state can be stopped
+// to make sure devices are stopped and files are closed.
+func (s *ControlServer) StopRun() (*layers.Run, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	switch s.run.State {
+	case layers.RunRunning, layers.RunError:
+	default:
+		return nil, srv.ErrRunState{Action: "stop", State: s.run.State}
+	}
+	log.Info("Stopping run: number: %d", s.run.Number)
+	s.setRunState(layers.RunStopping, nil)
+
+	var runErr error
+	for _, name := range s.runDevices() {
+		if err := s.devices[name].Do(s.devices[name].MStreamStop); err != nil {
+			log.Error("Error while stopping device: device: %s error: %s", name, err)
+			runErr = err
+		}
+	}
+	if s.runMetadata != nil {
+		s.captureRunStates(s.runMetadata, true)
+	}
+	if err := s.mstreamDrain(RunDrainTimeout); err != nil {
+		log.Error("Error while draining mstream pipeline: %s", err)
+		runErr = err
+	}
+	s.run.Stopped = srv.Now()
+
+	// metadata is written while files are still open, so the expected final state is saved
+	final := *s.run
+	final.State = layers.RunStopped
+	if runErr != nil {
+		final.State = layers.RunError
+		final.Error = runErr.Error()
+	}
+	s.writeRunMetadata(final)
+	s.runMetadata = nil
+
+	if err := s.closeRunFiles(); err != nil {
+		log.Error("Error while closing run files: %s", err)
+		runErr = err
+	}
+
+	if runErr != nil {
+		s.setRunState(layers.RunError, runErr)
+		return nil, runErr
+	}
+	s.run.Error = ""
+	s.setRunState(layers.RunStopped, nil)
+	run := *s.run
+	return &run, nil
+}
+
+// ListRuns returns catalog records of runs matching the filter
+func (s *ControlServer) ListRuns(filter *layers.RunFilter) ([]*layers.RunRecord, error) {
+	return s.catalog.List(filter)
+}
+
+// GetRunRecord returns the catalog record of the run
+func (s *ControlServer) GetRunRecord(number uint32) (*layers.RunRecord, error) {
+	return s.catalog.Get(number)
+}
+
+// AnnotateRun adds the comment to the catalog record of the run
+func (s *ControlServer) AnnotateRun(number uint32, text string) (*layers.RunRecord, error) {
+	return s.catalog.Annotate(number, text)
+}
@@ -24,19 +24,24 @@ import (
 	"jinr.ru/greenlab/go-adc/pkg/srv/control/ifc"
 
 	"go.etcd.io/bbolt"
+	"sigs.k8s.io/yaml"
 
 	"jinr.ru/greenlab/go-adc/pkg/config"
 	pkgdevice "jinr.ru/greenlab/go-adc/pkg/device"
 	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
 )
 
 const (
-	RegBucketPrefix = "reg_"
+	RegBucketPrefix        = "reg_"
+	RegHistoryBucketPrefix = "reghist_"
+	RegTimeBucketPrefix    = "regtime_"
 )
 
 type State struct {
 	context.Context
-	DB *bbolt.DB
+	DB      *bbolt.DB
+	history *config.RegHistory
 }
 
 var _ ifc.State = &State{}
@@ -62,6 +67,7 @@ func NewState(ctx context.Context, cfg *config.Config) (ifc.State, error) {
 	return &State{
 		Context: ctx,
 		DB:      db,
+		history: cfg.GetRegHistory(),
 	}, nil
 }
 
@@ -102,9 +108,16 @@ func (s *State) SetReg(reg *layers.Reg, deviceName string) error {
 		if b == nil {
 			return errors.New(fmt.Sprintf("Bucket not found: %s", regBucketName(deviceName)))
 		}
+		prev := b.Get(uint16ToByte(reg.Addr))
 		if err := b.Put(uint16ToByte(reg.Addr), uint16ToByte(reg.Value)); err != nil {
 			return err
 		}
+		if err := setRegTime(tx, deviceName, reg.Addr); err != nil {
+			return err
+		}
+		if prev == nil || binary.BigEndian.Uint16(prev) != reg.Value || s.history.RecordPolls {
+			return addRegSample(tx, deviceName, reg)
+		}
 		return nil
 	}); err != nil {
 		return err
@@ -181,3 +194,42 @@ func (s *State) GetRegs(deviceName string, regsToGet []uint16) ([]*layers.Reg, e
 	}
 	return regs, nil
 }
+
+// Put serializes the object and saves it to the bucket using the given key.
+// The bucket is created if it does not exist.
+func (s *State) Put(bucket, key string, obj interface{}) error {
+	log.Debug("Putting object: bucket: %s key: %s", bucket, key)
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if err := s.DB.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Get reads the object saved with the given key from the bucket and deserializes it
+func (s *State) Get(bucket, key string, obj interface{}) error {
+	log.Debug("Getting object: bucket: %s key: %s", bucket, key)
+	if err := s.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return srv.ErrNotFound{What: fmt.Sprintf("bucket %s", bucket)}
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return srv.ErrNotFound{What: fmt.Sprintf("key %s in bucket %s", key, bucket)}
+		}
+		return yaml.Unmarshal(data, obj)
+	}); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+const (
+	// SyncStartDelay is how many seconds ahead of the latest device time the start is scheduled.
+	// It must be enough to arm all devices.
+	SyncStartDelay = 2
+	// SyncStartTimeout is how long devices are polled after the start time
+	SyncStartTimeout = 3 * time.Second
+	// SyncStartPollInterval is how often devices are polled while waiting for them to start
+	SyncStartPollInterval = 100 * time.Millisecond
+)
+
+// SyncStart arms all given devices to start streaming at the same White Rabbit time
+// delay seconds ahead of the latest device time, then waits until all of them are running.
+// Devices are armed and reported in the given order. If some of the devices do not
+// support the timed start, all of them are started one by one instead.
+func (s *ControlServer) SyncStart(deviceNames []string, delay int) (*layers.SyncStartReport, error) {
+	if delay <= 0 {
+		delay = SyncStartDelay
+	}
+	for _, name := range deviceNames {
+		device, err := s.GetDeviceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !device.HasTimedStart() {
+			log.Warning("Timed start is not enabled, devices are started one by one: device: %s", name)
+			return s.startOneByOne(deviceNames)
+		}
+	}
+
+	var taiSec uint32
+	for _, name := range deviceNames {
+		device, err := s.GetDeviceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		var sec uint32
+		err = device.Do(func() (err error) {
+			sec, err = device.TaiSec()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sec > taiSec {
+			taiSec = sec
+		}
+	}
+	report := &layers.SyncStartReport{TaiSec: taiSec + uint32(delay)}
+	log.Info("Synchronised start: devices: %v tai: %d", deviceNames, report.TaiSec)
+
+	for _, name := range deviceNames {
+		result := &layers.SyncStartDevice{Device: name}
+		report.Devices = append(report.Devices, result)
+		device := s.devices[name]
+		if err := device.Do(func() error { return device.ArmStart(report.TaiSec) }); err != nil {
+			log.Error("Error while arming device: device: %s error: %s", name, err)
+			result.Error = err.Error()
+		}
+	}
+
+	// the host clock is only used to know when to give up
+	deadline := time.Now().Add(time.Duration(delay)*time.Second + SyncStartTimeout)
+	for {
+		waiting := false
+		for _, result := range report.Devices {
+			if result.Running || result.Error != "" {
+				continue
+			}
+			var running bool
+			var sec uint32
+			device := s.devices[result.Device]
+			err := device.Do(func() (err error) {
+				running, sec, err = device.ReadRunStatus()
+				return err
+			})
+			if err != nil {
+				log.Warning("Error while reading run status: device: %s error: %s", result.Device, err)
+				waiting = true
+				continue
+			}
+			if running {
+				result.Running = true
+				result.SeenRunningTaiSec = sec
+				if sec < report.TaiSec {
+					// the firmware ignores the timed start bit and has started right away
+					result.Error = fmt.Sprintf("started before the start time at %d, timed start is not supported by firmware", sec)
+					log.Error("Device started before the start time: device: %s tai: %d", result.Device, sec)
+					continue
+				}
+				log.Info("Device is running: device: %s tai: %d", result.Device, sec)
+				continue
+			}
+			waiting = true
+		}
+		if !waiting || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(SyncStartPollInterval)
+	}
+
+	return report, syncStartError(report)
+}
+
+// startOneByOne starts devices right away in the given order
+func (s *ControlServer) startOneByOne(deviceNames []string) (*layers.SyncStartReport, error) {
+	report := &layers.SyncStartReport{}
+	for _, name := range deviceNames {
+		result := &layers.SyncStartDevice{Device: name}
+		report.Devices = append(report.Devices, result)
+		device := s.devices[name]
+		if err := device.Do(device.MStreamStart); err != nil {
+			log.Error("Error while starting device: device: %s error: %s", name, err)
+			result.Error = err.Error()
+			continue
+		}
+		result.Running = true
+	}
+	return report, syncStartError(report)
+}
+
+// syncStartError returns the error if some devices have not started
+func syncStartError(report *layers.SyncStartReport) error {
+	if failed := report.Failed(); len(failed) > 0 {
+		for _, result := range report.Devices {
+			if !result.Running && result.Error == "" {
+				result.Error = "not running after the start time"
+			}
+		}
+		return srv.ErrSyncStart{Devices: failed}
+	}
+	return nil
+}
@@ -46,11 +46,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 
 	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
 	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
 )
 
 const (
@@ -116,18 +119,228 @@ func (s *ApiServer) configureRouter() {
 	//     "$ref": "#/responses/okResp"
 	//   "400":
 	//     "$ref": "#/responses/badReq"
+	// parameters:
+	// - name: online
+	//   in: query
+	//   description: true to list only online devices, false to list only offline ones
+	//   type: boolean
+	// - name: ip
+	//   in: query
+	//   description: master IP or source address of the device
+	//   type: string
+	// - name: firmware
+	//   in: query
+	//   description: firmware revision
+	//   type: string
+	// - name: hardware
+	//   in: query
+	//   description: hardware revision
+	//   type: string
+	// - name: slot
+	//   in: query
+	//   description: chassis slot
+	//   type: integer
 	subRouter.HandleFunc("/devices", s.handleDevices()).Methods("GET")
+	// swagger:operation GET /devices/{serial} devices getDevice
+	// ---
+	// summary: Return the description of the discovered device
+	// parameters:
+	// - name: serial
+	//   in: path
+	//   description: device serial number
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/devices/{serial}", s.handleDevice()).Methods("GET")
+	// swagger:operation GET /devices/{serial}/history devices getDeviceHistory
+	// ---
+	// summary: Return appear, disappear and change events of the discovered device, the oldest first
+	// parameters:
+	// - name: serial
+	//   in: path
+	//   description: device serial number
+	//   type: string
+	//   required: true
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/devices/{serial}/history", s.handleDeviceHistory()).Methods("GET")
+	// swagger:operation GET /events events getEvents
+	// ---
+	// summary: Stream device events as server-sent events
+	// description: Every event is sent as "event: <appeared|disappeared|changed>" followed by the JSON data line.
+	// produces:
+	// - text/event-stream
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	subRouter.HandleFunc("/events", s.handleEvents()).Methods("GET")
+	// swagger:operation GET /check check getCheck
+	// ---
+	// summary: Check discovered devices for duplicate IP and MAC addresses, duplicate chassis slots and revisions not allowed in config
+	// parameters:
+	// - name: offline
+	//   in: query
+	//   description: check offline devices too
+	//   type: boolean
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	subRouter.HandleFunc("/check", s.handleCheck()).Methods("GET")
 	s.Router.PathPrefix("/swagger/").Handler(http.StripPrefix("/swagger/", http.FileServer(http.Dir("./swaggerui/"))))
 }
 
 func (s *ApiServer) handleDevices() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Debug("Handling devices request")
+		filter, err := parseDeviceFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		devices, err := s.discover.state.GetAllDeviceDescriptions()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		filtered := []*layers.DeviceDescription{}
+		for _, dd := range devices {
+			if filter.Match(dd) {
+				filtered = append(filtered, dd)
+			}
+		}
+		json.NewEncoder(w).Encode(filtered)
+	}
+}
+
+func (s *ApiServer) handleDevice() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serial := mux.Vars(r)["serial"]
+		log.Debug("Handling device request: device: %s", serial)
+		dd, err := s.discover.state.GetDeviceDescription(serial)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(dd)
+	}
+}
+
+func (s *ApiServer) handleDeviceHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serial := mux.Vars(r)["serial"]
+		log.Debug("Handling device history request: device: %s", serial)
+		history, err := s.discover.state.GetDeviceHistory(serial)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+func (s *ApiServer) handleEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling events request: remote: %s", r.RemoteAddr)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		events := s.discover.Subscribe()
+		defer s.discover.Unsubscribe(events)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.Context.Done():
+				return
+			case event := <-events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Error("Error while encoding device event: %s", err)
+					continue
+				}
+				if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (s *ApiServer) handleCheck() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling check request")
+		offline := false
+		if value := r.URL.Query().Get("offline"); value != "" {
+			var err error
+			if offline, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		devices, err := s.discover.state.GetAllDeviceDescriptions()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
 		}
-		json.NewEncoder(w).Encode(devices)
+		var checked []*layers.DeviceDescription
+		for _, dd := range devices {
+			if offline || dd.Online {
+				checked = append(checked, dd)
+			}
+		}
+		json.NewEncoder(w).Encode(Check(checked, s.Config.GetCompliance(), Crates(s.Config.Devices)))
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case srv.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func parseDeviceFilter(r *http.Request) (*layers.DeviceFilter, error) {
+	query := r.URL.Query()
+	filter := &layers.DeviceFilter{
+		IP:       query.Get("ip"),
+		Firmware: query.Get("firmware"),
+		Hardware: query.Get("hardware"),
+	}
+	if value := query.Get("online"); value != "" {
+		online, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.Online = &online
+	}
+	if value := query.Get("slot"); value != "" {
+		slot, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		s := uint16(slot)
+		filter.Slot = &s
 	}
+	return filter, nil
 }
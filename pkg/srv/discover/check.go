@@ -0,0 +1,160 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package discover
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+// Check looks for devices sharing an IP address, a MAC address or a chassis slot
+// and for devices with firmware or hardware revisions not allowed by compliance.
+// MLDP does not report the crate, so crates maps serial IDs to crates and slots
+// are compared only for devices in known crates. Unspecified IPs and slot 0 mean
+// the device has not been configured and are not compared.
+func Check(devices []*layers.DeviceDescription, compliance *config.Compliance, crates map[uint64]uint16) *layers.DiscoverReport {
+	report := &layers.DiscoverReport{
+		Timestamp: srv.Now(),
+		Devices:   len(devices),
+		Issues:    []*layers.DiscoverIssue{},
+	}
+	ips := map[string][]string{}
+	macs := map[string][]string{}
+	slots := map[string][]string{}
+	for _, dd := range devices {
+		for _, ip := range uniq(checkedIP(dd.MasterIP), checkedIP(dd.MStreamIP)) {
+			ips[ip] = append(ips[ip], dd.SerialNumber)
+		}
+		for _, mac := range uniq(dd.MasterMac.String(), dd.MStreamMac.String()) {
+			macs[mac] = append(macs[mac], dd.SerialNumber)
+		}
+		crate, ok := crates[dd.SerialID]
+		if !ok || dd.ChassisSlot == 0 {
+			continue
+		}
+		slot := fmt.Sprintf("%d/%d", crate, dd.ChassisSlot)
+		slots[slot] = append(slots[slot], dd.SerialNumber)
+	}
+	report.Issues = append(report.Issues, duplicates(layers.IssueDuplicateIP, "IP address", ips)...)
+	report.Issues = append(report.Issues, duplicates(layers.IssueDuplicateMac, "MAC address", macs)...)
+	report.Issues = append(report.Issues, duplicates(layers.IssueDuplicateSlot, "crate/slot", slots)...)
+
+	for _, dd := range devices {
+		if !allowed(compliance.Firmware, dd.FirmwareRevision) {
+			report.Issues = append(report.Issues, &layers.DiscoverIssue{
+				Kind:    layers.IssueFirmware,
+				Value:   dd.FirmwareRevision,
+				Serials: []string{dd.SerialNumber},
+				Message: fmt.Sprintf("firmware revision %q is not allowed: %s", dd.FirmwareRevision, strings.Join(compliance.Firmware, ", ")),
+			})
+		}
+		if !allowed(compliance.Hardware, dd.HardwareRevision) {
+			report.Issues = append(report.Issues, &layers.DiscoverIssue{
+				Kind:    layers.IssueHardware,
+				Value:   dd.HardwareRevision,
+				Serials: []string{dd.SerialNumber},
+				Message: fmt.Sprintf("hardware revision %q is not allowed: %s", dd.HardwareRevision, strings.Join(compliance.Hardware, ", ")),
+			})
+		}
+	}
+	return report
+}
+
+// Crates returns crates of configured devices by serial ID. Devices without a serial are skipped.
+func Crates(devices []*config.Device) map[uint64]uint16 {
+	crates := map[uint64]uint16{}
+	for _, device := range devices {
+		serial, ok, err := device.SerialID()
+		if err != nil {
+			log.Error("Unable to get crate of device: %s", err)
+			continue
+		}
+		if !ok || device.DeviceInventory == nil {
+			continue
+		}
+		crates[serial] = device.DeviceInventory.CrateID
+	}
+	return crates
+}
+
+// checkedIP returns the IP as a string or an empty string if it is not to be compared
+func checkedIP(ip net.IP) string {
+	if ip.IsUnspecified() {
+		return ""
+	}
+	return ipString(ip)
+}
+
+// duplicates returns issues for values claimed by more than one device, sorted by value
+func duplicates(kind, what string, claims map[string][]string) []*layers.DiscoverIssue {
+	var issues []*layers.DiscoverIssue
+	for value, serials := range claims {
+		if len(serials) < 2 {
+			continue
+		}
+		sort.Strings(serials)
+		issues = append(issues, &layers.DiscoverIssue{
+			Kind:    kind,
+			Value:   value,
+			Serials: serials,
+			Message: fmt.Sprintf("%s %s is used by %d devices: %s", what, value, len(serials), strings.Join(serials, ", ")),
+		})
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].Value < issues[j].Value
+	})
+	return issues
+}
+
+// uniq returns non-empty distinct values, so a device using the same address for
+// the master and MStream interfaces does not conflict with itself
+func uniq(values ...string) []string {
+	var result []string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		found := false
+		for _, r := range result {
+			if r == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func allowed(revisions []string, revision string) bool {
+	if len(revisions) == 0 {
+		return true
+	}
+	for _, r := range revisions {
+		if r == revision {
+			return true
+		}
+	}
+	return false
+}
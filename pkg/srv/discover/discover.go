@@ -18,12 +18,15 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"jinr.ru/greenlab/go-adc/pkg/config"
 
 	"github.com/google/gopacket"
 	gopacketlayers "github.com/google/gopacket/layers"
+	"golang.org/x/net/ipv4"
 
 	"jinr.ru/greenlab/go-adc/pkg/layers"
 	"jinr.ru/greenlab/go-adc/pkg/log"
@@ -44,18 +47,29 @@ const (
 
 type DiscoverServer struct {
 	srv.Server
-	*net.Interface
-	state *State
-	api   *ApiServer
+	Interfaces []*net.Interface
+	state      *State
+	api        *ApiServer
+
+	// subscribers receive device events, e.g. for the event stream API
+	subscribers map[chan *layers.DeviceEvent]struct{}
+	subMutex    sync.Mutex
 }
 
 func NewDiscoverServer(ctx context.Context, cfg *config.Config) (*DiscoverServer, error) {
-	log.Info("Initializing discover server with address: %s port: %d iface: %s",
-		cfg.DiscoverIP, DiscoverPort, cfg.DiscoverIface)
+	log.Info("Initializing discover server with address: %s port: %d ifaces: %s",
+		cfg.DiscoverIP, DiscoverPort, strings.Join(cfg.GetDiscoverIfaces(), ","))
 
-	iface, err := net.InterfaceByName(cfg.DiscoverIface)
-	if err != nil {
-		return nil, err
+	if len(cfg.GetDiscoverIfaces()) == 0 {
+		return nil, fmt.Errorf("No interfaces to listen on for discovery, discoverIface or discoverIfaces must be set in config")
+	}
+	var ifaces []*net.Interface
+	for _, name := range cfg.GetDiscoverIfaces() {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		ifaces = append(ifaces, iface)
 	}
 
 	uaddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", cfg.DiscoverIP, DiscoverPort))
@@ -75,8 +89,9 @@ func NewDiscoverServer(ctx context.Context, cfg *config.Config) (*DiscoverServer
 			ChIn:    make(chan srv.InPacket),
 			Config:  cfg,
 		},
-		Interface: iface,
-		state:     state,
+		Interfaces:  ifaces,
+		state:       state,
+		subscribers: make(map[chan *layers.DeviceEvent]struct{}),
 	}
 
 	apiServer, err := NewApiServer(ctx, cfg, s)
@@ -89,72 +104,71 @@ func NewDiscoverServer(ctx context.Context, cfg *config.Config) (*DiscoverServer
 }
 
 func (s *DiscoverServer) Run() error {
-	conn, err := net.ListenMulticastUDP("udp", s.Interface, s.UDPAddr)
+	errChan := make(chan error, 1)
+
+	// A single socket joins the group on every interface, so a frame is received once
+	// even if interfaces share the network, and the control message tells the interface.
+	conn, err := net.ListenPacket("udp4", s.UDPAddr.String())
 	if err != nil {
 		return err
 	}
-
 	defer conn.Close()
-
-	errChan := make(chan error, 1)
-	buffer := make([]byte, 2048)
+	pconn := ipv4.NewPacketConn(conn)
+	for _, iface := range s.Interfaces {
+		if err := pconn.JoinGroup(iface, &net.UDPAddr{IP: s.UDPAddr.IP}); err != nil {
+			return fmt.Errorf("Unable to join multicast group: iface: %s error: %s", iface.Name, err)
+		}
+	}
+	if err := pconn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return err
+	}
 
 	// Read UDP packets from wire and put them to input queue
+	go s.capture(pconn, errChan)
+
+	// Read captured packets from input queue, parse them and update the discover database
 	go func() {
-		for {
-			length, addr, captureErr := conn.ReadFrom(buffer)
-			if captureErr != nil {
-				errChan <- captureErr
-				return
+		source := gopacket.NewPacketSource(s, gopacketlayers.LayerTypeLinkLayerDiscovery)
+		for packet := range source.Packets() {
+			dd := DecodeDescription(packet)
+			if dd == nil {
+				continue
 			}
-
-			udpAddr, readErr := net.ResolveUDPAddr("udp", addr.String())
-			if readErr != nil {
-				errChan <- readErr
-				return
+			udpAddr, handleErr := srv.GetAddrPort(packet)
+			if handleErr != nil {
+				// TODO
+				continue
 			}
+			dd.SetSource(udpAddr)
+			dd.SetTimestamp()
 
-			captureInfo := gopacket.CaptureInfo{
-				Length:         length,
-				CaptureLength:  length,
-				InterfaceIndex: s.Interface.Index,
-				Timestamp:      time.Now(),
-				AncillaryData:  []interface{}{udpAddr},
+			event, updateErr := s.state.UpdateDeviceDescription(dd)
+			if updateErr != nil {
+				log.Error("Error while updating device description: device: %s error: %s", dd.SerialNumber, updateErr)
+				continue
+			}
+			if event != nil {
+				s.publish(event)
 			}
-			packet := srv.InPacket{CaptureInfo: captureInfo, Data: make([]byte, length)}
-			copy(packet.Data, buffer[:length])
-			s.ChIn <- packet
 		}
 	}()
 
-	// Read captured packets from input queue, parse them and update the discover database
+	// Mark devices which have gone silent as offline
 	go func() {
-		source := gopacket.NewPacketSource(s, gopacketlayers.LayerTypeLinkLayerDiscovery)
-		for packet := range source.Packets() {
-			layer := packet.Layer(gopacketlayers.LayerTypeLinkLayerDiscoveryInfo)
-			if layer != nil {
-				layer, ok := layer.(*gopacketlayers.LinkLayerDiscoveryInfo)
-				if !ok {
-					log.Error("Error while asserting to LinkLayerDiscoveryInfo")
-					continue
-				}
-				dd := &layers.DeviceDescription{}
-				layers.DecodeOrgSpecific(layer.OrgTLVs, dd)
-				udpAddr, handleErr := srv.GetAddrPort(packet)
-				if handleErr != nil {
-					// TODO
-					continue
-				}
-				dd.SetSource(udpAddr)
-				dd.SetTimestamp()
-
-				if createBucketErr := s.state.CreateBucket(BucketName(dd.SerialNumber)); createBucketErr != nil {
-					log.Error("Error while creating bucket: device: %s", dd.SerialNumber)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.Context.Done():
+				return
+			case <-ticker.C:
+				events, markErr := s.state.MarkOffline()
+				if markErr != nil {
+					log.Error("Error while marking devices offline: %s", markErr)
 					continue
 				}
-				if setdevErr := s.state.SetDeviceDescription(dd); setdevErr != nil {
-					log.Error("Error while updating device description: device: %s error: %s", dd.SerialNumber, err)
-					continue
+				for _, event := range events {
+					s.publish(event)
 				}
 			}
 		}
@@ -167,7 +181,95 @@ func (s *DiscoverServer) Run() error {
 	select {
 	case <-s.Context.Done():
 		return s.Context.Err()
-	case err = <-errChan:
+	case err := <-errChan:
 		return err
 	}
 }
+
+// capture reads UDP packets received on the discover interfaces and puts them to input queue.
+// Packets which came through other interfaces are skipped.
+func (s *DiscoverServer) capture(conn *ipv4.PacketConn, errChan chan<- error) {
+	indexes := map[int]bool{}
+	for _, iface := range s.Interfaces {
+		indexes[iface.Index] = true
+	}
+	buffer := make([]byte, 2048)
+	for {
+		length, cm, addr, captureErr := conn.ReadFrom(buffer)
+		if captureErr != nil {
+			errChan <- captureErr
+			return
+		}
+		ifIndex := 0
+		if cm != nil {
+			if !indexes[cm.IfIndex] {
+				continue
+			}
+			ifIndex = cm.IfIndex
+		}
+
+		udpAddr, readErr := net.ResolveUDPAddr("udp", addr.String())
+		if readErr != nil {
+			errChan <- readErr
+			return
+		}
+
+		captureInfo := gopacket.CaptureInfo{
+			Length:         length,
+			CaptureLength:  length,
+			InterfaceIndex: ifIndex,
+			Timestamp:      time.Now(),
+			AncillaryData:  []interface{}{udpAddr},
+		}
+		packet := srv.InPacket{CaptureInfo: captureInfo, Data: make([]byte, length)}
+		copy(packet.Data, buffer[:length])
+		s.ChIn <- packet
+	}
+}
+
+// DecodeDescription returns the device description from the LLDP layer of the packet
+// or nil if the packet does not contain it. The source and the timestamp are not set.
+func DecodeDescription(packet gopacket.Packet) *layers.DeviceDescription {
+	layer := packet.Layer(gopacketlayers.LayerTypeLinkLayerDiscoveryInfo)
+	if layer == nil {
+		return nil
+	}
+	info, ok := layer.(*gopacketlayers.LinkLayerDiscoveryInfo)
+	if !ok {
+		log.Error("Error while asserting to LinkLayerDiscoveryInfo")
+		return nil
+	}
+	dd := &layers.DeviceDescription{}
+	layers.DecodeOrgSpecific(info.OrgTLVs, dd)
+	return dd
+}
+
+// Subscribe returns the channel which receives device events until Unsubscribe is called
+func (s *DiscoverServer) Subscribe() chan *layers.DeviceEvent {
+	ch := make(chan *layers.DeviceEvent, 16)
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	s.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (s *DiscoverServer) Unsubscribe(ch chan *layers.DeviceEvent) {
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	delete(s.subscribers, ch)
+}
+
+// publish sends the event to all subscribers. Slow subscribers miss events
+// rather than block discovery.
+func (s *DiscoverServer) publish(event *layers.DeviceEvent) {
+	log.Info("Device %s: serial: %s", event.Event, event.Serial)
+	s.subMutex.Lock()
+	defer s.subMutex.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Warning("Dropping device event for slow subscriber: serial: %s", event.Serial)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package discover
+
+import (
+	"io"
+	"net"
+
+	"github.com/google/gopacket"
+	gopacketlayers "github.com/google/gopacket/layers"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+// ReadCapture parses MLDP frames from a pcap or pcapng capture and returns descriptions
+// in the order they were captured. Both MLDP sent over UDP to DiscoverPort and raw LLDP
+// frames are recognised. The timestamp is the capture time; the source is only known
+// for MLDP over UDP.
+func ReadCapture(r io.Reader) ([]*layers.DeviceDescription, error) {
+	source, err := srv.NewCaptureSource(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptions []*layers.DeviceDescription
+	for {
+		packet, err := source.NextPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		dd := decodeCaptured(packet)
+		if dd == nil {
+			continue
+		}
+		dd.Timestamp = uint64(packet.Metadata().Timestamp.UnixNano()) / 1000000
+		descriptions = append(descriptions, dd)
+	}
+	return descriptions, nil
+}
+
+// decodeCaptured returns the description from a raw LLDP frame or from the payload
+// of a UDP datagram sent to DiscoverPort
+func decodeCaptured(packet gopacket.Packet) *layers.DeviceDescription {
+	if dd := DecodeDescription(packet); dd != nil {
+		return dd
+	}
+	udpLayer := packet.Layer(gopacketlayers.LayerTypeUDP)
+	if udpLayer == nil {
+		return nil
+	}
+	udp := udpLayer.(*gopacketlayers.UDP)
+	if udp.DstPort != DiscoverPort {
+		return nil
+	}
+	dd := DecodeDescription(gopacket.NewPacket(udp.Payload, gopacketlayers.LayerTypeLinkLayerDiscovery, gopacket.Default))
+	if dd == nil {
+		return nil
+	}
+	if network := packet.NetworkLayer(); network != nil {
+		dd.SetSource(&net.UDPAddr{IP: net.IP(network.NetworkFlow().Src().Raw()), Port: int(udp.SrcPort)})
+	}
+	return dd
+}
@@ -0,0 +1,93 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package discover
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/imroc/req"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+)
+
+// GetDiscoveredDevices requests descriptions of discovered devices from the discover server
+func GetDiscoveredDevices(cfg *config.Config) ([]*layers.DeviceDescription, error) {
+	r, err := req.Get(fmt.Sprintf("http://%s:%d/api/devices", cfg.IP, ApiPort))
+	if err != nil {
+		return nil, err
+	}
+	if r.Response().StatusCode != 200 {
+		return nil, errors.New(r.Response().Status)
+	}
+	var descriptions []*layers.DeviceDescription
+	if err = r.ToJSON(&descriptions); err != nil {
+		return nil, err
+	}
+	return descriptions, nil
+}
+
+// MatchSerial returns true if the description has the serial declared for the device
+func MatchSerial(dd *layers.DeviceDescription, device *config.Device) bool {
+	serial, ok, err := device.SerialID()
+	if err != nil || !ok {
+		return false
+	}
+	return dd.SerialID == serial || dd.SerialNumber == device.Serial
+}
+
+// ResolveDeviceIPs sets IPs of devices declared by serial number to the addresses
+// the discover server has seen them at. Only online devices are used, so an IP is not
+// resolved to an address the device has left. Configured IPs are kept if the discover
+// server is not available, but every device must end up with an IP.
+func ResolveDeviceIPs(cfg *config.Config) error {
+	descriptions, err := GetDiscoveredDevices(cfg)
+	if err != nil {
+		log.Warning("Unable to resolve device IPs from the discover server: %s", err)
+	}
+	for _, device := range cfg.Devices {
+		if _, _, err := device.SerialID(); err != nil {
+			return err
+		}
+		for _, dd := range descriptions {
+			if !dd.Online || !MatchSerial(dd, device) {
+				continue
+			}
+			ip := dd.MasterIP
+			if ip == nil || ip.IsUnspecified() {
+				ip = dd.Address
+			}
+			if ip == nil || ip.IsUnspecified() {
+				log.Warning("Discovered device has no IP: device: %s serial: %s", device.Name, device.Serial)
+				continue
+			}
+			if device.IP == nil || !device.IP.Equal(ip) {
+				log.Warning("Device IP is resolved by serial: device: %s serial: %s ip: %s configured: %s", device.Name, device.Serial, ip, device.IP)
+				resolved := net.IP(ip)
+				device.IP = &resolved
+			}
+			break
+		}
+	}
+	for _, device := range cfg.Devices {
+		if device.IP == nil {
+			return fmt.Errorf("IP of device %s is neither configured nor discovered", device.Name)
+		}
+	}
+	return nil
+}
@@ -18,6 +18,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"time"
 
 	"go.etcd.io/bbolt"
 	"sigs.k8s.io/yaml"
@@ -25,16 +27,24 @@ import (
 	"jinr.ru/greenlab/go-adc/pkg/config"
 	"jinr.ru/greenlab/go-adc/pkg/layers"
 	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
 )
 
 const (
 	BucketPrefix         = "discover_"
 	DeviceDescriptionKey = "device_description"
+	DeviceHistoryKey     = "history"
+	// DeviceHistorySize is the maximum number of events kept per device
+	DeviceHistorySize = 1000
+	// StateOpenTimeout is how long we wait for the discover database to be unlocked
+	StateOpenTimeout = 1 * time.Second
 )
 
 type State struct {
 	context.Context
 	DB *bbolt.DB
+	// offlineTimeout is how long (milliseconds) a device may be silent before it is considered offline
+	offlineTimeout uint64
 }
 
 func NewState(ctx context.Context, cfg *config.Config) (*State, error) {
@@ -44,8 +54,23 @@ func NewState(ctx context.Context, cfg *config.Config) (*State, error) {
 		return nil, err
 	}
 	return &State{
-		Context: ctx,
-		DB:      db,
+		Context:        ctx,
+		DB:             db,
+		offlineTimeout: uint64(cfg.GetDiscoverOfflineTimeout()) * 1000,
+	}, nil
+}
+
+// NewReadOnlyState opens the discover database for reading. It fails after StateOpenTimeout
+// if the database is locked, e.g. by the running discover server.
+func NewReadOnlyState(ctx context.Context, cfg *config.Config) (*State, error) {
+	db, err := bbolt.Open(cfg.DiscoverDBPath(), 0600, &bbolt.Options{ReadOnly: true, Timeout: StateOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	return &State{
+		Context:        ctx,
+		DB:             db,
+		offlineTimeout: uint64(cfg.GetDiscoverOfflineTimeout()) * 1000,
 	}, nil
 }
 
@@ -100,29 +125,25 @@ func (s *State) SetDeviceDescription(dd *layers.DeviceDescription) error {
 	return nil
 }
 
-// GetDeviceDescription ...
-func (s *State) GetDeviceDescription(serialNumber string) (string, uint64, error) {
+// GetDeviceDescription returns the description of the device with the given serial number
+func (s *State) GetDeviceDescription(serialNumber string) (*layers.DeviceDescription, error) {
 	log.Debug("Getting device description: device: %s", serialNumber)
-	var description string
-	var timestamp uint64
+	dd := &layers.DeviceDescription{}
 	if err := s.DB.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(BucketName(serialNumber)))
 		if b == nil {
-			return errors.New(fmt.Sprintf("Bucket not found: %s", BucketName(serialNumber)))
+			return srv.ErrNotFound{What: fmt.Sprintf("device %s", serialNumber)}
 		}
 		ddBytes := b.Get([]byte(DeviceDescriptionKey))
 		if ddBytes == nil {
-			return errors.New(fmt.Sprintf("Description not found"))
-		}
-		var dd *layers.DeviceDescription
-		if err := yaml.Unmarshal(ddBytes, dd); err != nil {
-			return err
+			return srv.ErrNotFound{What: fmt.Sprintf("description of device %s", serialNumber)}
 		}
-		return nil
+		return yaml.Unmarshal(ddBytes, dd)
 	}); err != nil {
-		return "", 0, err
+		return nil, err
 	}
-	return description, timestamp, nil
+	s.setOnline(dd)
+	return dd, nil
 }
 
 // GetAllDeviceDescriptions ...
@@ -140,6 +161,7 @@ func (s *State) GetAllDeviceDescriptions() ([]*layers.DeviceDescription, error)
 				log.Error("Error while unmarshalling DeviceDescription %s\n", err)
 				return err
 			}
+			s.setOnline(dd)
 			devices = append(devices, dd)
 			return nil
 		})
@@ -149,3 +171,172 @@ func (s *State) GetAllDeviceDescriptions() ([]*layers.DeviceDescription, error)
 	}
 	return devices, nil
 }
+
+// setOnline marks the device offline if it has not been seen for the offline timeout,
+// even if the discover server has not done it yet, e.g. because it is not running
+func (s *State) setOnline(dd *layers.DeviceDescription) {
+	dd.Online = dd.Online && srv.Now()-dd.Timestamp <= s.offlineTimeout
+}
+
+func getDescription(b *bbolt.Bucket) (*layers.DeviceDescription, error) {
+	ddBytes := b.Get([]byte(DeviceDescriptionKey))
+	if ddBytes == nil {
+		return nil, nil
+	}
+	dd := &layers.DeviceDescription{}
+	if err := yaml.Unmarshal(ddBytes, dd); err != nil {
+		return nil, err
+	}
+	return dd, nil
+}
+
+func putDescription(b *bbolt.Bucket, dd *layers.DeviceDescription) error {
+	ddBytes, err := yaml.Marshal(dd)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(DeviceDescriptionKey), ddBytes)
+}
+
+// appendEvent adds the event to the device history keeping at most DeviceHistorySize events
+func appendEvent(b *bbolt.Bucket, event *layers.DeviceEvent) error {
+	var history []*layers.DeviceEvent
+	if data := b.Get([]byte(DeviceHistoryKey)); data != nil {
+		if err := yaml.Unmarshal(data, &history); err != nil {
+			return err
+		}
+	}
+	history = append(history, event)
+	if len(history) > DeviceHistorySize {
+		history = history[len(history)-DeviceHistorySize:]
+	}
+	data, err := yaml.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(DeviceHistoryKey), data)
+}
+
+// UpdateDeviceDescription saves the description of the device which has just been seen.
+// It returns the event if the device has appeared or its description has changed.
+func (s *State) UpdateDeviceDescription(dd *layers.DeviceDescription) (*layers.DeviceEvent, error) {
+	log.Debug("Updating device description: device: %s", dd.SerialNumber)
+	var event *layers.DeviceEvent
+	if err := s.DB.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BucketName(dd.SerialNumber)))
+		if err != nil {
+			return err
+		}
+		prev, err := getDescription(b)
+		if err != nil {
+			return err
+		}
+		dd.Online = true
+		dd.FirstSeen = dd.Timestamp
+		switch {
+		case prev == nil:
+			event = &layers.DeviceEvent{Event: layers.DeviceAppeared, Changes: Diff(&layers.DeviceDescription{}, dd)}
+		case !prev.Online:
+			event = &layers.DeviceEvent{Event: layers.DeviceAppeared, Changes: Diff(prev, dd)}
+		default:
+			if changes := Diff(prev, dd); len(changes) > 0 {
+				event = &layers.DeviceEvent{Event: layers.DeviceChanged, Changes: changes}
+			}
+		}
+		if prev != nil && prev.FirstSeen != 0 {
+			dd.FirstSeen = prev.FirstSeen
+		}
+		if err = putDescription(b, dd); err != nil {
+			return err
+		}
+		if event == nil {
+			return nil
+		}
+		event.Timestamp = dd.Timestamp
+		event.Serial = dd.SerialNumber
+		return appendEvent(b, event)
+	}); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// MarkOffline marks devices which have not been seen for the offline timeout as offline
+// and returns the events for them
+func (s *State) MarkOffline() ([]*layers.DeviceEvent, error) {
+	var events []*layers.DeviceEvent
+	now := srv.Now()
+	if err := s.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			dd, err := getDescription(b)
+			if err != nil || dd == nil {
+				return err
+			}
+			if !dd.Online || now-dd.Timestamp <= s.offlineTimeout {
+				return nil
+			}
+			dd.Online = false
+			if err = putDescription(b, dd); err != nil {
+				return err
+			}
+			event := &layers.DeviceEvent{Timestamp: now, Serial: dd.SerialNumber, Event: layers.DeviceDisappeared}
+			events = append(events, event)
+			return appendEvent(b, event)
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetDeviceHistory returns events of the device with the given serial number, the oldest first
+func (s *State) GetDeviceHistory(serialNumber string) ([]*layers.DeviceEvent, error) {
+	history := []*layers.DeviceEvent{}
+	if err := s.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(BucketName(serialNumber)))
+		if b == nil {
+			return srv.ErrNotFound{What: fmt.Sprintf("device %s", serialNumber)}
+		}
+		if data := b.Get([]byte(DeviceHistoryKey)); data != nil {
+			return yaml.Unmarshal(data, &history)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func ipString(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return ip.String()
+}
+
+// Diff returns the fields which differ in two descriptions of the same device.
+// Timestamps and the source port are not compared.
+func Diff(prev, dd *layers.DeviceDescription) []*layers.FieldChange {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"masterIP", ipString(prev.MasterIP), ipString(dd.MasterIP)},
+		{"masterMac", prev.MasterMac.String(), dd.MasterMac.String()},
+		{"mstreamIP", ipString(prev.MStreamIP), ipString(dd.MStreamIP)},
+		{"mstreamMac", prev.MStreamMac.String(), dd.MStreamMac.String()},
+		{"address", ipString(prev.Address), ipString(dd.Address)},
+		{"chassisSlot", fmt.Sprint(prev.ChassisSlot), fmt.Sprint(dd.ChassisSlot)},
+		{"hardwareRevision", prev.HardwareRevision, dd.HardwareRevision},
+		{"firmwareRevision", prev.FirmwareRevision, dd.FirmwareRevision},
+		{"masterLocked", fmt.Sprint(prev.MasterLocked), fmt.Sprint(dd.MasterLocked)},
+		{"mstreamLocked", fmt.Sprint(prev.MStreamLocked), fmt.Sprint(dd.MStreamLocked)},
+	}
+	var changes []*layers.FieldChange
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, &layers.FieldChange{Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	return changes
+}
@@ -16,6 +16,7 @@ package srv
 
 import (
 	"fmt"
+	"strings"
 )
 
 // ErrGetAddr returned when we can not get the address and port of the device that sent a packet
@@ -51,3 +52,119 @@ type ErrDeviceNotFound struct {
 func (e ErrDeviceNotFound) Error() string {
 	return fmt.Sprintf("Device not found: %s", e.What)
 }
+
+// ErrResponseTimeout returned when a device does not respond to a request in time
+type ErrResponseTimeout struct {
+	Device string
+	Seq    uint16
+}
+
+func (e ErrResponseTimeout) Error() string {
+	return fmt.Sprintf("Timeout while waiting for response: device: %s seq: %d", e.Device, e.Seq)
+}
+
+// ErrUnexpectedResponse returned when a device response does not contain expected layer
+type ErrUnexpectedResponse struct {
+	Device string
+	Seq    uint16
+}
+
+func (e ErrUnexpectedResponse) Error() string {
+	return fmt.Sprintf("Unexpected response: device: %s seq: %d", e.Device, e.Seq)
+}
+
+// ErrNotFound returned when there is no requested object in the state database
+type ErrNotFound struct {
+	What string
+}
+
+func (e ErrNotFound) Error() string {
+	return fmt.Sprintf("Not found: %s", e.What)
+}
+
+// ErrDrainTimeout returned when the mstream pipeline is not drained in time
+type ErrDrainTimeout struct {
+	Pending int
+}
+
+func (e ErrDrainTimeout) Error() string {
+	return fmt.Sprintf("Timeout while draining mstream pipeline: pending: %d", e.Pending)
+}
+
+// ErrRunState returned when a run action is not allowed in the current run state
+type ErrRunState struct {
+	Action string
+	State  string
+}
+
+func (e ErrRunState) Error() string {
+	return fmt.Sprintf("Run can not %s in state: %s", e.Action, e.State)
+}
+
+// ErrNotPersisting returned when data files are required but the mstream server does not persist data
+type ErrNotPersisting struct{}
+
+func (e ErrNotPersisting) Error() string {
+	return fmt.Sprintf("MStream data is not being persisted")
+}
+
+// ErrSyncStart returned when some devices have not started at the requested time
+type ErrSyncStart struct {
+	Devices []string
+}
+
+func (e ErrSyncStart) Error() string {
+	return fmt.Sprintf("Devices have not started: %s", strings.Join(e.Devices, ", "))
+}
+
+// ErrLeased returned when a device or a crate is leased by another owner
+type ErrLeased struct {
+	What  string
+	Owner string
+}
+
+func (e ErrLeased) Error() string {
+	return fmt.Sprintf("Leased by another owner: %s owner: %s", e.What, e.Owner)
+}
+
+// ErrLeaseRequired returned when a device is changed without holding its lease
+type ErrLeaseRequired struct {
+	Device string
+}
+
+func (e ErrLeaseRequired) Error() string {
+	return fmt.Sprintf("Lease is required to change the device: %s", e.Device)
+}
+
+// ErrSerialMismatch returned when the device at the configured IP has another serial number
+type ErrSerialMismatch struct {
+	Device   string
+	Source   string
+	Expected string
+	Actual   string
+}
+
+func (e ErrSerialMismatch) Error() string {
+	return fmt.Sprintf("Serial mismatch: device: %s source: %s expected: %s actual: %s", e.Device, e.Source, e.Expected, e.Actual)
+}
+
+// ErrAddressConflict returned when more than one online device is discovered at the IP of the device
+type ErrAddressConflict struct {
+	Device  string
+	IP      string
+	Serials []string
+}
+
+func (e ErrAddressConflict) Error() string {
+	return fmt.Sprintf("Several devices are discovered at the IP: device: %s ip: %s serials: %s", e.Device, e.IP, strings.Join(e.Serials, ", "))
+}
+
+// ErrAlarmRule returned when an alarm rule in config is wrong
+type ErrAlarmRule struct {
+	Rule   string
+	Reason string
+}
+
+func (e ErrAlarmRule) Error() string {
+	return fmt.Sprintf("Wrong alarm rule: rule: %s reason: %s", e.Rule, e.Reason)
+}
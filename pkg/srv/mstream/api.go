@@ -51,7 +51,10 @@ import (
 	"github.com/gorilla/mux"
 	"jinr.ru/greenlab/go-adc/pkg/config"
 	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
@@ -59,6 +62,8 @@ const (
 	SwaggerBasePath = "/"
 	SwaggerPath     = "swagger"
 	SwaggerSpec     = "swagger.json"
+	// DrainTimeoutDefault is used when the drain request does not define the timeout
+	DrainTimeoutDefault = 10 * time.Second
 )
 
 // Success response
@@ -82,6 +87,13 @@ type ReqBadRequest struct {
 type Persist struct {
 	Dir        string
 	FilePrefix string
+	// RunNumber is used to name files if it is not zero
+	RunNumber uint32 `json:",omitempty"`
+}
+
+// MetadataFile is the path of the written run metadata
+type MetadataFile struct {
+	File string
 }
 
 type ApiServer struct {
@@ -132,13 +144,43 @@ func (s *ApiServer) configureRouter() error {
 	// swagger:operation GET /flush mstream getFlush
 	// ---
 	// summary: flush mstream
-	// description: --
+	// description: closes data files and returns their sizes and checksums
 	// responses:
 	//   "200":
 	//     "$ref": "#/responses/okResp"
 	//   "400":
 	//     "$ref": "#/responses/badReq"
 	subRouter.HandleFunc("/flush", s.handleFlush()).Methods("GET")
+	// swagger:operation POST /drain mstream postDrain
+	// ---
+	// summary: wait until all received data is written
+	// description: timeout query parameter is in milliseconds
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	//   "504":
+	//     description: pipeline is not drained in time
+	subRouter.HandleFunc("/drain", s.handleDrain()).Methods("POST")
+	// swagger:operation GET /stats mstream getStats
+	// ---
+	// summary: stream counters of all devices since files were opened
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	subRouter.HandleFunc("/stats", s.handleStats()).Methods("GET")
+	// swagger:operation POST /metadata mstream postMetadata
+	// ---
+	// summary: write run metadata next to data files
+	// responses:
+	//   "200":
+	//     "$ref": "#/responses/okResp"
+	//   "400":
+	//     "$ref": "#/responses/badReq"
+	//   "409":
+	//     description: data is not being persisted
+	subRouter.HandleFunc("/metadata", s.handleMetadata()).Methods("POST")
 
 	s.Router.Handle("/swagger.json", s.getSwaggerSpecHandler()).Methods("GET")
 	s.Router.Handle("/swagger", s.getSwaggerUIHandler()).Methods("GET")
@@ -154,15 +196,66 @@ func (s *ApiServer) handlePersist() http.HandlerFunc {
 			return
 		}
 
-		log.Debug("Handling persist request: filePrefix: %s", persist.FilePrefix)
-		s.mstream.Persist(persist.Dir, persist.FilePrefix)
+		log.Debug("Handling persist request: filePrefix: %s run: %d", persist.FilePrefix, persist.RunNumber)
+		s.mstream.Persist(persist.Dir, persist.FilePrefix, persist.RunNumber)
 	}
 }
 
 func (s *ApiServer) handleFlush() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Debug("Handling flush request")
-		s.mstream.Flush()
+		json.NewEncoder(w).Encode(s.mstream.Flush())
+	}
+}
+
+func (s *ApiServer) handleDrain() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := DrainTimeoutDefault
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			ms, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+		log.Debug("Handling drain request: timeout: %s", timeout)
+		if err := s.mstream.Drain(timeout); err != nil {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+	}
+}
+
+func (s *ApiServer) handleStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("Handling stats request")
+		json.NewEncoder(w).Encode(s.mstream.GetStats())
+	}
+}
+
+func (s *ApiServer) handleMetadata() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metadata := &RunMetadata{}
+		err := json.NewDecoder(r.Body).Decode(metadata)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Debug("Handling metadata request")
+		file, err := s.mstream.WriteMetadata(metadata)
+		if err != nil {
+			switch err.(type) {
+			case srv.ErrNotPersisting:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(&MetadataFile{File: file})
 	}
 }
 
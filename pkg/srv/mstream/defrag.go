@@ -192,6 +192,8 @@ type DefragManager struct {
 	fragmentBuilders []*FragmentBuilder
 	FragmentedCh     <-chan *layers.MStreamFragment
 	DefragmentedCh   chan<- *layers.MStreamFragment
+	// ready is closed when fragment builders are initialized
+	ready chan struct{}
 }
 
 func NewDefragManager(
@@ -204,9 +206,15 @@ func NewDefragManager(
 		fragmentBuilders: make([]*FragmentBuilder, 65536), // fragment id is uint16 number, thus 65536
 		FragmentedCh:     fragmentedCh,
 		DefragmentedCh:   defragmentedCh,
+		ready:            make(chan struct{}),
 	}
 }
 
+// Ready returns the channel which is closed when the manager starts handling fragments
+func (m *DefragManager) Ready() <-chan struct{} {
+	return m.ready
+}
+
 func (m *DefragManager) Run() {
 	log.Info("Run defrag manager: %s", m.deviceName)
 	for i := 0; i < 65536; i++ { // fragment id is uint16 number
@@ -216,6 +224,7 @@ func (m *DefragManager) Run() {
 		}(i)
 	}
 	log.Info("Fragment builders initialized: %s", m.deviceName)
+	close(m.ready)
 	var f *layers.MStreamFragment
 	for {
 		f = <-m.FragmentedCh
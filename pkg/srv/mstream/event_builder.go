@@ -44,12 +44,15 @@ type EventBuilder struct {
 	Length  uint32
 
 	DefragmentedCh chan *layers.MStreamFragment
-	writerCh       chan<- []byte
-	seq            <-chan uint32
+	// flushCh receives flush requests, the channel is closed when the open event is closed
+	flushCh  chan chan struct{}
+	writerCh chan<- []byte
+	seq      <-chan uint32
+	stats    *Stats
 }
 
 // NewEvent ...
-func NewEventBuilder(id int, cfg *config.Config, device *config.Device, writerCh chan<- []byte, seq <-chan uint32) *EventBuilder {
+func NewEventBuilder(id int, cfg *config.Config, device *config.Device, writerCh chan<- []byte, seq <-chan uint32, stats *Stats) *EventBuilder {
 	return &EventBuilder{
 		id:              id,
 		cfg:             cfg,
@@ -64,8 +67,10 @@ func NewEventBuilder(id int, cfg *config.Config, device *config.Device, writerCh
 		DataSize:        0,
 		Length:          0,
 		DefragmentedCh:  make(chan *layers.MStreamFragment),
+		flushCh:         make(chan chan struct{}),
 		writerCh:        writerCh,
 		seq:             seq,
+		stats:           stats,
 	}
 }
 
@@ -96,6 +101,7 @@ func (b *EventBuilder) CloseEvent(persist bool) {
 
 	if b.Trigger == nil {
 		log.Error("Can not close event w/o trigger: %s event: %d", b.device.Name, b.EventNum)
+		inc(&b.stats.Dropped)
 		return
 	}
 	//log.Info("Close event: %s event: %d\n"+
@@ -103,6 +109,7 @@ func (b *EventBuilder) CloseEvent(persist bool) {
 	//	"Trigger channels: %064b", b.deviceName, b.EventNum, b.DataChannels, b.TriggerChannels)
 
 	if !persist {
+		inc(&b.stats.Partial)
 		return
 	}
 
@@ -165,9 +172,11 @@ func (b *EventBuilder) CloseEvent(persist bool) {
 	err := gopacket.SerializeLayers(buf, opts, mpd)
 	if err != nil {
 		log.Error("Error while serializing Mpd layer: %s, event: %d", b.device.Name, b.EventNum)
+		inc(&b.stats.Dropped)
 		return
 	}
 
+	inc(&b.stats.Events)
 	b.writerCh <- buf.Bytes()
 }
 
@@ -205,7 +214,18 @@ func (b *EventBuilder) Run() {
 	b.EventNum = <-b.seq
 	log.Info("Run EventBuilder: %s id: %d", b.device.Name, b.id)
 	for {
-		f := <-b.DefragmentedCh
+		var f *layers.MStreamFragment
+		select {
+		case f = <-b.DefragmentedCh:
+		case done := <-b.flushCh:
+			// complete events are written as soon as they are built, so the open event is incomplete
+			if !b.Free {
+				log.Warning("Flush incomplete event: %s id: %d event: %d", b.device.Name, b.id, b.EventNum)
+				b.CloseEvent(false)
+			}
+			close(done)
+			continue
+		}
 		if f.MStreamPayloadHeader.EventNum >= b.EventNum+NumEventBuildersPerManager {
 			if !b.Free {
 				//log.Info("Force close event: %s id: %d builder event: %d fragment event: %d",
@@ -229,18 +249,32 @@ type EventBuilderManager struct {
 	writerCh       chan<- []byte
 	defragmentedCh <-chan *layers.MStreamFragment
 	seq            chan uint32
+	stats          *Stats
+	flushCh        chan chan struct{}
 }
 
-func NewEventBuilderManager(cfg *config.Config, device *config.Device, defragmentedCh <-chan *layers.MStreamFragment, writerCh chan<- []byte) *EventBuilderManager {
+func NewEventBuilderManager(cfg *config.Config, device *config.Device, defragmentedCh <-chan *layers.MStreamFragment, writerCh chan<- []byte, stats *Stats) *EventBuilderManager {
 	//log.Info("Creating EventBuilderManager: %s", deviceName)
 	return &EventBuilderManager{
 		cfg:            cfg,
 		device:         device,
 		writerCh:       writerCh,
 		defragmentedCh: defragmentedCh,
+		stats:          stats,
+		flushCh:        make(chan chan struct{}),
 	}
 }
 
+// Flush requests event builders to close open events after all the fragments received so far
+// are handled. The returned channel is closed when the events are closed.
+func (m *EventBuilderManager) Flush() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		m.flushCh <- done
+	}()
+	return done
+}
+
 func (m *EventBuilderManager) Run() {
 	log.Info("Run EventBuilderManger: %s", m.device.Name)
 	m.seq = make(chan uint32)
@@ -256,7 +290,7 @@ func (m *EventBuilderManager) Run() {
 	m.eventBuilders = []*EventBuilder{}
 	for i := 0; i < NumEventBuildersPerManager; i++ {
 		//log.Info("Creating EventBuilder: %s id: %d", m.deviceName, i)
-		b := NewEventBuilder(i, m.cfg, m.device, m.writerCh, m.seq)
+		b := NewEventBuilder(i, m.cfg, m.device, m.writerCh, m.seq, m.stats)
 		m.eventBuilders = append(m.eventBuilders, b)
 		go func(eventBuilder *EventBuilder) {
 			eventBuilder.Run()
@@ -264,10 +298,19 @@ func (m *EventBuilderManager) Run() {
 	}
 
 	for {
-		f := <-m.defragmentedCh
-		//log.Info("Handling event fragment: device %s event: %d", m.deviceName, f.MStreamPayloadHeader.EventNum)
-		for _, b := range m.eventBuilders {
-			b.DefragmentedCh <- f
+		select {
+		case f := <-m.defragmentedCh:
+			//log.Info("Handling event fragment: device %s event: %d", m.deviceName, f.MStreamPayloadHeader.EventNum)
+			for _, b := range m.eventBuilders {
+				b.DefragmentedCh <- f
+			}
+		case done := <-m.flushCh:
+			for _, b := range m.eventBuilders {
+				builderDone := make(chan struct{})
+				b.flushCh <- builderDone
+				<-builderDone
+			}
+			close(done)
 		}
 	}
 
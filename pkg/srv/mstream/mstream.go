@@ -16,18 +16,26 @@ package mstream
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
 	"path"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
+	"sigs.k8s.io/yaml"
 
 	"jinr.ru/greenlab/go-adc/pkg/config"
 	"jinr.ru/greenlab/go-adc/pkg/layers"
 	"jinr.ru/greenlab/go-adc/pkg/log"
 	"jinr.ru/greenlab/go-adc/pkg/srv"
+	"jinr.ru/greenlab/go-adc/pkg/srv/discover"
 )
 
 const (
@@ -36,6 +44,10 @@ const (
 	WriterChSize       = 100
 	FragmentedChSize   = 3
 	DefragmentedChSize = 3
+	// DrainQuiet is how long all the queues must stay empty for the pipeline to be considered drained
+	DrainQuiet = 200 * time.Millisecond
+	// DrainPollInterval is how often the queues are checked while draining
+	DrainPollInterval = 10 * time.Millisecond
 )
 
 const (
@@ -47,13 +59,28 @@ type MStreamServer struct {
 	api             *ApiServer
 	writerChs       map[string]chan []byte
 	writerStateChs  map[string]chan string
+	writerAckChs    map[string]chan struct{}
 	fragmentedChs   map[string]chan *layers.MStreamFragment
 	defragmentedChs map[string]chan *layers.MStreamFragment
+	eventBuilders   map[string]*EventBuilderManager
+	eventBuildersMu sync.Mutex
+	stats           map[string]*Stats
+	// files being written, empty if data is not persisted
+	files     []string
+	persistMu sync.Mutex
+	// metadata is written next to data files when the run metadata is received
+	metadataFile string
 }
 
 func NewMStreamServer(ctx context.Context, cfg *config.Config) (*MStreamServer, error) {
 	log.Info("Initializing mstream server with address: %s port: %d", cfg.IP, ServerMStreamPort)
 
+	if cfg.Identity != nil && cfg.Identity.ResolveIPs {
+		if err := discover.ResolveDeviceIPs(cfg); err != nil {
+			return nil, err
+		}
+	}
+
 	s := &MStreamServer{
 		Server: srv.Server{
 			Context: ctx,
@@ -61,15 +88,20 @@ func NewMStreamServer(ctx context.Context, cfg *config.Config) (*MStreamServer,
 		},
 		writerChs:       make(map[string]chan []byte),
 		writerStateChs:  make(map[string]chan string),
+		writerAckChs:    make(map[string]chan struct{}),
 		fragmentedChs:   make(map[string]chan *layers.MStreamFragment),
 		defragmentedChs: make(map[string]chan *layers.MStreamFragment),
+		eventBuilders:   make(map[string]*EventBuilderManager),
+		stats:           make(map[string]*Stats),
 	}
 
 	for _, device := range cfg.Devices {
 		s.writerChs[device.Name] = make(chan []byte, WriterChSize)
 		s.writerStateChs[device.Name] = make(chan string)
+		s.writerAckChs[device.Name] = make(chan struct{})
 		s.fragmentedChs[device.Name] = make(chan *layers.MStreamFragment, FragmentedChSize)
 		s.defragmentedChs[device.Name] = make(chan *layers.MStreamFragment, DefragmentedChSize)
+		s.stats[device.Name] = &Stats{}
 	}
 
 	apiServer, err := NewApiServer(ctx, cfg, s)
@@ -108,55 +140,7 @@ func (s *MStreamServer) Run() error {
 		if errResolve != nil {
 			return errResolve
 		}
-		defragManager := NewDefragManager(deviceName, s.fragmentedChs[deviceName], s.defragmentedChs[deviceName])
-		eventBuilderManager := NewEventBuilderManager(s.Config, device, s.defragmentedChs[deviceName], s.writerChs[deviceName])
-
-		// Run mpd writers
-		go func(writerStateCh <-chan string, writerCh <-chan []byte) {
-			currentFilename := ""
-			writer := io.Discard
-			for {
-				select {
-				case filename := <-writerStateCh:
-
-					if currentFilename != "" {
-						w := writer.(*Writer)
-						w.Flush()
-					}
-					if filename == "" {
-						writer = io.Discard
-					} else {
-						w, newWriterErr := NewWriter(filename)
-						if newWriterErr != nil {
-							log.Error("Error while creating writer: %s", newWriterErr)
-							continue
-						}
-						writer = w
-					}
-					currentFilename = filename
-				default:
-				}
-				select {
-				case bytes := <-writerCh:
-					_, writeErr := writer.Write(bytes)
-					if writeErr != nil {
-						log.Error("Error while writing to file: %s", writeErr)
-					}
-				default:
-					time.Sleep(10 * time.Millisecond)
-				}
-			}
-		}(s.writerStateChs[deviceName], s.writerChs[deviceName])
-
-		// Run event builders
-		go func(eventBuilderManager *EventBuilderManager) {
-			eventBuilderManager.Run()
-		}(eventBuilderManager)
-
-		// Run defragmenter manager
-		go func(defragManager *DefragManager) {
-			defragManager.Run()
-		}(defragManager)
+		s.runPipeline(device)
 
 		// This magic is necessary to make it fast
 		counterCh := make(chan int)
@@ -171,12 +155,8 @@ func (s *MStreamServer) Run() error {
 		}(counterCh)
 
 		// Run parsers
-		go func(deviceName string, conn *net.UDPConn, udpAddr *net.UDPAddr, fragmentedCh chan<- *layers.MStreamFragment, counterCh chan<- int) {
+		go func(deviceName string, conn *net.UDPConn, udpAddr *net.UDPAddr, fragmentedCh chan<- *layers.MStreamFragment, counterCh chan<- int, stats *Stats) {
 			buffer := make([]byte, InputBufferSize)
-			decodeOptions := gopacket.DecodeOptions{
-				Lazy:   false,
-				NoCopy: true,
-			}
 
 			for {
 				length, _, readErr := conn.ReadFromUDP(buffer)
@@ -189,39 +169,32 @@ func (s *MStreamServer) Run() error {
 				data := make([]byte, length)
 				copy(data, buffer[:length])
 
-				packet := gopacket.NewPacket(data, layers.MLinkLayerType, decodeOptions)
-
 				var mlSeq uint16
 				var mlSrc uint16
 				var mlDst uint16
-				mlinkLayer := packet.Layer(layers.MLinkLayerType)
-				if mlinkLayer != nil {
-					ml := mlinkLayer.(*layers.MLinkLayer)
+				ml, fragments := decodeFragments(data)
+				if ml != nil {
 					mlSeq = ml.Seq
 					mlSrc = ml.Src
 					mlDst = ml.Dst
 				}
 
-				mstreamLayer := packet.Layer(layers.MStreamLayerType)
-				if mstreamLayer != nil {
-					ms := mstreamLayer.(*layers.MStreamLayer)
+				for _, f := range fragments {
+					//log.Info("Handling fragment: %s fragment id: %04x offset: %d length: %d last: %t",
+					//	deviceName, f.FragmentID, f.FragmentLength, f.FragmentOffset, f.LastFragment())
 
-					for _, f := range ms.Fragments {
-						//log.Info("Handling fragment: %s fragment id: %04x offset: %d length: %d last: %t",
-						//	deviceName, f.FragmentID, f.FragmentLength, f.FragmentOffset, f.LastFragment())
+					inc(&stats.Fragments)
+					fragmentedCh <- f
 
-						fragmentedCh <- f
-
-						ackErr := SendAck(mlDst, mlSrc, mlSeq, f.FragmentID, f.FragmentOffset, udpAddr, conn)
-						if ackErr != nil {
-							log.Error("Error while sending fragment ack: %s udpAddr: %s id: %04x offset: %d length: %d last: %t",
-								deviceName, udpAddr, f.FragmentID, f.FragmentOffset, f.FragmentLength, f.LastFragment())
-						}
+					ackErr := SendAck(mlDst, mlSrc, mlSeq, f.FragmentID, f.FragmentOffset, udpAddr, conn)
+					if ackErr != nil {
+						inc(&stats.AckErrors)
+						log.Error("Error while sending fragment ack: %s udpAddr: %s id: %04x offset: %d length: %d last: %t",
+							deviceName, udpAddr, f.FragmentID, f.FragmentOffset, f.FragmentLength, f.LastFragment())
 					}
 				}
-
 			}
-		}(deviceName, conn, udpAddr, s.fragmentedChs[deviceName], counterCh)
+		}(deviceName, conn, udpAddr, s.fragmentedChs[deviceName], counterCh, s.stats[deviceName])
 
 		// connect to device
 		errAck := SendAck(layers.MLinkDeviceAddr, 1, 0, 0xffff, 0xffff, udpAddr, conn)
@@ -244,6 +217,93 @@ func (s *MStreamServer) Run() error {
 	}
 }
 
+// runPipeline starts the writer, the event builders and the defragmenter of the device.
+// Fragments put to the fragmented channel of the device end up in its data file.
+// The returned channel is closed when the pipeline is ready to handle fragments.
+func (s *MStreamServer) runPipeline(device *config.Device) <-chan struct{} {
+	deviceName := device.Name
+	defragManager := NewDefragManager(deviceName, s.fragmentedChs[deviceName], s.defragmentedChs[deviceName])
+	eventBuilderManager := NewEventBuilderManager(s.Config, device, s.defragmentedChs[deviceName], s.writerChs[deviceName], s.stats[deviceName])
+	s.eventBuildersMu.Lock()
+	s.eventBuilders[deviceName] = eventBuilderManager
+	s.eventBuildersMu.Unlock()
+
+	// Run mpd writers
+	go func(writerStateCh <-chan string, writerAckCh chan<- struct{}, writerCh <-chan []byte, stats *Stats) {
+		currentFilename := ""
+		writer := io.Discard
+		for {
+			select {
+			case filename := <-writerStateCh:
+
+				if currentFilename != "" {
+					w := writer.(*Writer)
+					w.Flush()
+				}
+				writer = io.Discard
+				currentFilename = ""
+				if filename != "" {
+					w, newWriterErr := NewWriter(filename)
+					if newWriterErr != nil {
+						log.Error("Error while creating writer: %s", newWriterErr)
+					} else {
+						writer = w
+						currentFilename = filename
+					}
+				}
+				// let Persist/Flush know the previous file is closed
+				writerAckCh <- struct{}{}
+			default:
+			}
+			select {
+			case bytes := <-writerCh:
+				n, writeErr := writer.Write(bytes)
+				if writeErr != nil {
+					log.Error("Error while writing to file: %s", writeErr)
+					inc(&stats.WriteErrors)
+				}
+				if currentFilename != "" {
+					atomic.AddUint64(&stats.Bytes, uint64(n))
+				}
+			default:
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}(s.writerStateChs[deviceName], s.writerAckChs[deviceName], s.writerChs[deviceName], s.stats[deviceName])
+
+	// Run event builders
+	go func(eventBuilderManager *EventBuilderManager) {
+		eventBuilderManager.Run()
+	}(eventBuilderManager)
+
+	// Run defragmenter manager
+	go func(defragManager *DefragManager) {
+		defragManager.Run()
+	}(defragManager)
+
+	return defragManager.Ready()
+}
+
+// decodeFragments decodes the MLink frame and returns its MStream fragments.
+// The MLink layer is nil if the frame can not be decoded.
+func decodeFragments(data []byte) (*layers.MLinkLayer, []*layers.MStreamFragment) {
+	decodeOptions := gopacket.DecodeOptions{
+		Lazy:   false,
+		NoCopy: true,
+	}
+	packet := gopacket.NewPacket(data, layers.MLinkLayerType, decodeOptions)
+
+	var ml *layers.MLinkLayer
+	if mlinkLayer := packet.Layer(layers.MLinkLayerType); mlinkLayer != nil {
+		ml = mlinkLayer.(*layers.MLinkLayer)
+	}
+	mstreamLayer := packet.Layer(layers.MStreamLayerType)
+	if mstreamLayer == nil {
+		return ml, nil
+	}
+	return ml, mstreamLayer.(*layers.MStreamLayer).Fragments
+}
+
 func SendAck(mlSrc, mlDst, mlSeq, fragmentID, fragmentOffset uint16, udpAddr *net.UDPAddr, conn *net.UDPConn) error {
 	ml := &layers.MLinkLayer{}
 	ml.Type = layers.MLinkTypeMStream
@@ -295,18 +355,174 @@ func (s *MStreamServer) persistFilename(dir, prefix, name, suffix string) string
 	return path.Join(dir, filename)
 }
 
-func (s *MStreamServer) Flush() {
+// setWriterFile switches the device writer to the new file and waits until the previous file is closed
+func (s *MStreamServer) setWriterFile(deviceName, filename string) {
+	s.writerStateChs[deviceName] <- filename
+	<-s.writerAckChs[deviceName]
+}
+
+// Flush closes data files and returns their sizes and checksums
+func (s *MStreamServer) Flush() []*layers.RunFile {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
 	for _, device := range s.Config.Devices {
 		log.Info("Flush writer: %s", device.Name)
-		s.writerStateChs[device.Name] <- ""
+		s.setWriterFile(device.Name, "")
+	}
+	var files []*layers.RunFile
+	for _, filename := range s.files {
+		file, err := runFileInfo(filename)
+		if err != nil {
+			log.Error("Error while getting file info: %s", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	if s.metadataFile != "" {
+		if file, err := runFileInfo(s.metadataFile); err == nil {
+			files = append(files, file)
+		}
+	}
+	s.files = nil
+	s.metadataFile = ""
+	return files
+}
+
+// runFileInfo returns the size and the checksum of the file
+func runFileInfo(filename string) (*layers.RunFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
 	}
+	return &layers.RunFile{
+		Path:   filename,
+		Size:   size,
+		Sha256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
 }
 
-func (s *MStreamServer) Persist(dir, filePrefix string) {
-	timestamp := time.Now().In(time.Local).Format("20060102_150405")
+// Persist opens new files for all devices. Files are named by the run number if it is given
+// and by the current time otherwise.
+func (s *MStreamServer) Persist(dir, filePrefix string, runNumber uint32) {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	suffix := time.Now().In(time.Local).Format("20060102_150405")
+	if runNumber > 0 {
+		suffix = fmt.Sprintf("run%06d", runNumber)
+	}
+	s.files = nil
 	for _, device := range s.Config.Devices {
 		log.Info("Persist writer: %s", device.Name)
-		filename := s.persistFilename(dir, filePrefix, device.Name, timestamp)
-		s.writerStateChs[device.Name] <- filename
+		filename := s.persistFilename(dir, filePrefix, device.Name, suffix)
+		s.setWriterFile(device.Name, filename)
+		s.stats[device.Name].Reset()
+		s.files = append(s.files, filename)
+	}
+	s.metadataFile = s.metadataFilename(dir, filePrefix, suffix)
+}
+
+func (s *MStreamServer) metadataFilename(dir, prefix, suffix string) string {
+	filename := fmt.Sprintf("%s.meta.yaml", suffix)
+	if prefix != "" {
+		filename = fmt.Sprintf("%s_%s", prefix, filename)
+	}
+	return path.Join(dir, filename)
+}
+
+// GetStats returns counters of all devices
+func (s *MStreamServer) GetStats() map[string]*Stats {
+	stats := make(map[string]*Stats)
+	for name, st := range s.stats {
+		stats[name] = st.Get()
+	}
+	return stats
+}
+
+// WriteMetadata completes the run metadata with stream counters and data file names
+// and writes it next to data files. It can be called several times during the run,
+// the file is overwritten every time.
+func (s *MStreamServer) WriteMetadata(metadata *RunMetadata) (string, error) {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	if s.metadataFile == "" {
+		return "", srv.ErrNotPersisting{}
+	}
+	for _, device := range metadata.Devices {
+		if st, ok := s.stats[device.Name]; ok {
+			device.Stream = st.Get()
+		}
+	}
+	metadata.Files = s.files
+	data, err := yaml.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	log.Info("Writing run metadata: %s", s.metadataFile)
+	if err = ioutil.WriteFile(s.metadataFile, data, 0644); err != nil {
+		return "", err
+	}
+	return s.metadataFile, nil
+}
+
+// pending returns the number of fragments and events which are queued but not yet written
+func (s *MStreamServer) pending() int {
+	count := 0
+	for _, device := range s.Config.Devices {
+		count += len(s.fragmentedChs[device.Name])
+		count += len(s.defragmentedChs[device.Name])
+		count += len(s.writerChs[device.Name])
+	}
+	return count
+}
+
+// Drain waits until all the queues between the sockets and the writers stay empty for DrainQuiet,
+// then makes event builders close open events and waits until the writers get everything.
+// It must be called after devices stop streaming and before files are flushed, otherwise
+// the tail of the run is lost. Complete events are written as soon as they are built,
+// so events which are still open are incomplete and counted as partial.
+func (s *MStreamServer) Drain(timeout time.Duration) error {
+	log.Info("Draining mstream pipeline: timeout: %s", timeout)
+	deadline := time.Now().Add(timeout)
+	if err := s.waitQuiet(deadline); err != nil {
+		return err
+	}
+	s.eventBuildersMu.Lock()
+	managers := make([]*EventBuilderManager, 0, len(s.eventBuilders))
+	for _, m := range s.eventBuilders {
+		managers = append(managers, m)
+	}
+	s.eventBuildersMu.Unlock()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for _, m := range managers {
+		select {
+		case <-m.Flush():
+		case <-timer.C:
+			return srv.ErrDrainTimeout{Pending: s.pending()}
+		}
+	}
+	return s.waitQuiet(deadline)
+}
+
+// waitQuiet waits until all the queues stay empty for DrainQuiet
+func (s *MStreamServer) waitQuiet(deadline time.Time) error {
+	quietSince := time.Now()
+	for {
+		now := time.Now()
+		if s.pending() > 0 {
+			quietSince = now
+		} else if now.Sub(quietSince) >= DrainQuiet {
+			return nil
+		}
+		if now.After(deadline) {
+			return srv.ErrDrainTimeout{Pending: s.pending()}
+		}
+		time.Sleep(DrainPollInterval)
 	}
 }
@@ -0,0 +1,151 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package mstream
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/ip4defrag"
+	gopacketlayers "github.com/google/gopacket/layers"
+
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+)
+
+// ReplaySetup defines where replayed data is written and how fast the capture is replayed
+type ReplaySetup struct {
+	Dir        string
+	FilePrefix string
+	// RunNumber is used in file names instead of the current time if it is given
+	RunNumber uint32
+	// Speed is how many times faster than captured datagrams are fed to the pipeline.
+	// Zero means as fast as possible. Timing affects event building the same way
+	// as on the wire, e.g. fragments of late events force closing incomplete ones.
+	Speed        float64
+	DrainTimeout time.Duration
+}
+
+// ReplayResult describes the replayed capture
+type ReplayResult struct {
+	// Packets is the number of MStream datagrams sent by configured devices
+	Packets uint64
+	// Unknown is the number of MStream datagrams sent by devices which are not configured
+	Unknown uint64
+	Files   []*layers.RunFile
+	Stats   map[string]*Stats
+}
+
+// Replay feeds MStream traffic captured in a pcap or pcapng file through the same pipeline
+// as received from devices and writes data files. Datagrams are assigned to devices
+// by the source IP, ACKs are not sent.
+func (s *MStreamServer) Replay(r io.Reader, setup *ReplaySetup) (*ReplayResult, error) {
+	source, err := srv.NewCaptureSource(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the live server is ready long before devices start streaming, the replay
+	// must not feed fragments earlier either, otherwise they are handled in a burst
+	for _, device := range s.Config.Devices {
+		<-s.runPipeline(device)
+	}
+	s.Persist(setup.Dir, setup.FilePrefix, setup.RunNumber)
+
+	result := &ReplayResult{}
+	unknown := make(map[string]bool)
+	defragmenter := ip4defrag.NewIPv4Defragmenter()
+	var started, firstCaptured time.Time
+	for {
+		packet, err := source.NextPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Flush()
+			return nil, err
+		}
+
+		udp, srcIP := replayDatagram(packet, defragmenter)
+		if udp == nil || udp.SrcPort != DeviceMStreamPort {
+			continue
+		}
+		device, err := s.Config.GetDeviceByIP(srcIP)
+		if err != nil {
+			result.Unknown++
+			if !unknown[srcIP.String()] {
+				unknown[srcIP.String()] = true
+				log.Warning("Skipping MStream datagrams from unknown device: %s", srcIP)
+			}
+			continue
+		}
+		result.Packets++
+
+		if setup.Speed > 0 {
+			captured := packet.Metadata().Timestamp
+			if started.IsZero() {
+				started, firstCaptured = time.Now(), captured
+			}
+			due := started.Add(time.Duration(float64(captured.Sub(firstCaptured)) / setup.Speed))
+			time.Sleep(time.Until(due))
+		}
+
+		// the payload is copied since decoding does not copy the data
+		data := make([]byte, len(udp.Payload))
+		copy(data, udp.Payload)
+		_, fragments := decodeFragments(data)
+		for _, f := range fragments {
+			inc(&s.stats[device.Name].Fragments)
+			s.fragmentedChs[device.Name] <- f
+		}
+	}
+
+	if err = s.Drain(setup.DrainTimeout); err != nil {
+		log.Error("Error while draining mstream pipeline: %s", err)
+	}
+	result.Stats = s.GetStats()
+	result.Files = s.Flush()
+	return result, err
+}
+
+// replayDatagram returns the UDP layer of the captured packet and the source IP.
+// IP fragments are reassembled, nil is returned until the datagram is complete.
+func replayDatagram(packet gopacket.Packet, defragmenter *ip4defrag.IPv4Defragmenter) (*gopacketlayers.UDP, net.IP) {
+	ip4Layer := packet.Layer(gopacketlayers.LayerTypeIPv4)
+	if ip4Layer == nil {
+		return nil, nil
+	}
+	ip4 := ip4Layer.(*gopacketlayers.IPv4)
+	if ip4.Protocol != gopacketlayers.IPProtocolUDP {
+		return nil, nil
+	}
+	reassembled, err := defragmenter.DefragIPv4WithTimestamp(ip4, packet.Metadata().Timestamp)
+	if err != nil {
+		log.Error("Error while reassembling IP fragments: %s", err)
+		return nil, nil
+	}
+	if reassembled == nil {
+		return nil, nil
+	}
+	udpPacket := gopacket.NewPacket(reassembled.Payload, gopacketlayers.LayerTypeUDP, gopacket.Default)
+	udpLayer := udpPacket.Layer(gopacketlayers.LayerTypeUDP)
+	if udpLayer == nil {
+		return nil, nil
+	}
+	return udpLayer.(*gopacketlayers.UDP), reassembled.SrcIP
+}
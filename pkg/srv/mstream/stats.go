@@ -0,0 +1,84 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package mstream
+
+import (
+	"sync/atomic"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+)
+
+// Stats are counted per device since data files were opened
+type Stats struct {
+	Fragments uint64 `json:"fragments"`
+	Events    uint64 `json:"events"`
+	Bytes     uint64 `json:"bytes"`
+	// Partial events are closed before all data fragments arrive and are not written
+	Partial uint64 `json:"partial"`
+	// Dropped events have no trigger or can not be serialized
+	Dropped     uint64 `json:"dropped"`
+	WriteErrors uint64 `json:"writeErrors"`
+	AckErrors   uint64 `json:"ackErrors"`
+}
+
+func inc(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
+
+// Get returns a consistent copy of counters
+func (st *Stats) Get() *Stats {
+	return &Stats{
+		Fragments:   atomic.LoadUint64(&st.Fragments),
+		Events:      atomic.LoadUint64(&st.Events),
+		Bytes:       atomic.LoadUint64(&st.Bytes),
+		Partial:     atomic.LoadUint64(&st.Partial),
+		Dropped:     atomic.LoadUint64(&st.Dropped),
+		WriteErrors: atomic.LoadUint64(&st.WriteErrors),
+		AckErrors:   atomic.LoadUint64(&st.AckErrors),
+	}
+}
+
+func (st *Stats) Reset() {
+	atomic.StoreUint64(&st.Fragments, 0)
+	atomic.StoreUint64(&st.Events, 0)
+	atomic.StoreUint64(&st.Bytes, 0)
+	atomic.StoreUint64(&st.Partial, 0)
+	atomic.StoreUint64(&st.Dropped, 0)
+	atomic.StoreUint64(&st.WriteErrors, 0)
+	atomic.StoreUint64(&st.AckErrors, 0)
+}
+
+// RunDevice is the metadata of a device taking part in a run
+type RunDevice struct {
+	Name       string                    `json:"name"`
+	Discovered *layers.DeviceDescription `json:"discovered,omitempty"`
+	Start      *layers.RunDeviceState    `json:"start,omitempty"`
+	Stop       *layers.RunDeviceState    `json:"stop,omitempty"`
+	// Stream is filled by the mstream server
+	Stream *Stats `json:"stream,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RunMetadata is saved next to data files and describes how the run was taken
+type RunMetadata struct {
+	Run     *layers.Run    `json:"run"`
+	Config  *config.Config `json:"config"`
+	Devices []*RunDevice   `json:"devices"`
+	// SyncStart is the result of the synchronised start of run devices
+	SyncStart *layers.SyncStartReport `json:"syncStart,omitempty"`
+	// Files are data files of the run, filled by the mstream server
+	Files []string `json:"files,omitempty"`
+}
@@ -0,0 +1,239 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
+
+const (
+	// FwVer and FwRev are the firmware version reported by simulated devices
+	FwVer = 0x0203
+	FwRev = 0x0001
+	// RequestQueueSize is how many received requests may wait to be handled
+	RequestQueueSize = 4096
+	// ReadBufferSize is the socket receive buffer size. The system limit applies.
+	ReadBufferSize = 4 << 20
+)
+
+type request struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// DeviceSimulator answers register and memory requests on behalf of a device.
+// It keeps written values and emulates the run status and the White Rabbit time,
+// so the control server can be exercised without hardware.
+type DeviceSimulator struct {
+	srv.Server
+	Name string
+	mu   sync.Mutex
+	regs map[uint16]uint16
+	mem  map[uint32]uint32
+	// seqs counts received requests by sequence number
+	seqs map[uint16]int
+}
+
+// NewDeviceSimulator creates a simulator listening on the device address from config
+func NewDeviceSimulator(ctx context.Context, cfg *config.Config, deviceName string) (*DeviceSimulator, error) {
+	cfgDevice, err := cfg.GetDeviceByName(deviceName)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Initializing device simulator: device: %s address: %s port: %d", cfgDevice.Name, cfgDevice.IP, control.RegPort)
+
+	uaddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", cfgDevice.IP, control.RegPort))
+	if err != nil {
+		return nil, err
+	}
+
+	// the serial number register contains the lower 16 bits of the serial ID
+	serial, _, err := cfgDevice.SerialID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceSimulator{
+		Server: srv.Server{
+			Context: ctx,
+			Config:  cfg,
+			UDPAddr: uaddr,
+		},
+		Name: cfgDevice.Name,
+		regs: map[uint16]uint16{
+			device.RegMap[device.RegFwVer]:     FwVer,
+			device.RegMap[device.RegFwRev]:     FwRev,
+			device.RegMap[device.RegSerialNum]: uint16(serial),
+		},
+		mem:  make(map[uint32]uint32),
+		seqs: make(map[uint16]int),
+	}, nil
+}
+
+func (s *DeviceSimulator) Run() error {
+	conn, err := net.ListenUDP("udp", s.UDPAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err = conn.SetReadBuffer(ReadBufferSize); err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 2)
+	requests := make(chan request, RequestQueueSize)
+
+	// requests are read as fast as possible, so bursts of writes are not dropped by the socket
+	go func() {
+		for {
+			buffer := make([]byte, 2048)
+			length, addr, readErr := conn.ReadFromUDP(buffer)
+			if readErr != nil {
+				errChan <- readErr
+				return
+			}
+			requests <- request{data: buffer[:length], addr: addr}
+		}
+	}()
+
+	go func() {
+		for req := range requests {
+			response, handleErr := s.handle(req.data)
+			if handleErr != nil {
+				log.Error("Error while handling request: device: %s error: %s", s.Name, handleErr)
+				continue
+			}
+			if _, writeErr := conn.WriteToUDP(response, req.addr); writeErr != nil {
+				errChan <- writeErr
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-s.Context.Done():
+		return nil
+	case err = <-errChan:
+		return err
+	}
+}
+
+// handle decodes the request and returns the response frame
+func (s *DeviceSimulator) handle(data []byte) ([]byte, error) {
+	ml := &layers.MLinkLayer{}
+	if err := ml.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seqs[ml.Seq]++
+
+	switch ml.Type {
+	case layers.MLinkTypeRegRequest:
+		reg := &layers.RegLayer{}
+		if err := reg.DecodeFromBytes(ml.Payload, gopacket.NilDecodeFeedback); err != nil {
+			return nil, err
+		}
+		var words []uint32
+		for _, op := range reg.RegOps {
+			if op.Read {
+				op.Value = s.readReg(op.Addr)
+				words = append(words, 0x80000000|uint32(op.Addr&0x7fff)<<16|uint32(op.Value))
+			} else {
+				s.regs[op.Addr] = op.Value
+				words = append(words, uint32(op.Addr&0x7fff)<<16|uint32(op.Value))
+			}
+		}
+		return response(layers.MLinkTypeRegResponse, ml.Seq, words), nil
+	case layers.MLinkTypeMemRequest:
+		mem := &layers.MemLayer{}
+		if err := mem.DecodeFromBytes(ml.Payload, gopacket.NilDecodeFeedback); err != nil {
+			return nil, err
+		}
+		words := []uint32{binary.LittleEndian.Uint32(ml.Payload[0:4])}
+		for i := uint32(0); i < mem.Size; i++ {
+			if mem.Read {
+				words = append(words, s.mem[mem.Addr+i])
+				continue
+			}
+			if int(i) < len(mem.Data) {
+				s.mem[mem.Addr+i] = mem.Data[i]
+			}
+			words = append(words, s.mem[mem.Addr+i])
+		}
+		return response(layers.MLinkTypeMemResponse, ml.Seq, words), nil
+	default:
+		return nil, srv.ErrUnknownOperation{What: fmt.Sprintf("MLink type: 0x%04x", uint16(ml.Type))}
+	}
+}
+
+// readReg returns the register value, status registers are computed at the time of reading
+func (s *DeviceSimulator) readReg(addr uint16) uint16 {
+	now := uint32(time.Now().Unix())
+	switch addr {
+	case device.RegMap[device.RegAdcTimeSec]:
+		return uint16(now)
+	case device.RegMap[device.RegAdcTimeSec] + 1:
+		return uint16(now >> 16)
+	case device.RegMap[device.RegRunStatus]:
+		ctrl := s.regs[device.RegMap[device.RegDeviceCtrl]]
+		running := ctrl&device.RegDeviceCtrlBitRun != 0
+		if running && ctrl&device.RegDeviceCtrlBitTimedStart != 0 {
+			ts := device.RegMap[device.RegTrigCsrTrigTs]
+			running = now >= uint32(s.regs[ts])|uint32(s.regs[ts+1])<<16
+		}
+		if running {
+			return device.RegRunStatusBitRunning
+		}
+		return 0
+	}
+	return s.regs[addr]
+}
+
+// response builds the MLink frame addressed to the host
+func response(mlType layers.MLinkType, seq uint16, words []uint32) []byte {
+	ml := &layers.MLinkLayer{}
+	ml.Type = mlType
+	ml.Sync = layers.MLinkSync
+	ml.Seq = seq
+	// 3 words for MLink header + 1 word CRC + N words of payload
+	ml.Len = uint16(4 + len(words))
+	ml.Src = layers.MLinkDeviceAddr
+	ml.Dst = layers.MLinkHostAddr
+
+	buf := make([]byte, 12+len(words)*4+4)
+	ml.SerializeHeader(buf[0:12])
+	for i, word := range words {
+		binary.LittleEndian.PutUint32(buf[12+i*4:16+i*4], word)
+	}
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], crc32.ChecksumIEEE(buf[:len(buf)-4]))
+	return buf
+}
@@ -0,0 +1,249 @@
+/*
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     https://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"jinr.ru/greenlab/go-adc/pkg/config"
+	"jinr.ru/greenlab/go-adc/pkg/device"
+	"jinr.ru/greenlab/go-adc/pkg/layers"
+	"jinr.ru/greenlab/go-adc/pkg/log"
+	"jinr.ru/greenlab/go-adc/pkg/srv/control"
+)
+
+const (
+	testDevice = "device_0"
+	testOwner  = "test"
+	// testRegBase is the first scratch register written by the test, it is not used by firmware
+	testRegBase = 0x7f00
+	testWriters = 4
+	testWrites  = 10
+)
+
+var testApi = fmt.Sprintf("http://127.0.0.1:%d/api", control.ApiPort)
+
+func TestMain(m *testing.M) {
+	log.SetLevel("error")
+	os.Exit(m.Run())
+}
+
+// startControl starts a simulated device and the control server talking to it
+func startControl(t *testing.T) (*DeviceSimulator, *control.ControlServer) {
+	t.Setenv("HOME", t.TempDir())
+
+	cfg := config.NewDefaultConfig()
+	ip := net.ParseIP("127.0.0.1")
+	deviceIP := net.ParseIP("127.0.0.2")
+	cfg.IP = &ip
+	cfg.Devices[0].IP = &deviceIP
+	if err := cfg.Persist(true); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	sim, err := NewDeviceSimulator(ctx, cfg, testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl, err := control.NewControlServer(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range []interface{ Run() error }{sim, ctrl} {
+		wg.Add(1)
+		go func(server interface{ Run() error }) {
+			defer wg.Done()
+			if err := server.Run(); err != nil && err != context.Canceled {
+				t.Error(err)
+			}
+		}(server)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(testApi + "/leases")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("API server is not started: %s", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	post(t, "/leases", &layers.LeaseRequest{Device: testDevice, Owner: testOwner, TTL: 600})
+	return sim, ctrl.(*control.ControlServer)
+}
+
+func post(t *testing.T, path string, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	request, err := http.NewRequest(http.MethodPost, testApi+path, bytes.NewReader(data))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	request.Header.Set(control.LeaseOwnerHeader, testOwner)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("POST %s: %s", path, resp.Status)
+	}
+}
+
+func get(t *testing.T, path string, result interface{}) {
+	resp, err := http.Get(testApi + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitSeqs waits until the simulator has received all requests sent so far.
+// Every sequence number must be received exactly once.
+func waitSeqs(t *testing.T, sim *DeviceSimulator, ctrl *control.ControlServer) {
+	next, err := ctrl.NextSeq(testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var missing []uint16
+		sim.mu.Lock()
+		for seq := uint16(0); seq < next; seq++ {
+			switch sim.seqs[seq] {
+			case 0:
+				missing = append(missing, seq)
+			case 1:
+			default:
+				t.Errorf("Sequence number is used %d times: %d", sim.seqs[seq], seq)
+			}
+		}
+		sim.mu.Unlock()
+		if len(missing) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Requests are not received: seqs: %v", missing)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func chBaselineAddr(ch int) uint32 {
+	return device.MemBitSelectCtrl | device.MemMap[device.MemChBaseline] | device.ChBaseMemAddr(ch)
+}
+
+// TestConcurrentWrites issues FIR, channel and register writes to one device concurrently
+// and checks that the device ends up in the state the control server reports.
+func TestConcurrentWrites(t *testing.T) {
+	sim, ctrl := startControl(t)
+
+	var wg sync.WaitGroup
+	for w := 0; w < testWriters; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < testWrites; i++ {
+				coef := make([]int, device.FirCoefNum)
+				for k := range coef {
+					coef[k] = w*testWrites + i + 1
+				}
+				roundoff := uint16(1)
+				post(t, "/fir/"+testDevice, &control.FirSetup{Coef: coef, Roundoff: &roundoff})
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < testWrites; i++ {
+				setup := layers.ChannelsSetup{}
+				for ch := 0; ch < device.Nch; ch++ {
+					setup.Channels = append(setup.Channels, layers.Channel{
+						Id:       ch,
+						En:       true,
+						TrigEn:   true,
+						Baseline: w*testWrites + i + 1,
+					})
+				}
+				post(t, "/channels/"+testDevice, &setup)
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < testWrites; i++ {
+				post(t, "/reg/w/"+testDevice, &control.RegHex{
+					Addr:  fmt.Sprintf("0x%04x", testRegBase+w),
+					Value: fmt.Sprintf("0x%04x", i+1),
+				})
+			}
+		}(w)
+	}
+	wg.Wait()
+	waitSeqs(t, sim, ctrl)
+
+	settings := &layers.DeviceSettings{}
+	get(t, "/settings/"+testDevice, settings)
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	for k, c := range settings.Fir.Coef {
+		if value := sim.regs[device.RegMap[device.RegFirCoefStart]+uint16(k)]; int(value) != c {
+			t.Errorf("FIR coefficient %d differs: device: %d settings: %d", k, value, c)
+		}
+	}
+	for _, ch := range settings.Channels {
+		if value := sim.mem[chBaselineAddr(ch.Id)]; int(value) != ch.Baseline {
+			t.Errorf("Channel %d baseline differs: device: %d settings: %d", ch.Id, value, ch.Baseline)
+		}
+		if ch.Baseline != settings.Channels[0].Baseline {
+			t.Errorf("Channel %d baseline is from another setup: %d expected: %d",
+				ch.Id, ch.Baseline, settings.Channels[0].Baseline)
+		}
+	}
+	for w := 0; w < testWriters; w++ {
+		if value := sim.regs[uint16(testRegBase+w)]; value != testWrites {
+			t.Errorf("Register 0x%04x differs: %d expected: %d", testRegBase+w, value, testWrites)
+		}
+	}
+}